@@ -0,0 +1,92 @@
+package account
+
+import (
+	"errors"
+	"gaming/loginguard"
+	"gaming/model"
+	"gaming/repository"
+	"testing"
+	"time"
+)
+
+func newTestService(t *testing.T) (*Service, repository.Repositories, model.User) {
+	t.Helper()
+	repos := repository.NewMemory()
+	guard := *loginguard.Default
+	guard.Store = loginguard.NewMemoryStore()
+	user := model.User{Name: "Player", Email: "player@example.com", Phone: "+15550100", Password: "hash"}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return NewService(repos.Accounts, &guard), repos, user
+}
+
+func TestScheduleAndCancelDeletion(t *testing.T) {
+	s, _, user := newTestService(t)
+
+	at, err := s.ScheduleDeletion(user.UserID)
+	if err != nil || at.Before(time.Now().Add(GracePeriod-time.Minute)) {
+		t.Fatalf("ScheduleDeletion = %v, %v, want after the grace period", at, err)
+	}
+	if _, err := s.ScheduleDeletion(user.UserID); !errors.Is(err, ErrDeletionScheduled) {
+		t.Errorf("second ScheduleDeletion = %v, want ErrDeletionScheduled", err)
+	}
+	if err := s.CancelDeletion(user.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CancelDeletion(user.UserID); !errors.Is(err, ErrNoDeletionScheduled) {
+		t.Errorf("second CancelDeletion = %v, want ErrNoDeletionScheduled", err)
+	}
+}
+
+func TestAnonymizeDue(t *testing.T) {
+	s, repos, user := newTestService(t)
+	session := model.Session{JTI: "jti", UserID: user.UserID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repos.Sessions.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Accounts.ScheduleDeletion(user.UserID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.AnonymizeDue(); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := repos.Sessions.IsRevoked("jti"); !revoked {
+		t.Error("the session of an anonymized user was not revoked")
+	}
+	export, err := s.BuildExport(user.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := export.Profile; p.Email == user.Email || p.Phone != "" || p.Name != "Deleted user" {
+		t.Errorf("profile after anonymizing = %+v", p)
+	}
+	// Running again finds nothing due
+	if err := s.AnonymizeDue(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildExport(t *testing.T) {
+	s, repos, user := newTestService(t)
+	league := model.League{Name: "Spring", PrizePool: 100}
+	if err := repos.Leagues.Create(&league); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Teams.CreateLeagueTeam(&model.Team{Name: "Reds", LeagueID: league.ID, PlayerID: user.UserID, Score: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := s.BuildExport(user.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Profile.Email != user.Email || len(export.LeagueTeams) != 1 || len(export.Leagues) != 1 {
+		t.Fatalf("export = %+v", export)
+	}
+	want := Result{"league", league.ID, "Reds", 3}
+	if len(export.Results) != 1 || export.Results[0] != want {
+		t.Errorf("results = %+v, want %+v", export.Results, want)
+	}
+}
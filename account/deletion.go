@@ -0,0 +1,99 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"gaming/audit"
+	"gaming/loginguard"
+	"gaming/repository"
+	"log/slog"
+	"time"
+)
+
+// GracePeriod is how long a scheduled deletion can still be cancelled.
+var GracePeriod = 30 * 24 * time.Hour
+
+var (
+	// ErrDeletionScheduled is returned when a deletion is already scheduled.
+	ErrDeletionScheduled = errors.New("account deletion already scheduled")
+	// ErrNoDeletionScheduled is returned when there is no deletion to cancel.
+	ErrNoDeletionScheduled = errors.New("no account deletion scheduled")
+)
+
+// Service exports and deletes accounts.
+type Service struct {
+	Accounts repository.AccountRepo
+	Guard    *loginguard.Guard
+}
+
+// NewService returns a Service using the given dependencies.
+func NewService(accounts repository.AccountRepo, guard *loginguard.Guard) *Service {
+	return &Service{Accounts: accounts, Guard: guard}
+}
+
+// ScheduleDeletion schedules the user's account to be anonymized after the
+// grace period and returns when that happens.
+func (s *Service) ScheduleDeletion(userID uint) (time.Time, error) {
+	at := time.Now().Add(GracePeriod)
+	err := s.Accounts.ScheduleDeletion(userID, at)
+	if errors.Is(err, repository.ErrNotFound) {
+		return time.Time{}, ErrDeletionScheduled
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return at, nil
+}
+
+// CancelDeletion cancels a scheduled deletion that has not been carried out yet.
+func (s *Service) CancelDeletion(userID uint) error {
+	err := s.Accounts.CancelDeletion(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrNoDeletionScheduled
+	}
+	return err
+}
+
+// Anonymize removes the personal data of a user. The row itself is kept with
+// placeholder values so teams and results still reference a valid user.
+// Credentials, linked identities, API keys, sessions, pending codes and emails
+// are deleted, and the audit events lose the user's emails and phone numbers,
+// in one transaction. The login failures of the email are cleared after it.
+func (s *Service) Anonymize(userID uint) error {
+	user, err := s.Accounts.Anonymize(userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if user.AnonymizedAt != nil {
+		return nil // anonymized before
+	}
+	audit.LogSystem(audit.Event{Action: audit.ActionAccountAnonymized, SubjectID: userID, TargetType: "user", TargetID: fmt.Sprint(userID)})
+	return s.Guard.Unlock(user.Email)
+}
+
+// AnonymizeDue anonymizes every account whose grace period has passed.
+func (s *Service) AnonymizeDue() error {
+	ids, err := s.Accounts.DueForDeletion(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.Anonymize(id); err != nil {
+			return fmt.Errorf("anonymize user %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// StartDeletion runs AnonymizeDue every interval in the background.
+func (s *Service) StartDeletion(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.AnonymizeDue(); err != nil {
+				slog.Error("failed to delete accounts", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,139 @@
+// Package account implements the personal data rights of a user: exporting
+// everything stored about them and deleting their account.
+//
+// Deletion is scheduled with a grace period during which it can be
+// cancelled. Once it passes, the user row is anonymized rather than removed,
+// so teams, scores and prize distributions of past competitions stay intact.
+package account
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"gaming/model"
+	"io"
+	"time"
+)
+
+// Export is the personal data of a user.
+type Export struct {
+	GeneratedAt time.Time            `json:"generated_at"`
+	Profile     Profile              `json:"profile"`
+	Identities  []model.UserIdentity `json:"identities"`
+	Sessions    []model.Session      `json:"sessions"`
+	APIKeys     []model.APIKey       `json:"api_keys"`
+	LeagueTeams []model.Team         `json:"league_teams"`
+	TournamentA []model.TeamA        `json:"tournament_teams_a"`
+	TournamentB []model.TeamB        `json:"tournament_teams_b"`
+	Leagues     []Competition        `json:"leagues"`
+	Tournaments []Competition        `json:"tournaments"`
+	Results     []Result             `json:"results"`
+}
+
+// Profile is the exported part of the user row. Credentials and 2FA secrets
+// are left out.
+type Profile struct {
+	UserID              uint       `json:"user_id"`
+	Name                string     `json:"name"`
+	Email               string     `json:"email"`
+	Phone               string     `json:"phone"`
+	PhoneVerified       bool       `json:"phone_verified"`
+	Role                string     `json:"role"`
+	TOTPEnabled         bool       `json:"totp_enabled"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// Competition is a league or tournament the user took part in.
+type Competition struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	PrizePool float64   `json:"prize_pool"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// Result is the score of one of the user's teams in a competition.
+type Result struct {
+	Competition   string  `json:"competition"` // "league" or "tournament"
+	CompetitionID uint    `json:"competition_id"`
+	Team          string  `json:"team"`
+	Score         float64 `json:"score"`
+}
+
+// BuildExport collects the personal data of the user.
+func (s *Service) BuildExport(userID uint) (*Export, error) {
+	data, err := s.Accounts.PersonalData(userID)
+	if err != nil {
+		return nil, err
+	}
+	user := data.User
+	export := &Export{
+		GeneratedAt: time.Now().UTC(),
+		Profile: Profile{
+			UserID:              user.UserID,
+			Name:                user.Name,
+			Email:               user.Email,
+			Phone:               user.Phone,
+			PhoneVerified:       user.PhoneVerified,
+			Role:                user.Role,
+			TOTPEnabled:         user.TOTPEnabled,
+			DeletionScheduledAt: user.DeletionScheduledAt,
+		},
+		Identities:  data.Identities,
+		Sessions:    data.Sessions,
+		APIKeys:     data.APIKeys,
+		LeagueTeams: data.LeagueTeams,
+		TournamentA: data.TeamsA,
+		TournamentB: data.TeamsB,
+	}
+
+	for _, team := range data.LeagueTeams {
+		export.Results = append(export.Results, Result{"league", team.LeagueID, team.Name, team.Score})
+	}
+	for _, team := range data.TeamsA {
+		export.Results = append(export.Results, Result{"tournament", team.TournamentID, team.Name, team.Score})
+	}
+	for _, team := range data.TeamsB {
+		export.Results = append(export.Results, Result{"tournament", team.TournamentID, team.Name, team.Score})
+	}
+	for _, league := range data.Leagues {
+		export.Leagues = append(export.Leagues, Competition{league.ID, league.Name, league.PrizePool, league.StartTime})
+	}
+	for _, tournament := range data.Tournaments {
+		export.Tournaments = append(export.Tournaments, Competition{tournament.ID, tournament.Name, tournament.PrizePool, tournament.StartTime})
+	}
+	return export, nil
+}
+
+// WriteZIP writes the export as a ZIP archive with one JSON file per section.
+func (e *Export) WriteZIP(w io.Writer) error {
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", e.Profile},
+		{"identities.json", e.Identities},
+		{"sessions.json", e.Sessions},
+		{"api_keys.json", e.APIKeys},
+		{"teams.json", map[string]any{
+			"league_teams":       e.LeagueTeams,
+			"tournament_teams_a": e.TournamentA,
+			"tournament_teams_b": e.TournamentB,
+		}},
+		{"leagues.json", e.Leagues},
+		{"tournaments.json", e.Tournaments},
+		{"results.json", e.Results},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: e.GeneratedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
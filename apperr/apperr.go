@@ -0,0 +1,170 @@
+// Package apperr defines the typed errors returned by the handlers and
+// services, and renders them as RFC 7807 problem details.
+//
+// Every error has a stable, machine-readable code such as "league_not_found"
+// that clients can rely on, while the message is meant for people and may
+// change.
+package apperr
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Kind is the category of an error. It decides the HTTP status.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindPreconditionFailed
+	KindPreconditionRequired
+	KindUnprocessable
+	KindTooManyRequests
+	KindUnavailable
+)
+
+// statuses maps each kind to its HTTP status.
+var statuses = map[Kind]int{
+	KindInternal:             http.StatusInternalServerError,
+	KindValidation:           http.StatusBadRequest,
+	KindUnauthorized:         http.StatusUnauthorized,
+	KindForbidden:            http.StatusForbidden,
+	KindNotFound:             http.StatusNotFound,
+	KindConflict:             http.StatusConflict,
+	KindPreconditionFailed:   http.StatusPreconditionFailed,
+	KindPreconditionRequired: http.StatusPreconditionRequired,
+	KindUnprocessable:        http.StatusUnprocessableEntity,
+	KindTooManyRequests:      http.StatusTooManyRequests,
+	KindUnavailable:          http.StatusBadGateway,
+}
+
+// Status returns the HTTP status of the kind.
+func (k Kind) Status() int {
+	return statuses[k]
+}
+
+// FieldError is a problem with a single field of the request. When it comes
+// from a failed validation rule, Abort translates the message to the language
+// of the client.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	rule  string // the failed validation rule, if any
+	param string // parameter of the rule, e.g. the 8 of min=8
+	text  bool   // the field is a string
+}
+
+// Error is an error with a kind, a stable code and a message that is safe to
+// show to the client. Err is the underlying cause; it is logged but never
+// sent to the client.
+type Error struct {
+	Kind       Kind
+	Code       string
+	Message    string
+	Fields     []FieldError
+	RetryAfter time.Duration // sent as Retry-After for KindTooManyRequests
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an *Error with the same code, so that
+// errors.Is works with errors built by the constructors below.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of the error with err as its cause.
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+func newError(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// Internal returns an error for an unexpected failure, such as a database error.
+func Internal(code, message string) *Error {
+	return newError(KindInternal, code, message)
+}
+
+// Validation returns an error for a request that is malformed or breaks a
+// rule, with the problems of each field if any.
+func Validation(code, message string, fields ...FieldError) *Error {
+	e := newError(KindValidation, code, message)
+	e.Fields = fields
+	return e
+}
+
+// Unauthorized returns an error for missing or wrong credentials.
+func Unauthorized(code, message string) *Error {
+	return newError(KindUnauthorized, code, message)
+}
+
+// Forbidden returns an error for a caller who is not allowed to do the request.
+func Forbidden(code, message string) *Error {
+	return newError(KindForbidden, code, message)
+}
+
+// NotFound returns an error for a missing resource.
+func NotFound(code, message string) *Error {
+	return newError(KindNotFound, code, message)
+}
+
+// Conflict returns an error for a request that clashes with the current
+// state, e.g. a name that is already taken.
+func Conflict(code, message string) *Error {
+	return newError(KindConflict, code, message)
+}
+
+// PreconditionFailed returns an error for a request whose condition, such as
+// If-Match, does not hold.
+func PreconditionFailed(code, message string) *Error {
+	return newError(KindPreconditionFailed, code, message)
+}
+
+// PreconditionRequired returns an error for a request that has to be made
+// conditional, e.g. with If-Match, and was not.
+func PreconditionRequired(code, message string) *Error {
+	return newError(KindPreconditionRequired, code, message)
+}
+
+// Unprocessable returns an error for a well-formed request that cannot be
+// processed as sent, e.g. one that reuses an idempotency key with another body.
+func Unprocessable(code, message string) *Error {
+	return newError(KindUnprocessable, code, message)
+}
+
+// TooManyRequests returns an error for a caller who has to wait retryAfter
+// before trying again.
+func TooManyRequests(code, message string, retryAfter time.Duration) *Error {
+	e := newError(KindTooManyRequests, code, message)
+	e.RetryAfter = retryAfter
+	return e
+}
+
+// Unavailable returns an error for an external service that failed, such as
+// the SMS provider.
+func Unavailable(code, message string) *Error {
+	return newError(KindUnavailable, code, message)
+}
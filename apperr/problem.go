@@ -0,0 +1,75 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"gaming/logging"
+	"gaming/validation"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Problem is the RFC 7807 body of an error response. Code and Errors are
+// extension members.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// Abort writes err as a problem details response and aborts the request.
+// An err that is not an *Error is an internal error; its text is logged but
+// not sent.
+func Abort(c *gin.Context, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal("internal", "something went wrong").Wrap(err)
+	}
+	status := e.Kind.Status()
+	if status >= http.StatusInternalServerError && e.Err != nil {
+		logging.For(c).Error(e.Message, "code", e.Code, "error", e.Err)
+	}
+	if e.Kind == KindTooManyRequests && e.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(e.RetryAfter.Seconds())+1))
+	}
+
+	fields := e.Fields
+	if len(fields) > 0 {
+		lang := validation.Language(c.GetHeader("Accept-Language"))
+		fields = localize(fields, lang)
+		c.Header("Content-Language", lang)
+	}
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(status, Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   e.Message,
+		Instance: c.Request.URL.Path,
+		Code:     e.Code,
+		Errors:   fields,
+	})
+}
+
+// NoRoute answers requests to unknown routes.
+func NoRoute(c *gin.Context) {
+	Abort(c, NotFound("route_not_found", "no such route"))
+}
+
+// Recovery logs the panic of a handler with its stack and answers the
+// request, for use with gin.CustomRecoveryWithWriter.
+func Recovery(c *gin.Context, recovered any) {
+	logging.For(c).Error("panic", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+	Abort(c, Internal("internal", "something went wrong"))
+}
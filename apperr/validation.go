@@ -0,0 +1,103 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"gaming/validation"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Invalid returns a validation error for a request body that could not be
+// bound, with the problem of each field when the body was well-formed JSON.
+func Invalid(err error) *Error {
+	var fieldErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &fieldErrs):
+		fields := make([]FieldError, len(fieldErrs))
+		for i, fe := range fieldErrs {
+			fields[i] = ruleError(fieldName(fe), fe.Tag(), fe.Param(), fe.Kind() == reflect.String)
+		}
+		return Validation("invalid_request", "the request has invalid fields", fields...).Wrap(err)
+	case errors.As(err, &typeErr):
+		field := ruleError(typeErr.Field, "type", jsonType(typeErr.Type), false)
+		return Validation("invalid_request", "the request has invalid fields", field).Wrap(err)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		name := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		field := ruleError(name, "unknown", "", false)
+		return Validation("invalid_request", "the request has invalid fields", field).Wrap(err)
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return Validation("malformed_json", "the request body is not valid JSON").Wrap(err)
+	default:
+		return Validation("invalid_request", "the request is invalid").Wrap(err)
+	}
+}
+
+// FieldRule returns the error of a field that failed a validation rule that
+// the handler checked itself rather than in a binding tag, e.g. one that
+// compares two fields.
+func FieldRule(field, rule, param string) FieldError {
+	return ruleError(field, rule, param, false)
+}
+
+// ruleError returns the error of a field that failed a validation rule.
+func ruleError(field, rule, param string, text bool) FieldError {
+	return FieldError{
+		Field:   field,
+		Code:    rule,
+		Message: validation.Message(validation.DefaultLanguage, rule, param, text),
+		rule:    rule,
+		param:   param,
+		text:    text,
+	}
+}
+
+// localize translates the messages of field errors from validation rules to
+// lang.
+func localize(fields []FieldError, lang string) []FieldError {
+	if lang == validation.DefaultLanguage {
+		return fields
+	}
+	localized := make([]FieldError, len(fields))
+	for i, field := range fields {
+		localized[i] = field
+		if field.rule != "" {
+			localized[i].Message = validation.Message(lang, field.rule, field.param, field.text)
+		}
+	}
+	return localized
+}
+
+// fieldName returns the path of the field below the request struct, e.g.
+// "email" or "scopes[0]".
+func fieldName(fe validator.FieldError) string {
+	_, name, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return name
+}
+
+// jsonType names the JSON type expected for a Go type.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	default:
+		return "object"
+	}
+}
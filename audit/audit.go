@@ -0,0 +1,261 @@
+// Package audit records security relevant events, such as logins, password
+// changes and administrative actions, in the audit_events table.
+//
+// Recording never fails the request that triggered it: errors are logged.
+// Changes are stored as before/after diffs holding only the fields that
+// changed, with secrets such as password hashes redacted.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"gaming/logging"
+	"gaming/model"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Actions.
+const (
+	ActionSignup             = "user.signup"
+	ActionOTPVerified        = "user.otp_verified"
+	ActionOTPFailed          = "user.otp_failed"
+	ActionLogin              = "auth.login"
+	ActionLoginFailed        = "auth.login_failed"
+	ActionLogout             = "auth.logout"
+	ActionLogoutAll          = "auth.logout_all"
+	ActionSessionRevoked     = "auth.session_revoked"
+	ActionAPIKeyCreated      = "auth.api_key_created"
+	ActionAPIKeyRevoked      = "auth.api_key_revoked"
+	ActionPasswordChanged    = "auth.password_changed"
+	ActionPasswordReset      = "auth.password_reset"
+	ActionEmailChanged       = "user.email_changed"
+	ActionPhoneVerified      = "user.phone_verified"
+	Action2FAEnabled         = "auth.2fa_enabled"
+	Action2FADisabled        = "auth.2fa_disabled"
+	ActionDeletionRequested  = "user.deletion_requested"
+	ActionDeletionCancelled  = "user.deletion_cancelled"
+	ActionAccountAnonymized  = "user.anonymized"
+	ActionCompetitionCreated = "competition.created"
+	ActionCompetitionUpdated = "competition.updated"
+	ActionCompetitionCancel  = "competition.cancelled"
+	ActionTeamUpdated        = "team.updated"
+	ActionTeamDeleted        = "team.deleted"
+	ActionResultReported     = "result.reported"
+	ActionResultOverridden   = "result.overridden"
+	ActionAccountUnlocked    = "admin.account_unlocked"
+	ActionRecordRestored     = "admin.record_restored"
+)
+
+// redacted are JSON fields that are never written to the log.
+var redacted = map[string]bool{
+	"password":       true,
+	"totp_secret":    true,
+	"totp_last_step": true,
+	"key_hash":       true,
+	"code_hash":      true,
+	"salt":           true,
+}
+
+// Event describes what happened. ActorID defaults to the authenticated user
+// of the request; SubjectID is only set when another user is affected. Before and After are any values that marshal to JSON
+// objects, e.g. a model before and after an update.
+type Event struct {
+	Action     string
+	ActorID    uint
+	SubjectID  uint
+	TargetType string
+	TargetID   string
+	Before     any
+	After      any
+	Details    map[string]any
+}
+
+// Store keeps the events.
+type Store interface {
+	Create(event *model.AuditEvent) error
+	// Find returns the events matching the filter, newest first, and the
+	// total number of matching events. The filter's limit is applied as is.
+	Find(f Filter) ([]model.AuditEvent, int64, error)
+}
+
+// Default is the store events are recorded in and found from.
+var Default Store = NewMemoryStore()
+
+// Log records the event with the IP and user agent of the request.
+func Log(c *gin.Context, e Event) {
+	if e.ActorID == 0 {
+		e.ActorID = c.GetUint("userid")
+	}
+	record(logging.For(c), e, c.ClientIP(), c.Request.UserAgent())
+}
+
+// LogSystem records an event that was not triggered by a request, such as
+// work done by a background job.
+func LogSystem(e Event) {
+	record(slog.Default(), e, "", "")
+}
+
+func record(logger *slog.Logger, e Event, ip, userAgent string) {
+	before, after := Diff(e.Before, e.After)
+	event := model.AuditEvent{
+		Action:     e.Action,
+		ActorID:    e.ActorID,
+		SubjectID:  e.SubjectID,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		IP:         ip,
+		UserAgent:  userAgent,
+		Before:     encode(before),
+		After:      encode(after),
+		Details:    encode(redact(e.Details)),
+	}
+	if err := Default.Create(&event); err != nil {
+		logger.Error("failed to record audit event", "action", e.Action, "error", err)
+	}
+}
+
+// Diff returns the fields that differ between before and after, each side
+// with its own values. Either side may be nil, e.g. for a creation.
+func Diff(before, after any) (map[string]any, map[string]any) {
+	b, a := toMap(before), toMap(after)
+	if b == nil || a == nil {
+		return redact(b), redact(a)
+	}
+	changedBefore, changedAfter := map[string]any{}, map[string]any{}
+	for key, value := range b {
+		if other, ok := a[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedBefore[key] = value
+		}
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedAfter[key] = value
+		}
+	}
+	return redact(changedBefore), redact(changedAfter)
+}
+
+// toMap converts a value to a JSON object, or nil when it is not one.
+func toMap(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	if m, ok := v.(map[string]any); ok {
+		return m
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// redact replaces the values of secret fields.
+func redact(m map[string]any) map[string]any {
+	for key := range m {
+		if redacted[key] {
+			m[key] = "[redacted]"
+		}
+	}
+	return m
+}
+
+// encode returns the JSON of a map, or "" when it is empty.
+func encode(m map[string]any) string {
+	if len(m) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// Filter selects audit events. Zero fields do not filter.
+type Filter struct {
+	UserID    uint // events where the user is the actor or the subject
+	ActorID   uint
+	SubjectID uint
+	Action    string
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Offset    int
+}
+
+// MaxLimit caps the number of events returned by Find.
+const MaxLimit = 200
+
+// Find returns the events matching the filter, newest first, and the total
+// number of matching events. At most MaxLimit events are returned.
+func Find(f Filter) ([]model.AuditEvent, int64, error) {
+	if f.Limit <= 0 || f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+	return Default.Find(f)
+}
+
+// FilterFromQuery reads a filter from the query parameters action, actor_id,
+// subject_id, from and to (RFC 3339), limit and offset.
+func FilterFromQuery(c *gin.Context) (Filter, error) {
+	f := Filter{Action: c.Query("action")}
+	uints := []struct {
+		name string
+		dest *uint
+	}{
+		{"actor_id", &f.ActorID},
+		{"subject_id", &f.SubjectID},
+	}
+	for _, u := range uints {
+		if value := c.Query(u.name); value != "" {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return Filter{}, fmt.Errorf("invalid %s %q", u.name, value)
+			}
+			*u.dest = uint(n)
+		}
+	}
+	times := []struct {
+		name string
+		dest *time.Time
+	}{
+		{"from", &f.From},
+		{"to", &f.To},
+	}
+	for _, t := range times {
+		if value := c.Query(t.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return Filter{}, fmt.Errorf("invalid %s %q, expected RFC 3339", t.name, value)
+			}
+			*t.dest = parsed
+		}
+	}
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"limit", &f.Limit},
+		{"offset", &f.Offset},
+	}
+	for _, i := range ints {
+		if value := c.Query(i.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return Filter{}, fmt.Errorf("invalid %s %q", i.name, value)
+			}
+			*i.dest = n
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"gaming/model"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore keeps events in memory. The events are lost on restart, so it
+// suits tests and local runs.
+type MemoryStore struct {
+	mu     sync.Mutex
+	nextID uint
+	events []model.AuditEvent
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Create(event *model.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	event.ID = s.nextID
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *MemoryStore) Find(f Filter) ([]model.AuditEvent, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []model.AuditEvent
+	for _, e := range s.events {
+		switch {
+		case f.UserID != 0 && e.ActorID != f.UserID && e.SubjectID != f.UserID,
+			f.ActorID != 0 && e.ActorID != f.ActorID,
+			f.SubjectID != 0 && e.SubjectID != f.SubjectID,
+			f.Action != "" && e.Action != f.Action,
+			!f.From.IsZero() && e.CreatedAt.Before(f.From),
+			!f.To.IsZero() && !e.CreatedAt.Before(f.To):
+			continue
+		}
+		matched = append(matched, e)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	total := int64(len(matched))
+	matched = matched[min(f.Offset, len(matched)):]
+	return matched[:min(f.Limit, len(matched))], total, nil
+}
+
+// DBStore keeps events in the audit_events table.
+type DBStore struct {
+	DB *gorm.DB
+}
+
+func (s DBStore) Create(event *model.AuditEvent) error {
+	return s.DB.Create(event).Error
+}
+
+func (s DBStore) Find(f Filter) ([]model.AuditEvent, int64, error) {
+	query := s.DB.Model(&model.AuditEvent{})
+	if f.UserID != 0 {
+		query = query.Where("actor_id = ? OR subject_id = ?", f.UserID, f.UserID)
+	}
+	if f.ActorID != 0 {
+		query = query.Where("actor_id = ?", f.ActorID)
+	}
+	if f.SubjectID != 0 {
+		query = query.Where("subject_id = ?", f.SubjectID)
+	}
+	if f.Action != "" {
+		query = query.Where("action = ?", f.Action)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("created_at < ?", f.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var events []model.AuditEvent
+	err := query.Order("created_at desc, id desc").Limit(f.Limit).Offset(f.Offset).Find(&events).Error
+	return events, total, err
+}
@@ -1,16 +1,239 @@
+// Package config loads the application settings into a typed Config.
+//
+// Settings come from, in increasing order of precedence: the defaults in the
+// struct tags, an optional YAML file, an optional .env file and the process
+// environment. Every field names its YAML key, environment variable and
+// default in its tags; fields tagged secret are redacted when the config is
+// printed.
 package config
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
-// load enviroment variables
-func LoadEnv() {
-	err := godotenv.Load(".env")
-	//error handling
+// Config holds every setting of the application.
+type Config struct {
+	DSN             string      `yaml:"dsn" env:"DSN" secret:"true"`
+	ListenAddr      string      `yaml:"listen_addr" env:"LISTEN_ADDR" default:":8080"`
+	LoginGuardStore string      `yaml:"login_guard_store" env:"LOGIN_GUARD_STORE" default:"database"` // database or memory
+	JWT             JWT         `yaml:"jwt"`
+	Mail            Mail        `yaml:"mail"`
+	SMS             SMS         `yaml:"sms"`
+	OTP             OTP         `yaml:"otp"`
+	RateLimit       RateLimit   `yaml:"rate_limit"`
+	Idempotency     Idempotency `yaml:"idempotency"`
+	Log             Log         `yaml:"log"`
+	OIDC            OIDC        `yaml:"oidc"`
+}
+
+// JWT configures the token signing keys and lifetimes.
+type JWT struct {
+	SigningKeyID   string        `yaml:"signing_key_id" env:"JWT_SIGNING_KEY_ID"`
+	SigningKeyFile string        `yaml:"signing_key_file" env:"JWT_SIGNING_KEY_FILE"` // PEM private key; an ephemeral key is used when empty
+	VerifyKeys     string        `yaml:"verify_keys" env:"JWT_VERIFY_KEYS"`           // extra public keys as "kid=path,kid=path"
+	TokenTTL       time.Duration `yaml:"token_ttl" env:"JWT_TOKEN_TTL" default:"2h"`
+	MFATokenTTL    time.Duration `yaml:"mfa_token_ttl" env:"JWT_MFA_TOKEN_TTL" default:"5m"`
+}
+
+// Mail configures outgoing email.
+type Mail struct {
+	Driver   string `yaml:"driver" env:"MAIL_DRIVER"` // smtp, fakesmtp, file or memory; chosen from Host when empty
+	Host     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	Port     int    `yaml:"smtp_port" env:"SMTP_PORT"` // 0 uses the driver's default port
+	Username string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	Password string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	From     string `yaml:"from" env:"SMTP_FROM" default:"no-reply@localhost"`
+	Dir      string `yaml:"dir" env:"MAIL_DIR" default:"mail"` // output directory of the file driver
+}
+
+// SMS configures outgoing text messages.
+type SMS struct {
+	Driver string `yaml:"driver" env:"SMS_DRIVER" default:"fake"` // fake or log
+}
+
+// OTP configures the one-time passwords.
+type OTP struct {
+	Length         int           `yaml:"length" env:"OTP_LENGTH" default:"6"`
+	TTL            time.Duration `yaml:"ttl" env:"OTP_TTL" default:"10m"`
+	MaxAttempts    int           `yaml:"max_attempts" env:"OTP_MAX_ATTEMPTS" default:"5"`
+	ResendCooldown time.Duration `yaml:"resend_cooldown" env:"OTP_RESEND_COOLDOWN" default:"1m"`
+}
+
+// RateLimit configures the rate limits, written as "requests/period[/burst]".
+type RateLimit struct {
+	Backend string `yaml:"backend" env:"RATE_LIMIT_BACKEND" default:"memory"` // memory or database
+	Auth    string `yaml:"auth" env:"RATE_LIMIT_AUTH" default:"10/1m"`
+	Write   string `yaml:"write" env:"RATE_LIMIT_WRITE" default:"60/1m/30"`
+	Read    string `yaml:"read" env:"RATE_LIMIT_READ" default:"300/1m/100"`
+}
+
+// Idempotency configures how long the responses to requests with an
+// Idempotency-Key header are kept for replay.
+type Idempotency struct {
+	Store string        `yaml:"store" env:"IDEMPOTENCY_STORE" default:"database"` // database or memory
+	TTL   time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" default:"24h"`
+}
+
+// Log configures the application log.
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" default:"info"`   // debug, info, warn or error; debug also logs the messages of the log sms driver
+	Format string `yaml:"format" env:"LOG_FORMAT" default:"json"` // json or text
+}
+
+// OIDC configures the OpenID Connect providers for social login.
+type OIDC struct {
+	Providers string `yaml:"providers" env:"OIDC_PROVIDERS"` // comma separated names, e.g. "google,discord"
+	// Provider holds the settings of each listed provider by name. In the
+	// environment they are prefixed with OIDC_<NAME>_, e.g. OIDC_GOOGLE_ISSUER.
+	Provider map[string]OIDCProvider `yaml:"provider"`
+}
+
+// OIDCProvider configures one OpenID Connect provider.
+type OIDCProvider struct {
+	Issuer       string `yaml:"issuer" env:"ISSUER"` // used for discovery
+	ClientID     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET" secret:"true"` // may be empty for public clients
+	RedirectURL  string `yaml:"redirect_url" env:"REDIRECT_URL"`                 // callback URL registered at the provider
+	Scopes       string `yaml:"scopes" env:"SCOPES"`                             // extra scopes besides "openid email profile"
+}
+
+// Names returns the listed providers, in lower case.
+func (o OIDC) Names() []string {
+	var names []string
+	for _, name := range strings.Split(o.Providers, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Load builds the config. The YAML file is read from path, or from the
+// CONFIG_FILE environment variable when path is empty; without either no
+// file is read. A missing .env file is not an error. All problems found are
+// returned together.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	var errs []error
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.Value, tag reflect.StructTag) {
+		if value, ok := tag.Lookup("default"); ok {
+			if err := set(field, value); err != nil {
+				errs = append(errs, fmt.Errorf("default of %s: %w", tag.Get("yaml"), err))
+			}
+		}
+	})
+
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, fmt.Errorf(".env: %w", err))
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file: %w", err))
+		} else if err := yaml.Unmarshal(data, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %w", path, err))
+		}
+	}
+
+	fromEnv := func(v reflect.Value, prefix string) {
+		walk(v, func(field reflect.Value, tag reflect.StructTag) {
+			name := tag.Get("env")
+			if value, ok := os.LookupEnv(prefix + name); ok && name != "" {
+				if err := set(field, value); err != nil {
+					errs = append(errs, fmt.Errorf("%s%s: %w", prefix, name, err))
+				}
+			}
+		})
+	}
+	fromEnv(reflect.ValueOf(cfg).Elem(), "")
+	// The provider settings are only known once the providers are listed
+	for _, name := range cfg.OIDC.Names() {
+		if cfg.OIDC.Provider == nil {
+			cfg.OIDC.Provider = map[string]OIDCProvider{}
+		}
+		provider := cfg.OIDC.Provider[name]
+		fromEnv(reflect.ValueOf(&provider).Elem(), "OIDC_"+strings.ToUpper(name)+"_")
+		cfg.OIDC.Provider[name] = provider
+	}
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+// walk calls fn for every leaf field of the struct v, descending into nested structs.
+func walk(v reflect.Value, fn func(field reflect.Value, tag reflect.StructTag)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			walk(field, fn)
+			continue
+		}
+		fn(field, t.Field(i).Tag)
+	}
+}
+
+// set parses value into the field.
+func set(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// String returns the config as YAML with the secrets redacted, so it can be logged.
+func (c Config) String() string {
+	redact(reflect.ValueOf(&c).Elem())
+	// The map is shared with the config, so the providers are redacted in a copy
+	providers := make(map[string]OIDCProvider, len(c.OIDC.Provider))
+	for name, provider := range c.OIDC.Provider {
+		redact(reflect.ValueOf(&provider).Elem())
+		providers[name] = provider
+	}
+	c.OIDC.Provider = providers
+	data, err := yaml.Marshal(c)
 	if err != nil {
-		log.Fatal("failed to load env")
+		return fmt.Sprintf("config: %v", err)
 	}
+	return string(data)
+}
+
+// redact replaces the values of the fields tagged secret in the struct v.
+func redact(v reflect.Value) {
+	walk(v, func(field reflect.Value, tag reflect.StructTag) {
+		if tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString("[redacted]")
+		}
+	})
 }
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validate returns every problem with the settings.
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.DSN == "" {
+		fail("DSN is required")
+	} else if scheme, _, ok := strings.Cut(c.DSN, "://"); ok {
+		oneOf(fail, "DSN scheme", scheme, "postgres", "postgresql", "sqlite")
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		fail("LISTEN_ADDR %q is not a host:port address", c.ListenAddr)
+	}
+	oneOf(fail, "LOGIN_GUARD_STORE", c.LoginGuardStore, "database", "memory")
+
+	if c.JWT.SigningKeyFile != "" {
+		if c.JWT.SigningKeyID == "" {
+			fail("JWT_SIGNING_KEY_ID is required with JWT_SIGNING_KEY_FILE")
+		}
+		if _, err := os.Stat(c.JWT.SigningKeyFile); err != nil {
+			fail("JWT_SIGNING_KEY_FILE: %v", err)
+		}
+	}
+	for _, entry := range strings.Split(c.JWT.VerifyKeys, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if _, file, ok := strings.Cut(entry, "="); !ok {
+			fail("JWT_VERIFY_KEYS entry %q is not kid=path", entry)
+		} else if _, err := os.Stat(file); err != nil {
+			fail("JWT_VERIFY_KEYS: %v", err)
+		}
+	}
+	positive(fail, "JWT_TOKEN_TTL", c.JWT.TokenTTL)
+	positive(fail, "JWT_MFA_TOKEN_TTL", c.JWT.MFATokenTTL)
+
+	oneOf(fail, "MAIL_DRIVER", c.Mail.Driver, "", "smtp", "fakesmtp", "file", "memory")
+	if c.Mail.Driver == "smtp" && c.Mail.Host == "" {
+		fail("SMTP_HOST is required for the smtp mail driver")
+	}
+	if c.Mail.Port < 0 || c.Mail.Port > 65535 {
+		fail("SMTP_PORT %d is out of range", c.Mail.Port)
+	}
+	if c.Mail.Username != "" && c.Mail.Password == "" {
+		fail("SMTP_PASSWORD is required with SMTP_USERNAME")
+	}
+
+	oneOf(fail, "SMS_DRIVER", c.SMS.Driver, "fake", "log")
+
+	if c.OTP.Length < 4 || c.OTP.Length > 10 {
+		fail("OTP_LENGTH must be between 4 and 10, got %d", c.OTP.Length)
+	}
+	positive(fail, "OTP_TTL", c.OTP.TTL)
+	if c.OTP.MaxAttempts < 1 {
+		fail("OTP_MAX_ATTEMPTS must be at least 1, got %d", c.OTP.MaxAttempts)
+	}
+	if c.OTP.ResendCooldown < 0 {
+		fail("OTP_RESEND_COOLDOWN must not be negative")
+	}
+
+	oneOf(fail, "RATE_LIMIT_BACKEND", c.RateLimit.Backend, "memory", "database")
+	limit(fail, "RATE_LIMIT_AUTH", c.RateLimit.Auth)
+	limit(fail, "RATE_LIMIT_WRITE", c.RateLimit.Write)
+	limit(fail, "RATE_LIMIT_READ", c.RateLimit.Read)
+
+	oneOf(fail, "IDEMPOTENCY_STORE", c.Idempotency.Store, "database", "memory")
+	positive(fail, "IDEMPOTENCY_TTL", c.Idempotency.TTL)
+
+	oneOf(fail, "LOG_LEVEL", strings.ToLower(c.Log.Level), "debug", "info", "warn", "error")
+	oneOf(fail, "LOG_FORMAT", c.Log.Format, "json", "text")
+
+	listed := map[string]bool{}
+	for _, name := range c.OIDC.Names() {
+		listed[name] = true
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := c.OIDC.Provider[name]
+		if provider.ClientID == "" {
+			fail("%sCLIENT_ID is required", prefix)
+		}
+		absoluteURL(fail, prefix+"ISSUER", provider.Issuer)
+		absoluteURL(fail, prefix+"REDIRECT_URL", provider.RedirectURL)
+	}
+	for name := range c.OIDC.Provider {
+		if !listed[name] {
+			fail("OIDC provider %q is configured but not listed in OIDC_PROVIDERS", name)
+		}
+	}
+	return errs
+}
+
+func absoluteURL(fail func(string, ...any), name, value string) {
+	if value == "" {
+		fail("%s is required", name)
+	} else if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("%s %q is not an http or https URL", name, value)
+	}
+}
+
+func oneOf(fail func(string, ...any), name, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	fail("%s %q must be one of %s", name, value, strings.Join(allowed, ", "))
+}
+
+func positive(fail func(string, ...any), name string, d time.Duration) {
+	if d <= 0 {
+		fail("%s must be positive, got %s", name, d)
+	}
+}
+
+// limit checks the "requests/period[/burst]" format; ratelimit.ParseLimit
+// reads the value once the config is valid.
+func limit(fail func(string, ...any), name, value string) {
+	parts := strings.Split(value, "/")
+	valid := len(parts) == 2 || len(parts) == 3
+	if valid {
+		n, err := strconv.Atoi(parts[0])
+		valid = err == nil && n > 0
+	}
+	if valid {
+		d, err := time.ParseDuration(parts[1])
+		valid = err == nil && d > 0
+	}
+	if valid && len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		valid = err == nil && n > 0
+	}
+	if !valid {
+		fail("%s %q is not requests/period[/burst], e.g. 60/1m", name, value)
+	}
+}
@@ -1,11 +1,14 @@
 package db
 
 import (
-	"gaming/model"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -13,22 +16,57 @@ import (
 var DB *gorm.DB
 
 // DBconnect initializes the database connection
-func DBconnect() {
-	// Fetching the Data Source Name (DSN) from the environment variables
-	dsn := os.Getenv("DSN")
-
+func DBconnect(dsn string) {
 	// Connecting to the database
-	var err error
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := Open(dsn)
 	//error handling
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		slog.Error("failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 	DB = db
-	// Auto-migrate the database schema
-	err = DB.AutoMigrate(&model.User{}, &model.OTP{}, &model.League{}, &model.Team{}, &model.Tournament{}, &model.TeamA{}, &model.TeamB{}) // Specify models to migrate
-	//error handling
-	if err != nil {
-		log.Fatal("failed to auto migrate", err)
+}
+
+// Open connects to the database of the DSN, choosing the driver from its
+// scheme. sqlite://path/to/file.db opens a SQLite file and sqlite://:memory:
+// a fresh in-memory database. postgres:// and postgresql:// URLs, and
+// key=value DSNs, open Postgres.
+func Open(dsn string) (*gorm.DB, error) {
+	scheme, rest, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	case "sqlite":
+		return gorm.Open(sqlite.Open(sqliteDSN(rest)), &gorm.Config{})
+	default:
+		return nil, fmt.Errorf("unsupported database scheme %q", scheme)
+	}
+}
+
+// memoryDatabases numbers the in-memory SQLite databases so each Open gets
+// its own.
+var memoryDatabases atomic.Int64
+
+// sqliteDSN turns the path of a sqlite:// DSN into a go-sqlite3 DSN. Foreign
+// keys are enforced as on Postgres, and writers wait for each other instead
+// of failing with "database is locked". Transactions take the write lock when
+// they begin, since a transaction that reads before it writes cannot wait for
+// the lock held by another and fails instead.
+func sqliteDSN(path string) string {
+	path, query, _ := strings.Cut(path, "?")
+	if path == ":memory:" {
+		// The memdb VFS lets all connections of the pool see the same database
+		// and lock it like a file, so that they wait for each other too
+		path = fmt.Sprintf("file:/memdb%d?vfs=memdb", memoryDatabases.Add(1))
+	} else {
+		path = "file:" + path + "?_journal_mode=WAL"
+	}
+	path += "&_foreign_keys=1&_busy_timeout=5000&_txlock=immediate"
+	if query != "" {
+		path += "&" + query
 	}
+	return path
 }
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"gaming/model"
+
+	"gorm.io/gorm"
+)
+
+// Models are the models stored in the database. The migrations must create a
+// table for each of them with every column and index they declare.
+var Models = []any{
+	&model.User{}, &model.OTP{}, &model.PendingSignup{}, &model.League{}, &model.Team{},
+	&model.Tournament{}, &model.TeamA{}, &model.TeamB{}, &model.Session{}, &model.RevokedToken{},
+	&model.OutboxEmail{}, &model.RecoveryCode{}, &model.UserIdentity{}, &model.OIDCLoginState{},
+	&model.LoginAttempt{}, &model.RateLimitBucket{}, &model.APIKey{}, &model.AuditEvent{},
+	&model.IdempotencyRecord{},
+}
+
+// ErrSchemaDrift is returned by CheckSchema when the database does not match
+// the models.
+var ErrSchemaDrift = errors.New("database schema does not match the models")
+
+// CheckSchema returns an error listing the pending migrations and the tables,
+// columns and indexes of the models that are missing from the database. A
+// model change needs a migration, or the server refuses to start.
+func CheckSchema(db *gorm.DB) error {
+	var problems []error
+
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			problems = append(problems, fmt.Errorf("migration %d_%s is pending", status.Version, status.Name))
+		}
+	}
+
+	migrator := db.Migrator()
+	for _, m := range Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+		if !migrator.HasTable(m) {
+			problems = append(problems, fmt.Errorf("table %s is missing", table))
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !migrator.HasColumn(m, field.DBName) {
+				problems = append(problems, fmt.Errorf("column %s.%s is missing", table, field.DBName))
+			}
+		}
+		for _, index := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(m, index.Name) {
+				problems = append(problems, fmt.Errorf("index %s on %s is missing", index.Name, table))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n%w", ErrSchemaDrift, errors.Join(problems...))
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations are SQL files named <version>_<name>.up.sql, with an optional
+// <version>_<name>.down.sql to revert them. Versions are applied in order and
+// a version must never be changed once it has been released.
+//
+// The SQL must run on both Postgres and SQLite. The few types they spell
+// differently are written as placeholders, see dialectTypes.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// dialectTypes expands the type placeholders of the migrations for each
+// database: {{serial}} is an auto-incrementing primary key and {{timestamp}}
+// a point in time. The first migration was released before SQLite was
+// supported and spells the Postgres types, which SQLite gets mapped too.
+var dialectTypes = map[string]*strings.Replacer{
+	"postgres": strings.NewReplacer("{{serial}}", "bigserial PRIMARY KEY", "{{timestamp}}", "timestamptz"),
+	"sqlite": strings.NewReplacer(
+		"{{serial}}", "integer PRIMARY KEY AUTOINCREMENT", "{{timestamp}}", "datetime",
+		"bigserial PRIMARY KEY", "integer PRIMARY KEY AUTOINCREMENT", "timestamptz", "datetime",
+	),
+}
+
+// migrationLockID is the Postgres advisory lock held while migrating, so that
+// replicas starting at the same time apply each migration once. SQLite locks
+// the whole database file for each transaction instead.
+const migrationLockID = 4242001
+
+// Migration is a numbered schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string // empty when the migration cannot be reverted
+}
+
+// MigrationStatus is a migration and when it was applied, nil if pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the schema_migrations table.
+type schemaMigration struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, name := range names {
+		base := path.Base(name)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", base)
+		}
+		number, title, _ := strings.Cut(stem, "_")
+		version, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version", base)
+		}
+		body, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if m.Name != title {
+			return nil, fmt.Errorf("migration %d: up and down files have different names", version)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies the pending migrations and returns them.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	err = withMigrationLock(db, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(expand(tx, m.Up)).Error; err != nil {
+					return err
+				}
+				return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns them.
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	var reverted []Migration
+	err = withMigrationLock(db, func(conn *gorm.DB) error {
+		var rows []schemaMigration
+		if err := conn.Order("version desc").Limit(steps).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			m, ok := byVersion[row.Version]
+			if !ok {
+				return fmt.Errorf("migration %d_%s: not known to this binary", row.Version, row.Name)
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s: cannot be reverted", m.Version, m.Name)
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(expand(tx, m.Down)).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&schemaMigration{}, m.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatuses lists the embedded migrations and when each was applied.
+// Applied versions unknown to this binary are listed too, without SQL.
+func MigrationStatuses(db *gorm.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	err = withMigrationLock(db, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := MigrationStatus{Migration: m}
+			if row, ok := done[m.Version]; ok {
+				status.AppliedAt = &row.AppliedAt
+				delete(done, m.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for _, row := range done {
+			appliedAt := row.AppliedAt
+			statuses = append(statuses, MigrationStatus{
+				Migration: Migration{Version: row.Version, Name: row.Name},
+				AppliedAt: &appliedAt,
+			})
+		}
+		return nil
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, err
+}
+
+// withMigrationLock runs fn on a single connection while holding the
+// migration lock, after making sure the schema_migrations table exists.
+func withMigrationLock(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+	if _, ok := dialectTypes[db.Dialector.Name()]; !ok {
+		return fmt.Errorf("migrations do not support %s databases", db.Dialector.Name())
+	}
+	return db.Connection(func(conn *gorm.DB) error {
+		if conn.Dialector.Name() == "postgres" {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID).Error; err != nil {
+				return fmt.Errorf("failed to take migration lock: %w", err)
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID)
+		}
+
+		if !conn.Migrator().HasTable(&schemaMigration{}) {
+			if err := conn.Migrator().CreateTable(&schemaMigration{}); err != nil {
+				return err
+			}
+		}
+		return fn(conn)
+	})
+}
+
+// expand replaces the type placeholders of the migration SQL for the database.
+func expand(db *gorm.DB, sql string) string {
+	return dialectTypes[db.Dialector.Name()].Replace(sql)
+}
+
+// appliedVersions returns the rows of schema_migrations by version.
+func appliedVersions(conn *gorm.DB) (map[int64]schemaMigration, error) {
+	var rows []schemaMigration
+	if err := conn.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	done := make(map[int64]schemaMigration, len(rows))
+	for _, row := range rows {
+		done[row.Version] = row
+	}
+	return done, nil
+}
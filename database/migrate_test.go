@@ -0,0 +1,148 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gaming/model"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The models as they were when AutoMigrate created the schema, before the
+// migrations replaced it.
+type (
+	baselineUser struct {
+		UserID   uint   `gorm:"primaryKey"`
+		Name     string `gorm:"not null"`
+		Email    string `gorm:"unique;not null"`
+		Phone    string
+		Password string `gorm:"not null"`
+	}
+	baselineOTP struct {
+		gorm.Model
+		Email string
+		Otp   string
+		Exp   time.Time
+	}
+	baselineLeague struct {
+		ID        uint
+		Name      string
+		PrizePool float64
+		Teams     []baselineTeam `gorm:"foreignKey:LeagueID"`
+		StartTime time.Time
+	}
+	baselineTeam struct {
+		ID       uint
+		Name     string
+		PlayerID uint
+		Score    float64
+		LeagueID uint
+	}
+)
+
+func (baselineUser) TableName() string   { return "users" }
+func (baselineOTP) TableName() string    { return "otps" }
+func (baselineLeague) TableName() string { return "leagues" }
+func (baselineTeam) TableName() string   { return "teams" }
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Logger = logger.Discard
+	return db
+}
+
+func TestMigrateFromAutoMigrate(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&baselineUser{}, &baselineOTP{}, &baselineLeague{}, &baselineTeam{}); err != nil {
+		t.Fatal(err)
+	}
+	user := baselineUser{Name: "Player", Email: "player@example.com", Password: "hash"}
+	league := baselineLeague{Name: "Spring", Teams: []baselineTeam{{Name: "Reds", Score: 3}}}
+	for _, row := range []any{&user, &baselineOTP{Email: user.Email, Otp: "123456"}, &baselineOTP{Email: user.Email, Otp: "654321"}, &league} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var migrated model.User
+	if err := db.First(&migrated, user.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Email != user.Email || migrated.Role != "user" || migrated.TOTPEnabled {
+		t.Errorf("user after migrating = %+v, want a plain user", migrated)
+	}
+	var otps int64
+	if err := db.Model(&model.OTP{}).Count(&otps).Error; err != nil || otps != 0 {
+		t.Errorf("%d plaintext OTPs left after migrating, %v", otps, err)
+	}
+	var team model.Team
+	if err := db.First(&team, league.Teams[0].ID).Error; err != nil || team.Version != 1 || team.Score != 3 {
+		t.Errorf("team after migrating = %+v, %v", team, err)
+	}
+}
+
+// released are the SHA-256 sums of the up files of the released migrations,
+// which must never change. A change goes in a new migration instead.
+var released = map[int64]string{
+	1:  "3e3ba6ad4d9d81bf2b33205fe4cd6be77c2d03de16b0270eaf06f51d42627cf5",
+	2:  "c032fae650f536aa6167b31a6c92f6d6201bc41a50384d58b4ff31bf9756ddeb",
+	3:  "465e08005e1e5712af9f2fc9b87b767fad48527db0c5af6964784ac6adffe9f5",
+	4:  "ad7f05df9621bf33eb39b1e382513354ee7d4bbc120fd273399319b677a20d87",
+	5:  "ae7ef55666ef8dd84d3375fc3411bbd746f0118cd2d9ea1cbc262ec6ade17896",
+	6:  "91da84fde8f56519b3c60196af46ba10775a6fe6f4fdd305ef55f67ac387b9db",
+	7:  "f90efc154604c76abf1da8e3aec7426e4611434964ca64e4f7ecb5304f44fbce",
+	8:  "7c11e03dd319c84ecd99a176f9d7ceb8bf5e0e2fcb680a4a8b53bec2e445d69b",
+	9:  "5ba20d287e112673443c042925a27220ec58328d7cbbffdd93aea6e48be4a6df",
+	10: "45ef33f31e4aa52a5a67e065d24485a8739af3e243312ba50c2b98356090466a",
+}
+
+func TestReleasedMigrationsUnchanged(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		want, ok := released[m.Version]
+		if !ok {
+			continue
+		}
+		sum := sha256.Sum256([]byte(m.Up))
+		if got := hex.EncodeToString(sum[:]); got != want {
+			t.Errorf("migration %d_%s was changed after its release", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openTestDB(t)
+	migrations, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateDown(db, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable("users") {
+		t.Error("users is left after reverting every migration")
+	}
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS team_bs;
+DROP TABLE IF EXISTS team_as;
+DROP TABLE IF EXISTS tournaments;
+DROP TABLE IF EXISTS teams;
+DROP TABLE IF EXISTS leagues;
+DROP TABLE IF EXISTS otps;
+DROP TABLE IF EXISTS users;
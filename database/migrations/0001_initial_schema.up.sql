@@ -0,0 +1,63 @@
+-- The schema as AutoMigrate created it before the migrations replaced it.
+-- IF NOT EXISTS lets those databases adopt the migrations, and the later
+-- migrations bring them up to date like a new database.
+--
+-- This version predates the type placeholders, so it spells the Postgres
+-- types. dialectTypes maps them for SQLite.
+
+CREATE TABLE IF NOT EXISTS users (
+    user_id  bigserial PRIMARY KEY,
+    name     text NOT NULL,
+    email    text NOT NULL CONSTRAINT uni_users_email UNIQUE,
+    phone    text,
+    password text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS otps (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    email      text,
+    otp        text,
+    exp        timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_otps_deleted_at ON otps (deleted_at);
+
+CREATE TABLE IF NOT EXISTS leagues (
+    id         bigserial PRIMARY KEY,
+    name       text,
+    prize_pool decimal,
+    start_time timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS teams (
+    id        bigserial PRIMARY KEY,
+    name      text,
+    player_id bigint,
+    score     decimal,
+    league_id bigint CONSTRAINT fk_leagues_teams REFERENCES leagues (id)
+);
+
+CREATE TABLE IF NOT EXISTS tournaments (
+    id         bigserial PRIMARY KEY,
+    name       text NOT NULL,
+    prize_pool decimal,
+    start_time timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS team_as (
+    id            bigserial PRIMARY KEY,
+    name          text NOT NULL,
+    player_id     bigint,
+    score         decimal,
+    tournament_id bigint CONSTRAINT fk_tournaments_team_a REFERENCES tournaments (id)
+);
+
+CREATE TABLE IF NOT EXISTS team_bs (
+    id            bigserial PRIMARY KEY,
+    name          text NOT NULL,
+    player_id     bigint,
+    score         decimal,
+    tournament_id bigint CONSTRAINT fk_tournaments_team_b REFERENCES tournaments (id)
+);
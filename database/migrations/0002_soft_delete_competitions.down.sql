@@ -0,0 +1,29 @@
+-- Soft deleted rows are removed for good, as they would otherwise come back.
+
+DELETE FROM team_bs WHERE deleted_at IS NOT NULL;
+DELETE FROM team_as WHERE deleted_at IS NOT NULL;
+DELETE FROM teams WHERE deleted_at IS NOT NULL;
+DELETE FROM team_bs WHERE tournament_id IN (SELECT id FROM tournaments WHERE deleted_at IS NOT NULL);
+DELETE FROM team_as WHERE tournament_id IN (SELECT id FROM tournaments WHERE deleted_at IS NOT NULL);
+DELETE FROM teams WHERE league_id IN (SELECT id FROM leagues WHERE deleted_at IS NOT NULL);
+DELETE FROM tournaments WHERE deleted_at IS NOT NULL;
+DELETE FROM leagues WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_team_bs_deleted_at;
+ALTER TABLE team_bs DROP COLUMN deleted_at;
+
+DROP INDEX IF EXISTS idx_team_as_deleted_at;
+ALTER TABLE team_as DROP COLUMN deleted_at;
+
+DROP INDEX IF EXISTS idx_teams_deleted_at;
+ALTER TABLE teams DROP COLUMN deleted_at;
+
+DROP INDEX IF EXISTS idx_tournaments_deleted_at;
+DROP INDEX IF EXISTS idx_tournaments_created_by;
+ALTER TABLE tournaments DROP COLUMN deleted_at;
+ALTER TABLE tournaments DROP COLUMN created_by;
+
+DROP INDEX IF EXISTS idx_leagues_deleted_at;
+DROP INDEX IF EXISTS idx_leagues_created_by;
+ALTER TABLE leagues DROP COLUMN deleted_at;
+ALTER TABLE leagues DROP COLUMN created_by;
@@ -0,0 +1,22 @@
+-- Leagues, tournaments and their teams are soft deleted so that an admin can
+-- restore them. Competitions also record who created them, who may then
+-- edit and delete them.
+
+ALTER TABLE leagues ADD COLUMN created_by bigint;
+ALTER TABLE leagues ADD COLUMN deleted_at {{timestamp}};
+CREATE INDEX IF NOT EXISTS idx_leagues_created_by ON leagues (created_by);
+CREATE INDEX IF NOT EXISTS idx_leagues_deleted_at ON leagues (deleted_at);
+
+ALTER TABLE tournaments ADD COLUMN created_by bigint;
+ALTER TABLE tournaments ADD COLUMN deleted_at {{timestamp}};
+CREATE INDEX IF NOT EXISTS idx_tournaments_created_by ON tournaments (created_by);
+CREATE INDEX IF NOT EXISTS idx_tournaments_deleted_at ON tournaments (deleted_at);
+
+ALTER TABLE teams ADD COLUMN deleted_at {{timestamp}};
+CREATE INDEX IF NOT EXISTS idx_teams_deleted_at ON teams (deleted_at);
+
+ALTER TABLE team_as ADD COLUMN deleted_at {{timestamp}};
+CREATE INDEX IF NOT EXISTS idx_team_as_deleted_at ON team_as (deleted_at);
+
+ALTER TABLE team_bs ADD COLUMN deleted_at {{timestamp}};
+CREATE INDEX IF NOT EXISTS idx_team_bs_deleted_at ON team_bs (deleted_at);
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_team_bs_player_id;
+DROP INDEX IF EXISTS idx_team_as_player_id;
+DROP INDEX IF EXISTS idx_teams_player_id;
+
+DROP INDEX IF EXISTS idx_tournaments_prize_pool;
+DROP INDEX IF EXISTS idx_tournaments_start_time;
+DROP INDEX IF EXISTS idx_tournaments_game;
+ALTER TABLE tournaments DROP COLUMN game;
+
+DROP INDEX IF EXISTS idx_leagues_prize_pool;
+DROP INDEX IF EXISTS idx_leagues_start_time;
+DROP INDEX IF EXISTS idx_leagues_game;
+ALTER TABLE leagues DROP COLUMN game;
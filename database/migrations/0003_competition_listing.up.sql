@@ -0,0 +1,16 @@
+-- Competitions name the game they are played in, and the columns that lists
+-- filter and sort on are indexed so that pages stay cheap on large tables.
+
+ALTER TABLE leagues ADD COLUMN game text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_leagues_game ON leagues (game);
+CREATE INDEX IF NOT EXISTS idx_leagues_start_time ON leagues (start_time);
+CREATE INDEX IF NOT EXISTS idx_leagues_prize_pool ON leagues (prize_pool);
+
+ALTER TABLE tournaments ADD COLUMN game text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_tournaments_game ON tournaments (game);
+CREATE INDEX IF NOT EXISTS idx_tournaments_start_time ON tournaments (start_time);
+CREATE INDEX IF NOT EXISTS idx_tournaments_prize_pool ON tournaments (prize_pool);
+
+CREATE INDEX IF NOT EXISTS idx_teams_player_id ON teams (player_id);
+CREATE INDEX IF NOT EXISTS idx_team_as_player_id ON team_as (player_id);
+CREATE INDEX IF NOT EXISTS idx_team_bs_player_id ON team_bs (player_id);
@@ -0,0 +1,5 @@
+ALTER TABLE team_bs DROP COLUMN version;
+ALTER TABLE team_as DROP COLUMN version;
+ALTER TABLE teams DROP COLUMN version;
+ALTER TABLE tournaments DROP COLUMN version;
+ALTER TABLE leagues DROP COLUMN version;
@@ -0,0 +1,9 @@
+-- Competitions and teams carry a version that every edit increments, so that
+-- an edit based on an outdated read is refused instead of overwriting the
+-- edit made in between.
+
+ALTER TABLE leagues ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE tournaments ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE teams ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE team_as ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE team_bs ADD COLUMN version bigint NOT NULL DEFAULT 1;
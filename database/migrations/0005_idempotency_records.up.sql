@@ -0,0 +1,15 @@
+-- Responses to requests sent with an Idempotency-Key header, kept per user
+-- and key so that a retried request is answered without running it again.
+
+CREATE TABLE IF NOT EXISTS idempotency_records (
+    user_id      bigint NOT NULL,
+    key          text NOT NULL,
+    request_hash text NOT NULL,
+    status       bigint,
+    header       text,
+    body         text,
+    created_at   {{timestamp}},
+    expires_at   {{timestamp}},
+    PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_records_expires_at ON idempotency_records (expires_at);
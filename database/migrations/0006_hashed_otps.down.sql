@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS pending_signups;
+
+DELETE FROM otps;
+DROP INDEX IF EXISTS idx_otp_email_purpose;
+ALTER TABLE otps DROP COLUMN last_sent_at;
+ALTER TABLE otps DROP COLUMN attempts;
+ALTER TABLE otps DROP COLUMN salt;
+ALTER TABLE otps DROP COLUMN code_hash;
+ALTER TABLE otps DROP COLUMN purpose;
+ALTER TABLE otps ADD COLUMN otp text;
@@ -0,0 +1,22 @@
+-- One-time passwords are stored hashed and scoped to the flow they were sent
+-- for, and signups wait for their OTP in pending_signups. The plaintext codes
+-- stored before cannot be hashed here, so they are deleted; they expired
+-- within minutes and a new one can be requested.
+
+DELETE FROM otps;
+ALTER TABLE otps DROP COLUMN otp;
+ALTER TABLE otps ADD COLUMN purpose text NOT NULL DEFAULT '';
+ALTER TABLE otps ADD COLUMN code_hash text NOT NULL DEFAULT '';
+ALTER TABLE otps ADD COLUMN salt text NOT NULL DEFAULT '';
+ALTER TABLE otps ADD COLUMN attempts bigint NOT NULL DEFAULT 0;
+ALTER TABLE otps ADD COLUMN last_sent_at {{timestamp}};
+CREATE UNIQUE INDEX IF NOT EXISTS idx_otp_email_purpose ON otps (email, purpose);
+
+CREATE TABLE IF NOT EXISTS pending_signups (
+    email      text PRIMARY KEY,
+    name       text NOT NULL,
+    phone      text,
+    password   text NOT NULL,
+    created_at {{timestamp}},
+    updated_at {{timestamp}}
+);
@@ -0,0 +1,22 @@
+-- Sessions record the tokens issued to each user, so that they can be listed
+-- and revoked before they expire.
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id         {{serial}},
+    jti        text NOT NULL,
+    user_id    bigint NOT NULL,
+    device     text,
+    ip         text,
+    created_at {{timestamp}},
+    expires_at {{timestamp}}
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_jti ON sessions (jti);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        text PRIMARY KEY,
+    user_id    bigint,
+    expires_at {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
@@ -0,0 +1,16 @@
+-- Emails are queued in an outbox and retried until the SMTP server takes them.
+
+CREATE TABLE IF NOT EXISTS outbox_emails (
+    id              {{serial}},
+    "to"            text NOT NULL,
+    subject         text,
+    text            text,
+    html            text,
+    attempts        bigint,
+    next_attempt_at {{timestamp}},
+    sent_at         {{timestamp}},
+    last_error      text,
+    created_at      {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_next_attempt_at ON outbox_emails (next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_sent_at ON outbox_emails (sent_at);
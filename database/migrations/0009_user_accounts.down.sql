@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS o_id_c_login_states;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS recovery_codes;
+
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+ALTER TABLE users DROP COLUMN totp_last_step;
+ALTER TABLE users DROP COLUMN totp_enabled;
+ALTER TABLE users DROP COLUMN totp_secret;
+ALTER TABLE users DROP COLUMN anonymized_at;
+ALTER TABLE users DROP COLUMN deletion_scheduled_at;
+ALTER TABLE users DROP COLUMN phone_verified;
+ALTER TABLE users DROP COLUMN pending_phone;
+ALTER TABLE users DROP COLUMN pending_email;
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,43 @@
+-- Users get a role, verified contact changes, two-factor authentication,
+-- scheduled deletion and identities at OpenID Connect providers. Existing
+-- users become plain users without 2FA.
+
+ALTER TABLE users ADD COLUMN role text NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN pending_email text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN pending_phone text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN phone_verified boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN deletion_scheduled_at {{timestamp}};
+ALTER TABLE users ADD COLUMN anonymized_at {{timestamp}};
+ALTER TABLE users ADD COLUMN totp_secret text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users (deletion_scheduled_at);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id        {{serial}},
+    user_id   bigint NOT NULL,
+    code_hash text NOT NULL,
+    used_at   {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id         {{serial}},
+    user_id    bigint NOT NULL,
+    provider   text NOT NULL,
+    subject    text NOT NULL,
+    email      text,
+    created_at {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_identity_provider_subject ON user_identities (provider, subject);
+
+CREATE TABLE IF NOT EXISTS o_id_c_login_states (
+    state         text PRIMARY KEY,
+    provider      text NOT NULL,
+    code_verifier text NOT NULL,
+    nonce         text NOT NULL,
+    link_user_id  bigint,
+    expires_at    {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_o_id_c_login_states_expires_at ON o_id_c_login_states (expires_at);
@@ -0,0 +1,52 @@
+-- Failed logins and rate limit buckets are kept in the database so that every
+-- replica counts them, personal API keys authenticate scripts and security
+-- events are kept in an audit log.
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key             text PRIMARY KEY,
+    failures        bigint,
+    last_failure_at {{timestamp}},
+    next_attempt_at {{timestamp}},
+    locked_until    {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_locked_until ON login_attempts (locked_until);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key         text PRIMARY KEY,
+    tokens      decimal NOT NULL,
+    refilled_at {{timestamp}}
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           {{serial}},
+    user_id      bigint NOT NULL,
+    name         text NOT NULL,
+    prefix       text NOT NULL,
+    key_hash     text NOT NULL,
+    scopes       text NOT NULL,
+    expires_at   {{timestamp}},
+    last_used_at {{timestamp}},
+    revoked_at   {{timestamp}},
+    created_at   {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys (prefix);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id          {{serial}},
+    action      text NOT NULL,
+    actor_id    bigint,
+    subject_id  bigint,
+    target_type text,
+    target_id   text,
+    ip          text,
+    user_agent  text,
+    before      text,
+    after       text,
+    details     text,
+    created_at  {{timestamp}}
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_subject_id ON audit_events (subject_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
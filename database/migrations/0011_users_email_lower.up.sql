@@ -0,0 +1,5 @@
+-- Users are looked up by email ignoring case, see UserRepo.ByEmail. Emails
+-- that differ only in case can exist from before signups lowercased them, so
+-- the index is not unique.
+
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));
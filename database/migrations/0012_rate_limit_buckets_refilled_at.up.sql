@@ -0,0 +1,3 @@
+-- Idle buckets are deleted by their refill time, see ratelimit.StartCleanup.
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_refilled_at ON rate_limit_buckets (refilled_at);
@@ -1,33 +1,28 @@
 package generateotp
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"math/big"
 )
 
-// init initializes the random number generator seed.
-// It uses the current Unix timestamp in nanoseconds to ensure that the random numbers 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 // GenerateOTP generates a one-time password (OTP) of the specified length.
-// The OTP consists of numeric characters only (0-9).
-func GenerateOTP(length int) string {
+// The OTP consists of numeric characters only (0-9) and every digit is drawn
+// from crypto/rand, so codes cannot be predicted from earlier ones.
+func GenerateOTP(length int) (string, error) {
 	// Set of characters to choose from for OTP generation
 	characters := "0123456789"
-	// Initialize an empty string to hold the generated OTP.
-	otp := ""
+	max := big.NewInt(int64(len(characters)))
 
+	otp := make([]byte, length)
 	// Loop through 'length' times, randomly selecting a character for each position.
-	for i := 0; i < length; i++ {
-		// Generate a random index to select a character from the set.
-		randomIndex := rand.Intn(len(characters))
-
-		// Append the selected character to the OTP string.
-		otp += string(characters[randomIndex])
+	for i := range otp {
+		randomIndex, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		otp[i] = characters[randomIndex.Int64()]
 	}
 
 	// Return the final generated OTP as a string.
-	return otp
+	return string(otp), nil
 }
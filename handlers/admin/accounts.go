@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"gaming/apperr"
+	"gaming/audit"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LockedAccounts lists the accounts and IPs that are locked after failed logins
+func (s *Service) LockedAccounts(c *gin.Context) {
+	records, err := s.Guard.Locked()
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch locked accounts").Wrap(err))
+		return
+	}
+
+	var lockedview []gin.H
+	for _, record := range records {
+		kind, subject, _ := strings.Cut(record.Key, ":")
+		lockedview = append(lockedview, gin.H{
+			"type":            kind, // "account" or "ip"
+			"subject":         subject,
+			"failures":        record.Failures,
+			"last_failure_at": record.LastFailureAt,
+			"locked_until":    record.LockedUntil,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "fetched locked accounts successfully",
+		"data":    lockedview,
+	})
+}
+
+// UnlockAccount lifts the lockout of an account
+func (s *Service) UnlockAccount(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	req.Email = strings.ToLower(req.Email)
+
+	if err := s.Guard.Unlock(req.Email); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to unlock account").Wrap(err))
+		return
+	}
+	subject, _ := s.Users.ByEmail(req.Email)
+	audit.Log(c, audit.Event{Action: audit.ActionAccountUnlocked, SubjectID: subject.UserID, TargetType: "user", TargetID: req.Email})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "account unlocked successfully",
+	})
+}
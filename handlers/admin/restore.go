@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"errors"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// restorer restores a deleted record of one kind and returns it.
+type restorer func(id uint) (any, error)
+
+// restorers maps the kind route parameter to the repository that restores it.
+func (s *Service) restorers() map[string]restorer {
+	return map[string]restorer{
+		"league":     func(id uint) (any, error) { return s.Leagues.Restore(id) },
+		"tournament": func(id uint) (any, error) { return s.Tournaments.Restore(id) },
+		"team":       func(id uint) (any, error) { return s.Teams.RestoreLeagueTeam(id) },
+		"team_a":     func(id uint) (any, error) { return s.Teams.RestoreTeamA(id) },
+		"team_b":     func(id uint) (any, error) { return s.Teams.RestoreTeamB(id) },
+	}
+}
+
+// RestoreRecord undoes the soft delete of a league, tournament or team. The
+// teams deleted along with a competition come back with it.
+func (s *Service) RestoreRecord(c *gin.Context) {
+	kind := c.Param("kind")
+	restore, ok := s.restorers()[kind]
+	if !ok {
+		apperr.Abort(c, apperr.NotFound("unknown_kind", "records of this kind cannot be restored"))
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Abort(c, apperr.Validation("invalid_id", "invalid record id"))
+		return
+	}
+
+	record, err := restore(uint(id))
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		apperr.Abort(c, apperr.NotFound("record_not_found", "no deleted "+kind+" has this id"))
+		return
+	case errors.Is(err, repository.ErrDuplicate):
+		apperr.Abort(c, apperr.Conflict("name_taken", "another "+kind+" has taken the name since it was deleted"))
+		return
+	case errors.Is(err, repository.ErrParentDeleted):
+		apperr.Abort(c, apperr.Conflict("competition_deleted", "the competition of the team is deleted, restore it first"))
+		return
+	case err != nil:
+		apperr.Abort(c, apperr.Internal("internal", "failed to restore record").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionRecordRestored, TargetType: kind, TargetID: strconv.FormatUint(id, 10), After: record})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "record restored successfully",
+		"data":    record,
+	})
+}
@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"gaming/loginguard"
+	"gaming/repository"
+)
+
+// Service handles the admin routes.
+type Service struct {
+	Users       repository.UserRepo
+	Leagues     repository.LeagueRepo
+	Tournaments repository.TournamentRepo
+	Teams       repository.TeamRepo
+	Guard       *loginguard.Guard
+}
+
+// NewService returns a Service using the given dependencies.
+func NewService(users repository.UserRepo, leagues repository.LeagueRepo, tournaments repository.TournamentRepo, teams repository.TeamRepo, guard *loginguard.Guard) *Service {
+	return &Service{Users: users, Leagues: leagues, Tournaments: tournaments, Teams: teams, Guard: guard}
+}
@@ -1,46 +1,72 @@
 package leagues
 
 import (
+    "encoding/json"
+    "errors"
+    "gaming/apperr"
+    "gaming/audit"
+    "gaming/jwt"
+    "gaming/listing"
     "gaming/model"
+    "gaming/repository"
+    "gaming/versioning"
     "net/http"
+    "strconv"
+    "time"
 
-    database "gaming/database"
     "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
 )
 
+// Service handles the league routes.
+type Service struct {
+    Leagues repository.LeagueRepo
+    Users   repository.UserRepo
+}
+
+// NewService returns a Service using the given repositories.
+func NewService(leagues repository.LeagueRepo, users repository.UserRepo) *Service {
+    return &Service{Leagues: leagues, Users: users}
+}
+
+// CreateLeagueRequest is the payload accepted by CreateLeagues
+type CreateLeagueRequest struct {
+    Name      string    `json:"name" binding:"required,max=100,name"`
+    Game      string    `json:"game" binding:"omitempty,max=50,name"`
+    PrizePool float64   `json:"prize_pool" binding:"money"`
+    StartTime time.Time `json:"start_time" binding:"required,future"`
+}
+
 // CreateLeagues handles the creation of a new league.
-func CreateLeagues(c *gin.Context) {
-    var league model.League
-    // Bind JSON request to the league model
-    if err := c.ShouldBindJSON(&league); err != nil {
-        c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-            "status":  http.StatusBadRequest,
-            "message": "failed to bind json",
-        })
+func (s *Service) CreateLeagues(c *gin.Context) {
+    var req CreateLeagueRequest
+    // Bind and validate the JSON request
+    if err := c.ShouldBindJSON(&req); err != nil {
+        apperr.Abort(c, apperr.Invalid(err))
         return
     }
+    league := model.League{Name: req.Name, Game: req.Game, PrizePool: req.PrizePool, StartTime: req.StartTime, CreatedBy: c.GetUint("userid")}
 
     // Check if a league with the same name already exists
-    var existingleague model.League
-    if err := database.DB.Where("name = ?", league.Name).First(&existingleague).Error; err == nil {
-        // If no error, the league already exists
-        c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-            "status":  http.StatusConflict,
-            "message": "this league already exists, please create a different league",
-        })
+    taken, err := s.Leagues.NameTaken(league.Name)
+    if err != nil {
+        apperr.Abort(c, apperr.Internal("internal", "failed to check league name").Wrap(err))
+        return
+    }
+    if taken {
+        apperr.Abort(c, apperr.Conflict("league_exists", "this league already exists, please create a different league"))
         return
     }
 
     // Create a new league in the database
-    if err := database.DB.Create(&league).Error; err != nil {
-        c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-            "status":  http.StatusInternalServerError,
-            "message": "failed to create league in the database",
-        })
+    if err := s.Leagues.Create(&league); err != nil {
+        apperr.Abort(c, apperr.Internal("internal", "failed to create league in the database").Wrap(err))
         return
     }
+    audit.Log(c, audit.Event{Action: audit.ActionCompetitionCreated, TargetType: "league", TargetID: strconv.FormatUint(uint64(league.ID), 10), After: league})
 
     // Respond with success and return the created league details
+    versioning.SetETag(c, league.Version)
     c.JSON(http.StatusOK, gin.H{
         "status":  http.StatusOK,
         "message": "league created successfully",
@@ -49,20 +75,24 @@ func CreateLeagues(c *gin.Context) {
 }
 
 
-// ViewLeagues handles fetching the list of all leagues and their associated teams.
-func ViewLeagues(c *gin.Context) {
-    var leagues []model.League
-    result := database.DB.Preload("Teams").Find(&leagues) // Preload the 'Teams' relationship
-    if result.Error != nil {
-        c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-            "status":  http.StatusBadRequest,
-            "message": "failed to fetch leagues",
-        })
+// leagueFields are the fields of a listed league that clients can select.
+var leagueFields = []string{"league_id", "league_name", "game", "start_time", "prize_pool", "teams"}
+
+// ViewLeagues handles fetching a page of leagues and their associated teams.
+func (s *Service) ViewLeagues(c *gin.Context) {
+    query, ok := listing.Parse(c, leagueFields)
+    if !ok {
+        return
+    }
+    query.Options.WithTeams = query.Wants("teams") // Teams are only loaded when asked for
+    page, err := s.Leagues.List(query.Options)
+    if err != nil {
+        apperr.Abort(c, apperr.Internal("internal", "failed to fetch leagues").Wrap(err))
         return
     }
 
     var leagueview []gin.H
-    for _, fetchleagues := range leagues {
+    for _, fetchleagues := range page.Items {
         // Create a slice to hold team details for each league
         var teamview []gin.H
         for _, team := range fetchleagues.Teams {
@@ -78,57 +108,48 @@ func ViewLeagues(c *gin.Context) {
         details := gin.H{
             "league_id":   fetchleagues.ID,
             "league_name": fetchleagues.Name,
+            "game":        fetchleagues.Game,
             "start_time":  fetchleagues.StartTime,
             "prize_pool":  fetchleagues.PrizePool,
             "teams":       teamview,
         }
-        leagueview = append(leagueview, details)
+        leagueview = append(leagueview, query.Select(details))
     }
 
-    // Respond with the list of leagues and their teams
-    c.JSON(http.StatusOK, gin.H{
-        "status":  http.StatusOK,
-        "message": "fetched leagues successfully",
-        "data":    leagueview,
-    })
+    // Respond with the page of leagues and their teams
+    listing.Respond(c, query, "fetched leagues successfully", leagueview, page.Next)
 }
 
 
 // JoinLeague handles the request for a user to join a league.
-func JoinLeague(c *gin.Context) {
+func (s *Service) JoinLeague(c *gin.Context) {
     id := c.GetUint("userid") // Retrieve user ID from context (usually set during authentication)
     
     // Struct for the incoming request containing the league ID
     var Req struct {
-        LeagueID uint `json:"league_id"`
+        LeagueID uint `json:"league_id" binding:"required"`
     }
 
     // Bind the JSON request to the Req struct
     if err := c.ShouldBindJSON(&Req); err != nil {
-        c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-            "status":  http.StatusBadRequest,
-            "message": "failed to bind json",
-        })
+        apperr.Abort(c, apperr.Invalid(err))
         return
     }
 
     // Find the league and preload its teams
-    var league model.League
-    if err := database.DB.Preload("Teams").First(&league, Req.LeagueID).Error; err != nil {
-        c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-            "status":  http.StatusInternalServerError,
-            "message": "failed to find league",
-        })
+    league, err := s.Leagues.ByID(Req.LeagueID)
+    if errors.Is(err, repository.ErrNotFound) {
+        apperr.Abort(c, apperr.NotFound("league_not_found", "league not found"))
+        return
+    }
+    if err != nil {
+        apperr.Abort(c, apperr.Internal("internal", "failed to find league").Wrap(err))
         return
     }
 
     // Check if the user exists
-    var user model.User
-    if err := database.DB.First(&user, id).Error; err != nil {
-        c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-            "status":  http.StatusNotFound,
-            "message": "user not found",
-        })
+    if _, err := s.Users.ByID(id); err != nil {
+        apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
         return
     }
 
@@ -159,3 +180,155 @@ func JoinLeague(c *gin.Context) {
         "league_details": leagueDetails,
     })
 }
+
+// GetLeague returns a league with its teams.
+func (s *Service) GetLeague(c *gin.Context) {
+    league, ok := s.findLeague(c)
+    if !ok {
+        return
+    }
+
+    versioning.SetETag(c, league.Version)
+    c.JSON(http.StatusOK, gin.H{
+        "status":  http.StatusOK,
+        "message": "fetched league successfully",
+        "data":    league,
+    })
+}
+
+// UpdateLeagueRequest lists the league fields that can be edited. Fields
+// that are not sent are left unchanged.
+type UpdateLeagueRequest struct {
+    Name      *string    `json:"name" binding:"omitempty,max=100,name"`
+    Game      *string    `json:"game" binding:"omitempty,max=50,name"`
+    PrizePool *float64   `json:"prize_pool" binding:"omitempty,money"`
+    StartTime *time.Time `json:"start_time" binding:"omitempty,future"`
+}
+
+// UpdateLeague edits a league that has not started yet. Only its creator and
+// admins can edit it.
+func (s *Service) UpdateLeague(c *gin.Context) {
+    var edit UpdateLeagueRequest
+    // Unknown fields are an error so clients notice that e.g. teams are not edited here
+    decoder := json.NewDecoder(c.Request.Body)
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&edit); err != nil {
+        apperr.Abort(c, apperr.Invalid(err))
+        return
+    }
+    if err := binding.Validator.ValidateStruct(&edit); err != nil {
+        apperr.Abort(c, apperr.Invalid(err))
+        return
+    }
+
+    league, ok := s.findLeague(c)
+    if !ok || !checkCanChange(c, league) || !versioning.CheckIfMatch(c, league.Version) {
+        return
+    }
+
+    updates := map[string]any{}
+    if edit.Name != nil && *edit.Name != league.Name {
+        taken, err := s.Leagues.NameTaken(*edit.Name)
+        if err != nil {
+            apperr.Abort(c, apperr.Internal("internal", "failed to check league name").Wrap(err))
+            return
+        }
+        if taken {
+            apperr.Abort(c, apperr.Conflict("league_exists", "this league already exists, please choose a different name"))
+            return
+        }
+        updates["name"] = *edit.Name
+    }
+    if edit.Game != nil {
+        updates["game"] = *edit.Game
+    }
+    if edit.PrizePool != nil {
+        updates["prize_pool"] = *edit.PrizePool
+    }
+    if edit.StartTime != nil {
+        updates["start_time"] = *edit.StartTime
+    }
+    if len(updates) > 0 {
+        err := s.Leagues.Update(league.ID, league.Version, updates)
+        if errors.Is(err, repository.ErrVersionConflict) {
+            apperr.Abort(c, versioning.ErrMismatch)
+            return
+        }
+        if err != nil {
+            apperr.Abort(c, apperr.Internal("internal", "failed to update league").Wrap(err))
+            return
+        }
+    }
+
+    updated, err := s.Leagues.ByID(league.ID)
+    if err != nil {
+        apperr.Abort(c, apperr.Internal("internal", "failed to find league").Wrap(err))
+        return
+    }
+    audit.Log(c, audit.Event{Action: audit.ActionCompetitionUpdated, TargetType: "league", TargetID: strconv.FormatUint(uint64(league.ID), 10), Before: league, After: updated})
+
+    versioning.SetETag(c, updated.Version)
+    c.JSON(http.StatusOK, gin.H{
+        "status":  http.StatusOK,
+        "message": "league updated successfully",
+        "data":    updated,
+    })
+}
+
+// DeleteLeague soft deletes a league that has not started yet, with its
+// teams. Only its creator and admins can delete it; admins can restore it.
+func (s *Service) DeleteLeague(c *gin.Context) {
+    league, ok := s.findLeague(c)
+    if !ok || !checkCanChange(c, league) || !versioning.CheckIfMatch(c, league.Version) {
+        return
+    }
+
+    err := s.Leagues.Delete(league.ID, league.Version)
+    if errors.Is(err, repository.ErrVersionConflict) {
+        apperr.Abort(c, versioning.ErrMismatch)
+        return
+    }
+    if err != nil {
+        apperr.Abort(c, apperr.Internal("internal", "failed to delete league").Wrap(err))
+        return
+    }
+    audit.Log(c, audit.Event{Action: audit.ActionCompetitionCancel, TargetType: "league", TargetID: strconv.FormatUint(uint64(league.ID), 10), Before: league})
+
+    c.JSON(http.StatusOK, gin.H{
+        "status":  http.StatusOK,
+        "message": "league deleted successfully",
+    })
+}
+
+// findLeague loads the league of the id route parameter, or aborts.
+func (s *Service) findLeague(c *gin.Context) (model.League, bool) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        apperr.Abort(c, apperr.Validation("invalid_id", "invalid league id"))
+        return model.League{}, false
+    }
+    league, err := s.Leagues.ByID(uint(id))
+    if errors.Is(err, repository.ErrNotFound) {
+        apperr.Abort(c, apperr.NotFound("league_not_found", "league not found"))
+        return model.League{}, false
+    }
+    if err != nil {
+        apperr.Abort(c, apperr.Internal("internal", "failed to find league").Wrap(err))
+        return model.League{}, false
+    }
+    return league, true
+}
+
+// checkCanChange aborts unless the caller created the league or is an admin,
+// and the league has not started.
+func checkCanChange(c *gin.Context, league model.League) bool {
+    if !jwt.IsAdmin(c) && (league.CreatedBy == 0 || league.CreatedBy != c.GetUint("userid")) {
+        apperr.Abort(c, apperr.Forbidden("not_owner", "only the creator of the league can change it"))
+        return false
+    }
+    if league.Started(time.Now()) {
+        apperr.Abort(c, apperr.Conflict("competition_started", "the league has started and can no longer be changed"))
+        return false
+    }
+    return true
+}
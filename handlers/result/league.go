@@ -1,29 +1,49 @@
 package result
 
 import (
-	database "gaming/database"
+	"gaming/apperr"
+	"gaming/listing"
 	"gaming/model"
-	"net/http"
+	"gaming/repository"
 
 	"github.com/gin-gonic/gin"
 )
 
-// LeagueResult handles the request to fetch league results along with team details
-func LeagueResult(c *gin.Context) {
-	var league []model.League // Slice to hold the leagues
-
-	// Fetch leagues from the database, preloading the associated Teams
-	result := database.DB.Preload("Teams").Find(&league)
-	if result.Error != nil { // Check for errors during fetching
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to fetch leagues", // Error message if fetching fails
-		})
+// Service handles the result and prize distribution routes. Results and
+// prizes are computed from the team scores, which are reported through the
+// score routes of the teams, see the players package.
+type Service struct {
+	Leagues     repository.LeagueRepo
+	Tournaments repository.TournamentRepo
+}
+
+// NewService returns a Service using the given repositories.
+func NewService(leagues repository.LeagueRepo, tournaments repository.TournamentRepo) *Service {
+	return &Service{Leagues: leagues, Tournaments: tournaments}
+}
+
+// leagueResultFields are the fields of a league result that clients can select.
+var leagueResultFields = []string{"league_name", "prize_pool", "teams"}
+
+// leaguePrizeFields are the fields of a league prize distribution that clients can select.
+var leaguePrizeFields = []string{"league_name", "prize_pool", "teams", "prize_distribution"}
+
+// LeagueResult handles the request to fetch a page of league results along with team details
+func (s *Service) LeagueResult(c *gin.Context) {
+	query, ok := listing.Parse(c, leagueResultFields)
+	if !ok {
+		return
+	}
+	// Fetch a page of leagues from the database along with the associated Teams
+	query.Options.WithTeams = query.Wants("teams")
+	page, err := s.Leagues.List(query.Options)
+	if err != nil { // Check for errors during fetching
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch leagues").Wrap(err))
 		return // Exit if an error occurs
 	}
 
-	var leagueview []gin.H                // Slice to hold formatted league results
-	for _, fetchleagues := range league { // Iterate over each league
+	var leagueview []gin.H                    // Slice to hold formatted league results
+	for _, fetchleagues := range page.Items { // Iterate over each league
 		// Create a slice to hold team details for each league
 		var teamview []gin.H
 		for _, team := range fetchleagues.Teams { // Iterate over teams in the league
@@ -42,33 +62,29 @@ func LeagueResult(c *gin.Context) {
 			"prize_pool":  fetchleagues.PrizePool,
 			"teams":       teamview,
 		}
-		leagueview = append(leagueview, details) // Append league details to the league view
+		leagueview = append(leagueview, query.Select(details)) // Append league details to the league view
 	}
 
-	// Respond with the league results
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "league result fetched successfully",
-		"data":    leagueview, // Data containing leagues and teams
-	})
+	// Respond with the page of league results
+	listing.Respond(c, query, "league result fetched successfully", leagueview, page.Next)
 }
 
-// PriceDistribution handles the request to fetch prize distribution based on team scores
-func PriceDistribution(c *gin.Context) {
-	var league []model.League // Slice to hold the leagues
-
-	// Fetch leagues from the database, preloading the associated Teams
-	result := database.DB.Preload("Teams").Find(&league)
-	if result.Error != nil { // Check for errors during fetching
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to fetch leagues",
-		})
+// PriceDistribution handles the request to fetch a page of prize distributions based on team scores
+func (s *Service) PriceDistribution(c *gin.Context) {
+	query, ok := listing.Parse(c, leaguePrizeFields)
+	if !ok {
+		return
+	}
+	// Fetch a page of leagues from the database along with the associated Teams
+	query.Options.WithTeams = query.Wants("teams") || query.Wants("prize_distribution")
+	page, err := s.Leagues.List(query.Options)
+	if err != nil { // Check for errors during fetching
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch leagues").Wrap(err))
 		return // Exit if an error occurs
 	}
 
-	var leagueview []gin.H                // Slice to hold formatted league results
-	for _, fetchleagues := range league { // Iterate over each league
+	var leagueview []gin.H                    // Slice to hold formatted league results
+	for _, fetchleagues := range page.Items { // Iterate over each league
 		// Create a slice to hold team details for each league
 		var teamview []gin.H
 		var winningTeam *model.Team               // Pointer to hold the winning team
@@ -104,13 +120,9 @@ func PriceDistribution(c *gin.Context) {
 			"teams":              teamview,
 			"prize_distribution": prizeDistribution,
 		}
-		leagueview = append(leagueview, details) // Append league details to the league view
+		leagueview = append(leagueview, query.Select(details)) // Append league details to the league view
 	}
 
-	// Respond with the prize distribution results
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "Congratulations, this team has won in this league",
-		"data":    leagueview, // Data containing leagues, teams, and prize distribution
-	})
+	// Respond with the page of prize distribution results
+	listing.Respond(c, query, "Congratulations, this team has won in this league", leagueview, page.Next)
 }
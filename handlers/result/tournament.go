@@ -1,29 +1,34 @@
 package result
 
 import (
-	database "gaming/database"
-	"gaming/model"
-	"net/http"
+	"gaming/apperr"
+	"gaming/listing"
 
 	"github.com/gin-gonic/gin"
 )
 
-// TournamentResult handles the request to fetch tournament results and winning teams
-func TournamentResult(c *gin.Context) {
-	var tournaments []model.Tournament // Slice to hold tournaments
+// tournamentResultFields are the fields of a tournament result that clients can select.
+var tournamentResultFields = []string{"tournament_id", "tournament_name", "start_time", "prize_pool", "winning_team"}
 
-	// Fetch tournaments from the database, preloading associated TeamA and TeamB relationships
-	result := database.DB.Preload("TeamA").Preload("TeamB").Find(&tournaments)
-	if result.Error != nil { // Check for errors during fetching
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to fetch tournaments", 
-		})
+// tournamentPrizeFields are the fields of a tournament prize distribution that clients can select.
+var tournamentPrizeFields = []string{"tournament_id", "tournament_name", "start_time", "prize_pool"}
+
+// TournamentResult handles the request to fetch a page of tournament results and winning teams
+func (s *Service) TournamentResult(c *gin.Context) {
+	query, ok := listing.Parse(c, tournamentResultFields)
+	if !ok {
+		return
+	}
+	// Fetch a page of tournaments from the database along with the associated TeamA and TeamB
+	query.Options.WithTeams = query.Wants("winning_team")
+	page, err := s.Tournaments.List(query.Options)
+	if err != nil { // Check for errors during fetching
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch tournaments").Wrap(err))
 		return // Exit if an error occurs
 	}
 
 	var tournamentView []gin.H // Slice to hold formatted tournament results
-	for _, tournament := range tournaments { // Iterate over each tournament
+	for _, tournament := range page.Items { // Iterate over each tournament
 		var winningTeam gin.H    // Map to hold the winning team's details
 		var winningScore float64  // Variable to track the highest score
 
@@ -59,33 +64,28 @@ func TournamentResult(c *gin.Context) {
 			"prize_pool":      tournament.PrizePool,  
 			"winning_team":    winningTeam,            
 		}
-		tournamentView = append(tournamentView, details) // Append tournament details to the tournament view
+		tournamentView = append(tournamentView, query.Select(details)) // Append tournament details to the tournament view
 	}
 
-	// Respond with the tournament results
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "This team has won in the tournament",
-		"data":    tournamentView,
-	})
+	// Respond with the page of tournament results
+	listing.Respond(c, query, "This team has won in the tournament", tournamentView, page.Next)
 }
 
-// TournamentPriceDistribution determines the winning team and distributes the prize
-func TournamentPriceDistribution(c *gin.Context) {
-	var tournaments []model.Tournament // Slice to hold tournaments
-
-	// Fetch tournaments from the database, preloading associated TeamA and TeamB relationships
-	result := database.DB.Preload("TeamA").Preload("TeamB").Find(&tournaments)
-	if result.Error != nil { // Check for errors during fetching
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to fetch tournaments", 
-		})
+// TournamentPriceDistribution determines the winning team and distributes the prize, a page of tournaments at a time
+func (s *Service) TournamentPriceDistribution(c *gin.Context) {
+	query, ok := listing.Parse(c, tournamentPrizeFields)
+	if !ok {
+		return
+	}
+	// Fetch a page of tournaments from the database; their teams are not shown
+	page, err := s.Tournaments.List(query.Options)
+	if err != nil { // Check for errors during fetching
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch tournaments").Wrap(err))
 		return // Exit if an error occurs
 	}
 
 	var tournamentView []gin.H // Slice to hold formatted tournament results
-	for _, tournament := range tournaments { // Iterate over each tournament
+	for _, tournament := range page.Items { // Iterate over each tournament
 		// Create a map for tournament details
 		details := gin.H{
 			"tournament_id":   tournament.ID,         
@@ -93,13 +93,9 @@ func TournamentPriceDistribution(c *gin.Context) {
 			"start_time":      tournament.StartTime,  
 			"prize_pool":      tournament.PrizePool, 
 		}
-		tournamentView = append(tournamentView, details) // Append tournament details to the tournament view
+		tournamentView = append(tournamentView, query.Select(details)) // Append tournament details to the tournament view
 	}
 
-	// Respond with the tournament prize distribution results
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "Congratulations, you have won in this tournament",
-		"data":    tournamentView,
-	})
+	// Respond with the page of tournament prize distribution results
+	listing.Respond(c, query, "Congratulations, you have won in this tournament", tournamentView, page.Next)
 }
\ No newline at end of file
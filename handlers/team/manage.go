@@ -0,0 +1,260 @@
+package players
+
+import (
+	"encoding/json"
+	"errors"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/jwt"
+	"gaming/repository"
+	"gaming/versioning"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// teamRecord is a team of any kind, with what the update guards need to know
+// about its competition.
+type teamRecord struct {
+	team      any // the model, as returned to the client
+	name      string
+	version   uint
+	playerID  uint
+	createdBy uint // creator of the competition
+	started   bool // the competition has started
+}
+
+// teamTable gives the handlers below access to one of the team tables.
+type teamTable struct {
+	kind      string // target type in the audit log
+	find      func(id uint) (teamRecord, error)
+	nameTaken func(name string) (bool, error)
+	update    func(id, version uint, fields map[string]any) error
+	delete    func(id, version uint) error
+}
+
+func (s *Service) leagueTeams() teamTable {
+	return teamTable{
+		kind: "team",
+		find: func(id uint) (teamRecord, error) {
+			team, err := s.Teams.LeagueTeam(id)
+			if err != nil {
+				return teamRecord{}, err
+			}
+			record := teamRecord{team: team, name: team.Name, version: team.Version, playerID: team.PlayerID}
+			league, err := s.Leagues.ByID(team.LeagueID)
+			if errors.Is(err, repository.ErrNotFound) {
+				return record, nil
+			}
+			record.createdBy, record.started = league.CreatedBy, league.Started(time.Now())
+			return record, err
+		},
+		nameTaken: s.Teams.LeagueTeamNameTaken,
+		update:    s.Teams.UpdateLeagueTeam,
+		delete:    s.Teams.DeleteLeagueTeam,
+	}
+}
+
+func (s *Service) teamsA() teamTable {
+	return teamTable{
+		kind: "team_a",
+		find: func(id uint) (teamRecord, error) {
+			team, err := s.Teams.TeamA(id)
+			if err != nil {
+				return teamRecord{}, err
+			}
+			return s.withTournament(teamRecord{team: team, name: team.Name, version: team.Version, playerID: team.PlayerID}, team.TournamentID)
+		},
+		nameTaken: s.Teams.TeamANameTaken,
+		update:    s.Teams.UpdateTeamA,
+		delete:    s.Teams.DeleteTeamA,
+	}
+}
+
+func (s *Service) teamsB() teamTable {
+	return teamTable{
+		kind: "team_b",
+		find: func(id uint) (teamRecord, error) {
+			team, err := s.Teams.TeamB(id)
+			if err != nil {
+				return teamRecord{}, err
+			}
+			return s.withTournament(teamRecord{team: team, name: team.Name, version: team.Version, playerID: team.PlayerID}, team.TournamentID)
+		},
+		nameTaken: s.Teams.TeamBNameTaken,
+		update:    s.Teams.UpdateTeamB,
+		delete:    s.Teams.DeleteTeamB,
+	}
+}
+
+// withTournament fills in the competition of a tournament team.
+func (s *Service) withTournament(record teamRecord, tournamentID uint) (teamRecord, error) {
+	tournament, err := s.Tournaments.ByID(tournamentID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return record, nil
+	}
+	record.createdBy, record.started = tournament.CreatedBy, tournament.Started(time.Now())
+	return record, err
+}
+
+// GetTeam returns a league team.
+func (s *Service) GetTeam(c *gin.Context) { s.getTeam(c, s.leagueTeams()) }
+
+// UpdateTeam edits a league team.
+func (s *Service) UpdateTeam(c *gin.Context) { s.updateTeam(c, s.leagueTeams()) }
+
+// DeleteTeam soft deletes a league team.
+func (s *Service) DeleteTeam(c *gin.Context) { s.deleteTeam(c, s.leagueTeams()) }
+
+// GetTeamA returns an A side tournament team.
+func (s *Service) GetTeamA(c *gin.Context) { s.getTeam(c, s.teamsA()) }
+
+// UpdateTeamA edits an A side tournament team.
+func (s *Service) UpdateTeamA(c *gin.Context) { s.updateTeam(c, s.teamsA()) }
+
+// DeleteTeamA soft deletes an A side tournament team.
+func (s *Service) DeleteTeamA(c *gin.Context) { s.deleteTeam(c, s.teamsA()) }
+
+// GetTeamB returns a B side tournament team.
+func (s *Service) GetTeamB(c *gin.Context) { s.getTeam(c, s.teamsB()) }
+
+// UpdateTeamB edits a B side tournament team.
+func (s *Service) UpdateTeamB(c *gin.Context) { s.updateTeam(c, s.teamsB()) }
+
+// DeleteTeamB soft deletes a B side tournament team.
+func (s *Service) DeleteTeamB(c *gin.Context) { s.deleteTeam(c, s.teamsB()) }
+
+func (s *Service) getTeam(c *gin.Context, table teamTable) {
+	_, record, ok := findTeam(c, table)
+	if !ok {
+		return
+	}
+
+	versioning.SetETag(c, record.version)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "fetched team successfully",
+		"data":    record.team,
+	})
+}
+
+// UpdateTeamRequest lists the team fields that can be edited. Scores are
+// reported through ReportScore instead.
+type UpdateTeamRequest struct {
+	Name *string `json:"name" binding:"omitempty,max=100,name"`
+}
+
+func (s *Service) updateTeam(c *gin.Context, table teamTable) {
+	var edit UpdateTeamRequest
+	// Unknown fields are an error so clients notice that e.g. the score is reported elsewhere
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&edit); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&edit); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	id, record, ok := findTeam(c, table)
+	if !ok || !checkCanChange(c, record) || !versioning.CheckIfMatch(c, record.version) {
+		return
+	}
+
+	if edit.Name != nil && *edit.Name != record.name {
+		taken, err := table.nameTaken(*edit.Name)
+		if err != nil {
+			apperr.Abort(c, apperr.Internal("internal", "failed to check team name").Wrap(err))
+			return
+		}
+		if taken {
+			apperr.Abort(c, apperr.Conflict("team_exists", "this team already exists, please choose a different name"))
+			return
+		}
+		err = table.update(id, record.version, map[string]any{"name": *edit.Name})
+		if errors.Is(err, repository.ErrVersionConflict) {
+			apperr.Abort(c, versioning.ErrMismatch)
+			return
+		}
+		if err != nil {
+			apperr.Abort(c, apperr.Internal("internal", "failed to update team").Wrap(err))
+			return
+		}
+	}
+
+	updated, err := table.find(id)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to find team").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionTeamUpdated, TargetType: table.kind, TargetID: strconv.FormatUint(uint64(id), 10), Before: record.team, After: updated.team})
+
+	versioning.SetETag(c, updated.version)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "team updated successfully",
+		"data":    updated.team,
+	})
+}
+
+func (s *Service) deleteTeam(c *gin.Context, table teamTable) {
+	id, record, ok := findTeam(c, table)
+	if !ok || !checkCanChange(c, record) || !versioning.CheckIfMatch(c, record.version) {
+		return
+	}
+
+	err := table.delete(id, record.version)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		apperr.Abort(c, versioning.ErrMismatch)
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to delete team").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionTeamDeleted, TargetType: table.kind, TargetID: strconv.FormatUint(uint64(id), 10), Before: record.team})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "team deleted successfully",
+	})
+}
+
+// findTeam loads the team of the id route parameter from the table, or aborts.
+func findTeam(c *gin.Context, table teamTable) (uint, teamRecord, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Abort(c, apperr.Validation("invalid_id", "invalid team id"))
+		return 0, teamRecord{}, false
+	}
+	record, err := table.find(uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Abort(c, apperr.NotFound("team_not_found", "team not found"))
+		return 0, teamRecord{}, false
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to find team").Wrap(err))
+		return 0, teamRecord{}, false
+	}
+	return uint(id), record, true
+}
+
+// checkCanChange aborts unless the caller plays for the team, created its
+// competition or is an admin, and the competition has not started.
+func checkCanChange(c *gin.Context, record teamRecord) bool {
+	userID := c.GetUint("userid")
+	if !jwt.IsAdmin(c) && record.playerID != userID && (record.createdBy == 0 || record.createdBy != userID) {
+		apperr.Abort(c, apperr.Forbidden("not_owner", "only the player of the team or the creator of its competition can change it"))
+		return false
+	}
+	if record.started {
+		apperr.Abort(c, apperr.Conflict("competition_started", "the competition has started and the team can no longer be changed"))
+		return false
+	}
+	return true
+}
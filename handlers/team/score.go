@@ -0,0 +1,90 @@
+package players
+
+import (
+	"errors"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/jwt"
+	"gaming/repository"
+	"gaming/versioning"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReportScoreRequest is the payload accepted by the score routes.
+type ReportScoreRequest struct {
+	Score *float64 `json:"score" binding:"required,gte=0,lte=1000000"`
+}
+
+// ReportScore sets the score of a league team.
+func (s *Service) ReportScore(c *gin.Context) { s.reportScore(c, s.leagueTeams()) }
+
+// ReportScoreA sets the score of an A side tournament team.
+func (s *Service) ReportScoreA(c *gin.Context) { s.reportScore(c, s.teamsA()) }
+
+// ReportScoreB sets the score of a B side tournament team.
+func (s *Service) ReportScoreB(c *gin.Context) { s.reportScore(c, s.teamsB()) }
+
+// reportScore sets the score that the results and prizes are computed from.
+// The request must send the ETag of the team in If-Match, so that of two
+// reports based on the same read the second gets 412 instead of silently
+// replacing the first.
+func (s *Service) reportScore(c *gin.Context, table teamTable) {
+	var req ReportScoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	id, record, ok := findTeam(c, table)
+	if !ok || !checkCanReport(c, record) || !versioning.RequireIfMatch(c, record.version) {
+		return
+	}
+
+	// The team row stays locked from the version check to the write
+	err := table.update(id, record.version, map[string]any{"score": *req.Score})
+	if errors.Is(err, repository.ErrVersionConflict) {
+		apperr.Abort(c, versioning.ErrMismatch)
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to report score").Wrap(err))
+		return
+	}
+
+	updated, err := table.find(id)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to find team").Wrap(err))
+		return
+	}
+	// The player of the team reports its score, anyone else overrides it
+	action := audit.ActionResultReported
+	if record.playerID != c.GetUint("userid") {
+		action = audit.ActionResultOverridden
+	}
+	audit.Log(c, audit.Event{Action: action, TargetType: table.kind, TargetID: strconv.FormatUint(uint64(id), 10), Before: record.team, After: updated.team})
+
+	versioning.SetETag(c, updated.version)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "score reported successfully",
+		"data":    updated.team,
+	})
+}
+
+// checkCanReport aborts unless the caller plays for the team, created its
+// competition or is an admin, and the competition has started.
+func checkCanReport(c *gin.Context, record teamRecord) bool {
+	userID := c.GetUint("userid")
+	if !jwt.IsAdmin(c) && record.playerID != userID && (record.createdBy == 0 || record.createdBy != userID) {
+		apperr.Abort(c, apperr.Forbidden("not_owner", "only the player of the team or the creator of its competition can report its score"))
+		return false
+	}
+	if !record.started {
+		apperr.Abort(c, apperr.Conflict("competition_not_started", "the competition has not started, there is no score to report yet"))
+		return false
+	}
+	return true
+}
@@ -1,62 +1,82 @@
 package players
 
 import (
-	database "gaming/database"
+	"gaming/apperr"
 	"gaming/model"
+	"gaming/repository"
+	"gaming/versioning"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func CreateTeam(c *gin.Context) {
-	var team model.Team
-	// Bind the incoming JSON to the team struct
-	if err := c.ShouldBindJSON(&team); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to bind JSON",
-		})
+// Service handles the team routes.
+type Service struct {
+	Teams       repository.TeamRepo
+	Leagues     repository.LeagueRepo
+	Tournaments repository.TournamentRepo
+	Users       repository.UserRepo
+}
+
+// NewService returns a Service using the given repositories.
+func NewService(teams repository.TeamRepo, leagues repository.LeagueRepo, tournaments repository.TournamentRepo, users repository.UserRepo) *Service {
+	return &Service{Teams: teams, Leagues: leagues, Tournaments: tournaments, Users: users}
+}
+
+// CreateTeamRequest is the payload accepted by CreateTeam. Scores are
+// reported once the competition has started, see ReportScore.
+type CreateTeamRequest struct {
+	Name     string `json:"name" binding:"required,max=100,name"`
+	PlayerID uint   `json:"player_id" binding:"required"`
+	LeagueID uint   `json:"league_id" binding:"required"`
+}
+
+// CreateTournamentTeamRequest is the payload accepted by CreateTeamA and
+// CreateTeamB.
+type CreateTournamentTeamRequest struct {
+	Name         string `json:"name" binding:"required,max=100,name"`
+	PlayerID     uint   `json:"player_id" binding:"required"`
+	TournamentID uint   `json:"tournament_id" binding:"required"`
+}
+
+// CreateTeam creates a team in a league
+func (s *Service) CreateTeam(c *gin.Context) {
+	var req CreateTeamRequest
+	// Bind and validate the incoming JSON
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
+	team := model.Team{Name: req.Name, PlayerID: req.PlayerID, LeagueID: req.LeagueID}
 
 	// Check if the user exists
-	var user model.User
-	if err := database.DB.First(&user, team.PlayerID).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "user not found",
-		})
+	if _, err := s.Users.ByID(team.PlayerID); err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
 		return
 	}
 
-	var league model.League
-	if err := database.DB.First(&league, team.LeagueID).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "league not found",
-		})
+	if _, err := s.Leagues.ByID(team.LeagueID); err != nil {
+		apperr.Abort(c, apperr.NotFound("league_not_found", "league not found"))
 		return
 	}
 	// Check if a team with the same name already exists
-	var existingTeam model.Team
-	if err := database.DB.Where("name = ?", team.Name).First(&existingTeam).Error; err == nil {
-		// If no error, it means the team already exists
-		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-			"status":  http.StatusConflict,
-			"message": "this team already exists, please create a different team",
-		})
+	taken, err := s.Teams.LeagueTeamNameTaken(team.Name)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to check team name").Wrap(err))
+		return
+	}
+	if taken {
+		apperr.Abort(c, apperr.Conflict("team_exists", "this team already exists, please create a different team"))
 		return
 	}
 	// Proceed to create the team since it doesn't already exist
-	if err := database.DB.Create(&team).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to create team",
-		})
+	if err := s.Teams.CreateLeagueTeam(&team); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to create team").Wrap(err))
 		return
 	}
 
 	// Successful response
+	versioning.SetETag(c, team.Version)
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
 		"message": "team created successfully",
@@ -64,57 +84,45 @@ func CreateTeam(c *gin.Context) {
 	})
 }
 
-func CreateTeamA(c *gin.Context) {
-	var team model.TeamA
+func (s *Service) CreateTeamA(c *gin.Context) {
+	var req CreateTournamentTeamRequest
 
-	// Bind the incoming JSON to the team struct
-	if err := c.ShouldBindJSON(&team); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to bind JSON",
-		})
+	// Bind and validate the incoming JSON
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
+	team := model.TeamA{Name: req.Name, PlayerID: req.PlayerID, TournamentID: req.TournamentID}
 
-	var tournament model.Tournament
-	if err := database.DB.First(&tournament, team.TournamentID).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "tournament not found",
-		})
+	if _, err := s.Tournaments.ByID(team.TournamentID); err != nil {
+		apperr.Abort(c, apperr.NotFound("tournament_not_found", "tournament not found"))
 		return
 	}
 	// Check if the user exists
-	var user model.User
-	if err := database.DB.First(&user, team.PlayerID).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "user not found",
-		})
+	if _, err := s.Users.ByID(team.PlayerID); err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
 		return
 	}
 
 	// Check if a team with the same name already exists
-	var existingTeam model.TeamA
-	if err := database.DB.Where("name = ?", team.Name).First(&existingTeam).Error; err == nil {
-		// If no error, it means the team already exists
-		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-			"status":  http.StatusConflict,
-			"message": "this team already exists, please create a different team",
-		})
+	taken, err := s.Teams.TeamANameTaken(team.Name)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to check team name").Wrap(err))
+		return
+	}
+	if taken {
+		apperr.Abort(c, apperr.Conflict("team_exists", "this team already exists, please create a different team"))
 		return
 	}
 
 	// Proceed to create the team since it doesn't already exist
-	if err := database.DB.Create(&team).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to create team",
-		})
+	if err := s.Teams.CreateTeamA(&team); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to create team").Wrap(err))
 		return
 	}
 
 	// Successful response
+	versioning.SetETag(c, team.Version)
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
 		"message": "team created successfully",
@@ -122,56 +130,44 @@ func CreateTeamA(c *gin.Context) {
 	})
 }
 
-func CreateTeamB(c *gin.Context) {
-	var team model.TeamB
-	// Bind the incoming JSON to the team struct
-	if err := c.ShouldBindJSON(&team); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to bind JSON",
-		})
+func (s *Service) CreateTeamB(c *gin.Context) {
+	var req CreateTournamentTeamRequest
+	// Bind and validate the incoming JSON
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
+	team := model.TeamB{Name: req.Name, PlayerID: req.PlayerID, TournamentID: req.TournamentID}
 
-	var tournament model.Tournament
-	if err := database.DB.First(&tournament, team.TournamentID).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "tournament not found",
-		})
+	if _, err := s.Tournaments.ByID(team.TournamentID); err != nil {
+		apperr.Abort(c, apperr.NotFound("tournament_not_found", "tournament not found"))
 		return
 	}
 	// Check if the user exists
-	var user model.User
-	if err := database.DB.First(&user, team.PlayerID).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "user not found",
-		})
+	if _, err := s.Users.ByID(team.PlayerID); err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
 		return
 	}
 
 	// Check if a team with the same name already exists
-	var existingTeam model.TeamB
-	if err := database.DB.Where("name = ?", team.Name).First(&existingTeam).Error; err == nil {
-		// If no error, it means the team already exists
-		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-			"status":  http.StatusConflict,
-			"message": "this team already exists, please create a different team",
-		})
+	taken, err := s.Teams.TeamBNameTaken(team.Name)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to check team name").Wrap(err))
+		return
+	}
+	if taken {
+		apperr.Abort(c, apperr.Conflict("team_exists", "this team already exists, please create a different team"))
 		return
 	}
 
 	// Proceed to create the team since it doesn't already exist
-	if err := database.DB.Create(&team).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to create team",
-		})
+	if err := s.Teams.CreateTeamB(&team); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to create team").Wrap(err))
 		return
 	}
 
 	// Successful response
+	versioning.SetETag(c, team.Version)
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
 		"message": "team created successfully",
@@ -1,50 +1,75 @@
 package tournament
 
 import (
+	"encoding/json"
+	"errors"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/jwt"
+	"gaming/listing"
 	"gaming/model"
+	"gaming/repository"
+	"gaming/versioning"
 	"net/http"
-
-	database "gaming/database"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
+// Service handles the tournament routes.
+type Service struct {
+	Tournaments repository.TournamentRepo
+	Users       repository.UserRepo
+}
+
+// NewService returns a Service using the given repositories.
+func NewService(tournaments repository.TournamentRepo, users repository.UserRepo) *Service {
+	return &Service{Tournaments: tournaments, Users: users}
+}
+
+// CreateTournamentRequest is the payload accepted by CreateTournament
+type CreateTournamentRequest struct {
+	Name      string    `json:"name" binding:"required,max=100,name"`
+	Game      string    `json:"game" binding:"omitempty,max=50,name"`
+	PrizePool float64   `json:"prize_pool" binding:"money"`
+	StartTime time.Time `json:"start_time" binding:"required,future"`
+}
+
 // CreateTournament handles the creation of a new tournament.
-func CreateTournament(c *gin.Context) {
-	var tournament model.Tournament
+func (s *Service) CreateTournament(c *gin.Context) {
+	var req CreateTournamentRequest
 
-	// Bind the incoming JSON request to the tournament model
-	if err := c.ShouldBindJSON(&tournament); err != nil {
+	// Bind and validate the incoming JSON request
+	if err := c.ShouldBindJSON(&req); err != nil {
 		// If binding fails, respond with a Bad Request status
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to bind json",
-		})
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
+	tournament := model.Tournament{Name: req.Name, Game: req.Game, PrizePool: req.PrizePool, StartTime: req.StartTime, CreatedBy: c.GetUint("userid")}
 
 	// Check if a tournament with the same name already exists
-	var existingTournament model.Tournament
-	if err := database.DB.Where("name = ?", tournament.Name).First(&existingTournament).Error; err == nil {
-		// If no error, it means the tournament already exists
-		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-			"status":  http.StatusConflict,
-			"message": "this tournament already exists, please create a different tournament",
-		})
+	taken, err := s.Tournaments.NameTaken(tournament.Name)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to check tournament name").Wrap(err))
+		return
+	}
+	if taken {
+		apperr.Abort(c, apperr.Conflict("tournament_exists", "this tournament already exists, please create a different tournament"))
 		return
 	}
 
 	// Create the new tournament in the database
-	if err := database.DB.Create(&tournament).Error; err != nil {
+	if err := s.Tournaments.Create(&tournament); err != nil {
 		// If creation fails, respond with an Internal Server Error status
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to create tournament in the database",
-		})
+		apperr.Abort(c, apperr.Internal("internal", "failed to create tournament in the database").Wrap(err))
 		return
 	}
+	audit.Log(c, audit.Event{Action: audit.ActionCompetitionCreated, TargetType: "tournament", TargetID: strconv.FormatUint(uint64(tournament.ID), 10), After: tournament})
 
 	// Respond with success and return the created tournament details
+	versioning.SetETag(c, tournament.Version)
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
 		"message": "tournament created successfully",
@@ -52,24 +77,27 @@ func CreateTournament(c *gin.Context) {
 	})
 }
 
-// ViewTournaments handles fetching the list of all tournaments along with their teams.
-func ViewTournaments(c *gin.Context) {
-	var tournaments []model.Tournament
-
-	// Fetch all tournaments and preload their associated teams
-	result := database.DB.Preload("TeamA").Preload("TeamB").Find(&tournaments)
-	if result.Error != nil {
-		// If fetching fails, respond with a Bad Request status
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to fetch tournaments",
-		})
+// tournamentFields are the fields of a listed tournament that clients can select.
+var tournamentFields = []string{"tournament_id", "tournament_name", "game", "start_time", "prize_pool", "team_a", "team_b"}
+
+// ViewTournaments handles fetching a page of tournaments along with their teams.
+func (s *Service) ViewTournaments(c *gin.Context) {
+	query, ok := listing.Parse(c, tournamentFields)
+	if !ok {
+		return
+	}
+	// Fetch the page of tournaments, with their teams when asked for
+	query.Options.WithTeams = query.Wants("team_a") || query.Wants("team_b")
+	page, err := s.Tournaments.List(query.Options)
+	if err != nil {
+		// If fetching fails, respond with an Internal Server Error status
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch tournaments").Wrap(err))
 		return
 	}
 
 	var tournamentView []gin.H
 	// Iterate through each tournament to build the response structure
-	for _, tournament := range tournaments {
+	for _, tournament := range page.Items {
 		// Prepare Team A details
 		var teamAView []gin.H
 		for _, teamA := range tournament.TeamA {
@@ -96,57 +124,48 @@ func ViewTournaments(c *gin.Context) {
 		details := gin.H{
 			"tournament_id":   tournament.ID,
 			"tournament_name": tournament.Name,
+			"game":            tournament.Game,
 			"start_time":      tournament.StartTime,
 			"prize_pool":      tournament.PrizePool,
 			"team_a":          teamAView,
 			"team_b":          teamBView,
 		}
-		tournamentView = append(tournamentView, details)
+		tournamentView = append(tournamentView, query.Select(details))
 	}
 
-	// Respond with the list of tournaments and their details
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "fetched tournaments successfully",
-		"data":    tournamentView,
-	})
+	// Respond with the page of tournaments and their details
+	listing.Respond(c, query, "fetched tournaments successfully", tournamentView, page.Next)
 }
 
 // JoinTournament handles a user's request to join a tournament.
-func JoinTournament(c *gin.Context) {
+func (s *Service) JoinTournament(c *gin.Context) {
 	id := c.GetUint("userid") // Retrieve user ID from context
 
 	// Struct to capture the incoming request for joining a tournament
 	var Req struct {
-		TournamentID uint `json:"tournament_id"` // tournament ID from request
+		TournamentID uint `json:"tournament_id" binding:"required"` // tournament ID from request
 	}
 
 	// Bind the JSON request to the Req struct
 	if err := c.ShouldBindJSON(&Req); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to bind json",
-		})
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
 
-	// Find the tournament and preload TeamA and TeamB
-	var tournament model.Tournament
-	if err := database.DB.Preload("TeamA").Preload("TeamB").First(&tournament, Req.TournamentID).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to find tournament",
-		})
+	// Find the tournament along with TeamA and TeamB
+	tournament, err := s.Tournaments.ByID(Req.TournamentID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Abort(c, apperr.NotFound("tournament_not_found", "tournament not found"))
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to find tournament").Wrap(err))
 		return
 	}
 
 	// Check if the user exists in the database
-	var user model.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "user not found",
-		})
+	if _, err := s.Users.ByID(id); err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
 		return
 	}
 
@@ -188,4 +207,156 @@ func JoinTournament(c *gin.Context) {
 		"message":         "You joined this tournament",
 		"tournament_info": tournamentDetails,
 	})
-}
\ No newline at end of file
+}
+
+// GetTournament returns a tournament with the teams of both sides.
+func (s *Service) GetTournament(c *gin.Context) {
+	tournament, ok := s.findTournament(c)
+	if !ok {
+		return
+	}
+
+	versioning.SetETag(c, tournament.Version)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "fetched tournament successfully",
+		"data":    tournament,
+	})
+}
+
+// UpdateTournamentRequest lists the tournament fields that can be edited.
+// Fields that are not sent are left unchanged.
+type UpdateTournamentRequest struct {
+	Name      *string    `json:"name" binding:"omitempty,max=100,name"`
+	Game      *string    `json:"game" binding:"omitempty,max=50,name"`
+	PrizePool *float64   `json:"prize_pool" binding:"omitempty,money"`
+	StartTime *time.Time `json:"start_time" binding:"omitempty,future"`
+}
+
+// UpdateTournament edits a tournament that has not started yet. Only its
+// creator and admins can edit it.
+func (s *Service) UpdateTournament(c *gin.Context) {
+	var edit UpdateTournamentRequest
+	// Unknown fields are an error so clients notice that e.g. teams are not edited here
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&edit); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&edit); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	tournament, ok := s.findTournament(c)
+	if !ok || !checkCanChange(c, tournament) || !versioning.CheckIfMatch(c, tournament.Version) {
+		return
+	}
+
+	updates := map[string]any{}
+	if edit.Name != nil && *edit.Name != tournament.Name {
+		taken, err := s.Tournaments.NameTaken(*edit.Name)
+		if err != nil {
+			apperr.Abort(c, apperr.Internal("internal", "failed to check tournament name").Wrap(err))
+			return
+		}
+		if taken {
+			apperr.Abort(c, apperr.Conflict("tournament_exists", "this tournament already exists, please choose a different name"))
+			return
+		}
+		updates["name"] = *edit.Name
+	}
+	if edit.Game != nil {
+		updates["game"] = *edit.Game
+	}
+	if edit.PrizePool != nil {
+		updates["prize_pool"] = *edit.PrizePool
+	}
+	if edit.StartTime != nil {
+		updates["start_time"] = *edit.StartTime
+	}
+	if len(updates) > 0 {
+		err := s.Tournaments.Update(tournament.ID, tournament.Version, updates)
+		if errors.Is(err, repository.ErrVersionConflict) {
+			apperr.Abort(c, versioning.ErrMismatch)
+			return
+		}
+		if err != nil {
+			apperr.Abort(c, apperr.Internal("internal", "failed to update tournament").Wrap(err))
+			return
+		}
+	}
+
+	updated, err := s.Tournaments.ByID(tournament.ID)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to find tournament").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionCompetitionUpdated, TargetType: "tournament", TargetID: strconv.FormatUint(uint64(tournament.ID), 10), Before: tournament, After: updated})
+
+	versioning.SetETag(c, updated.Version)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "tournament updated successfully",
+		"data":    updated,
+	})
+}
+
+// DeleteTournament soft deletes a tournament that has not started yet, with
+// its teams. Only its creator and admins can delete it; admins can restore it.
+func (s *Service) DeleteTournament(c *gin.Context) {
+	tournament, ok := s.findTournament(c)
+	if !ok || !checkCanChange(c, tournament) || !versioning.CheckIfMatch(c, tournament.Version) {
+		return
+	}
+
+	err := s.Tournaments.Delete(tournament.ID, tournament.Version)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		apperr.Abort(c, versioning.ErrMismatch)
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to delete tournament").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionCompetitionCancel, TargetType: "tournament", TargetID: strconv.FormatUint(uint64(tournament.ID), 10), Before: tournament})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "tournament deleted successfully",
+	})
+}
+
+// findTournament loads the tournament of the id route parameter, or aborts.
+func (s *Service) findTournament(c *gin.Context) (model.Tournament, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Abort(c, apperr.Validation("invalid_id", "invalid tournament id"))
+		return model.Tournament{}, false
+	}
+	tournament, err := s.Tournaments.ByID(uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Abort(c, apperr.NotFound("tournament_not_found", "tournament not found"))
+		return model.Tournament{}, false
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to find tournament").Wrap(err))
+		return model.Tournament{}, false
+	}
+	return tournament, true
+}
+
+// checkCanChange aborts unless the caller created the tournament or is an
+// admin, and the tournament has not started.
+func checkCanChange(c *gin.Context, tournament model.Tournament) bool {
+	if !jwt.IsAdmin(c) && (tournament.CreatedBy == 0 || tournament.CreatedBy != c.GetUint("userid")) {
+		apperr.Abort(c, apperr.Forbidden("not_owner", "only the creator of the tournament can change it"))
+		return false
+	}
+	if tournament.Started(time.Now()) {
+		apperr.Abort(c, apperr.Conflict("competition_started", "the tournament has started and can no longer be changed"))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gaming/account"
+	"gaming/apperr"
+	"gaming/audit"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ExportData returns all personal data stored about the user, as JSON or,
+// with ?format=zip, as a ZIP archive of JSON files
+func (s *Service) ExportData(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		apperr.Abort(c, apperr.Validation("invalid_format", "format must be json or zip"))
+		return
+	}
+
+	export, err := s.Accounts.BuildExport(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to export data").Wrap(err))
+		return
+	}
+
+	filename := fmt.Sprintf("user-%d-export-%s", userid, export.GeneratedAt.Format("20060102"))
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	// Build the archive first so a failure can still be reported as an error
+	var buf bytes.Buffer
+	if err := export.WriteZIP(&buf); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to build export archive").Wrap(err))
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// RequestDeletion schedules the account for deletion after the grace period.
+// The password, and the second factor when enabled, confirm the request.
+func (s *Service) RequestDeletion(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	// Accounts created through social login may have no password
+	if existinguser.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(existinguser.Password), []byte(req.Password)); err != nil {
+			apperr.Abort(c, apperr.Unauthorized("incorrect_password", "incorrect password"))
+			return
+		}
+	}
+	if existinguser.TOTPEnabled && !s.verifySecondFactor(&existinguser, req.Code, req.RecoveryCode) {
+		apperr.Abort(c, apperr.Unauthorized("invalid_code", "invalid two-factor code"))
+		return
+	}
+
+	at, err := s.Accounts.ScheduleDeletion(userid)
+	if errors.Is(err, account.ErrDeletionScheduled) {
+		apperr.Abort(c, apperr.Conflict("deletion_scheduled", "account deletion is already scheduled"))
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to schedule account deletion").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionDeletionRequested, Details: map[string]any{"deletion_scheduled_at": at}})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "account scheduled for deletion, you can cancel until then",
+		"data":    gin.H{"deletion_scheduled_at": at},
+	})
+}
+
+// CancelDeletion cancels a scheduled account deletion
+func (s *Service) CancelDeletion(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	err := s.Accounts.CancelDeletion(userid)
+	if errors.Is(err, account.ErrNoDeletionScheduled) {
+		apperr.Abort(c, apperr.NotFound("no_deletion_scheduled", "no account deletion is scheduled"))
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to cancel account deletion").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionDeletionCancelled})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "account deletion cancelled",
+	})
+}
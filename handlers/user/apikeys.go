@@ -0,0 +1,129 @@
+package user
+
+import (
+	"errors"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/jwt"
+	"gaming/model"
+	"gaming/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAPIKeys is the number of active keys a user can have
+const maxAPIKeys = 20
+
+// CreateAPIKey creates a named API key with scopes and an optional expiry.
+// The key is returned in this response only; just its hash is stored.
+func (s *Service) CreateAPIKey(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Name          string   `json:"name" binding:"required,max=64"`
+		Scopes        []string `json:"scopes" binding:"required,min=1"`
+		ExpiresInDays int      `json:"expires_in_days" binding:"min=0,max=365"` // 0 means no expiry
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !jwt.ValidScopes[scope] {
+			apperr.Abort(c, apperr.Validation("unknown_scope", "unknown scope "+scope))
+			return
+		}
+	}
+
+	count, err := s.APIKeys.CountActive(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to count api keys").Wrap(err))
+		return
+	}
+	if count >= maxAPIKeys {
+		apperr.Abort(c, apperr.Conflict("api_key_limit", "api key limit reached, revoke an unused key first"))
+		return
+	}
+
+	key, prefix, hash, err := jwt.GenerateAPIKey()
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to generate api key").Wrap(err))
+		return
+	}
+	apiKey := model.APIKey{
+		UserID:  userid,
+		Name:    req.Name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  strings.Join(req.Scopes, ","),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := s.APIKeys.Create(&apiKey); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to store api key").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionAPIKeyCreated, TargetType: "api_key", TargetID: strconv.FormatUint(uint64(apiKey.ID), 10), After: apiKey})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "api key created, copy it now as it will not be shown again",
+		"data": gin.H{
+			"id":         apiKey.ID,
+			"name":       apiKey.Name,
+			"key":        key,
+			"scopes":     req.Scopes,
+			"expires_at": apiKey.ExpiresAt,
+		},
+	})
+}
+
+// ListAPIKeys returns the user's API keys without their secrets
+func (s *Service) ListAPIKeys(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	apiKeys, err := s.APIKeys.List(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch api keys").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "fetched api keys successfully",
+		"data":    apiKeys,
+	})
+}
+
+// RevokeAPIKey revokes one of the user's API keys
+func (s *Service) RevokeAPIKey(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Abort(c, apperr.Validation("invalid_id", "invalid api key id"))
+		return
+	}
+
+	err = s.APIKeys.Revoke(userid, uint(id), time.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Abort(c, apperr.NotFound("api_key_not_found", "api key not found"))
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to revoke api key").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionAPIKeyRevoked, TargetType: "api_key", TargetID: c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "api key revoked successfully",
+	})
+}
@@ -0,0 +1,33 @@
+package user
+
+import (
+	"gaming/apperr"
+	"gaming/audit"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEvents returns the audit events where the user is the actor or the
+// subject, filtered by the query parameters
+func (s *Service) AuditEvents(c *gin.Context) {
+	filter, err := audit.FilterFromQuery(c)
+	if err != nil {
+		apperr.Abort(c, apperr.Validation("invalid_filter", err.Error()))
+		return
+	}
+	filter.UserID = c.GetUint("userid")
+
+	events, total, err := audit.Find(filter)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch audit events").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "fetched audit events successfully",
+		"data":    events,
+		"total":   total,
+	})
+}
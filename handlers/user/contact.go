@@ -0,0 +1,228 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/logging"
+	"gaming/otp"
+	"gaming/repository"
+	"gaming/sms"
+	"gaming/utility"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestEmailChange sends an OTP to the new email address. The email is
+// only changed once that code is confirmed with ConfirmEmailChange.
+func (s *Service) RequestEmailChange(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	// Keep the new email in lower case, as social logins do, so the duplicate
+	// check and the OTP use the address that will be stored
+	req.Email = strings.ToLower(req.Email)
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	if strings.EqualFold(req.Email, existinguser.Email) {
+		apperr.Abort(c, apperr.Validation("same_email", "this is already your email"))
+		return
+	}
+	taken, err := s.Users.EmailTaken(req.Email)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to check email").Wrap(err))
+		return
+	}
+	if taken {
+		apperr.Abort(c, apperr.Conflict("email_taken", "this email is already in use"))
+		return
+	}
+
+	code, err := s.OTP.Issue(req.Email, otp.PurposeEmailChange)
+	if err != nil {
+		abortOTPError(c, err)
+		return
+	}
+	if err := s.Users.Update(userid, map[string]any{"pending_email": req.Email}); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to save email change").Wrap(err))
+		return
+	}
+	utility.SendOTPByEmail(req.Email, code, mailLocale(c), s.OTP.TTL)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "otp sent to the new email, confirm it to complete the change",
+	})
+}
+
+// ConfirmEmailChange replaces the user's email with the pending one after
+// checking the OTP that was sent to it
+func (s *Service) ConfirmEmailChange(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Otp string `json:"otp" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	if existinguser.PendingEmail == "" {
+		apperr.Abort(c, apperr.Conflict("no_pending_email", "no email change was requested"))
+		return
+	}
+
+	if err := s.OTP.Verify(existinguser.PendingEmail, otp.PurposeEmailChange, req.Otp); err != nil {
+		abortOTPError(c, err)
+		return
+	}
+
+	// The email may have been taken since the change was requested
+	err = s.Users.ChangeEmail(userid, existinguser.PendingEmail)
+	if errors.Is(err, repository.ErrDuplicate) {
+		apperr.Abort(c, apperr.Conflict("email_taken", "this email is already in use"))
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to change email").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{
+		Action: audit.ActionEmailChanged,
+		Before: map[string]any{"email": existinguser.Email},
+		After:  map[string]any{"email": existinguser.PendingEmail},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "email changed successfully",
+	})
+}
+
+// RequestPhoneVerification texts an OTP to a new phone number, or to the
+// current one when no number is given. The number is saved as verified once
+// the code is confirmed with ConfirmPhoneVerification.
+func (s *Service) RequestPhoneVerification(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Phone string `json:"phone" binding:"omitempty,e164"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	phone := req.Phone
+	if phone == "" {
+		phone = existinguser.Phone
+	}
+	if phone == "" {
+		apperr.Abort(c, apperr.Validation("phone_required", "phone is required"))
+		return
+	}
+	if phone == existinguser.Phone && existinguser.PhoneVerified {
+		apperr.Abort(c, apperr.Conflict("phone_verified", "this phone is already verified"))
+		return
+	}
+
+	code, err := s.OTP.Issue(phone, otp.PurposePhone)
+	if err != nil {
+		abortOTPError(c, err)
+		return
+	}
+	if err := s.Users.Update(userid, map[string]any{"pending_phone": phone}); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to save phone change").Wrap(err))
+		return
+	}
+
+	msg := sms.Message{
+		To:   phone,
+		Body: fmt.Sprintf("Your verification code is %s. It expires in %s.", code, s.OTP.TTL),
+	}
+	if err := sms.Default.Send(c.Request.Context(), msg); err != nil {
+		logging.For(c).Error("failed to send verification sms", "error", err)
+		s.OTP.Discard(phone, otp.PurposePhone)
+		apperr.Abort(c, apperr.Unavailable("sms_failed", "failed to send sms, please try again later"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "otp sent by sms, confirm it to verify your phone",
+	})
+}
+
+// ConfirmPhoneVerification saves the pending phone as verified after
+// checking the OTP that was texted to it
+func (s *Service) ConfirmPhoneVerification(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Otp string `json:"otp" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	if existinguser.PendingPhone == "" {
+		apperr.Abort(c, apperr.Conflict("no_pending_phone", "no phone verification was requested"))
+		return
+	}
+
+	if err := s.OTP.Verify(existinguser.PendingPhone, otp.PurposePhone, req.Otp); err != nil {
+		abortOTPError(c, err)
+		return
+	}
+
+	err = s.Users.Update(userid, map[string]any{
+		"phone":          existinguser.PendingPhone,
+		"phone_verified": true,
+		"pending_phone":  "",
+	})
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to verify phone").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{
+		Action: audit.ActionPhoneVerified,
+		Before: map[string]any{"phone": existinguser.Phone, "phone_verified": existinguser.PhoneVerified},
+		After:  map[string]any{"phone": existinguser.PendingPhone, "phone_verified": true},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "phone verified successfully",
+	})
+}
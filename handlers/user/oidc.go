@@ -0,0 +1,290 @@
+package user
+
+import (
+	"crypto/subtle"
+	"errors"
+	"gaming/apperr"
+	"gaming/model"
+	"gaming/oidcauth"
+	"gaming/repository"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateTTL is how long the user has to complete the login at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie holds the state of the login started in this browser. The
+// callback only accepts its own state, so that a victim cannot be made to
+// complete a login started by someone else.
+const oidcStateCookie = "oidc_state"
+
+// OIDCLogin redirects the browser to the provider to sign in
+func (s *Service) OIDCLogin(c *gin.Context) {
+	authURL, err := s.startAuthorization(c, c.Param("provider"), 0)
+	if err != nil {
+		abortOIDCError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// LinkIdentity starts linking a provider account to the logged in user. The
+// client opens the returned URL; the callback then links instead of logging in.
+func (s *Service) LinkIdentity(c *gin.Context) {
+	userid := c.GetUint("userid")
+	authURL, err := s.startAuthorization(c, c.Param("provider"), userid)
+	if err != nil {
+		abortOIDCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "open the url to link your account",
+		"data":    gin.H{"auth_url": authURL},
+	})
+}
+
+// OIDCCallback completes the authorization when the provider redirects back.
+// The provider identity is mapped to a user as follows:
+//   - an identity that is already linked logs in its user
+//   - a link started by LinkIdentity is attached to that user
+//   - a verified email that matches an existing user is linked to that user
+//   - otherwise a new user is created
+func (s *Service) OIDCCallback(c *gin.Context) {
+	providerName := c.Param("provider")
+	provider, err := oidcauth.Get(providerName)
+	if err != nil {
+		abortOIDCError(c, err)
+		return
+	}
+	if errParam := c.Query("error"); errParam != "" {
+		apperr.Abort(c, apperr.Unauthorized("provider_cancelled", "sign in was cancelled at the provider: "+errParam))
+		return
+	}
+
+	// The state is single-use, and the cookie ties it to the browser that
+	// started the login
+	cookie, _ := c.Cookie(oidcStateCookie)
+	setStateCookie(c, provider, "", -1)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(c.Query("state"))) != 1 {
+		apperr.Abort(c, apperr.Validation("invalid_login_state", "invalid or expired login state"))
+		return
+	}
+	state, err := s.Identities.TakeLoginState(providerName, c.Query("state"))
+	if err != nil || time.Now().After(state.ExpiresAt) {
+		apperr.Abort(c, apperr.Validation("invalid_login_state", "invalid or expired login state"))
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		logLoginFailure(c, "", 0, "oidc:"+providerName+" verification failed")
+		apperr.Abort(c, apperr.Unauthorized("provider_login_failed", "failed to verify the provider login"))
+		return
+	}
+
+	existinguser, err := s.resolveIdentity(providerName, identity, state.LinkUserID)
+	if err != nil {
+		abortOIDCError(c, err)
+		return
+	}
+
+	if state.LinkUserID != 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": providerName + " account linked successfully",
+		})
+		return
+	}
+	s.completeLogin(c, existinguser, "oidc:"+providerName)
+}
+
+// ListIdentities returns the provider accounts linked to the logged in user
+func (s *Service) ListIdentities(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	identities, err := s.Identities.List(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch identities").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "fetched identities successfully",
+		"data":    identities,
+	})
+}
+
+// UnlinkIdentity removes a linked provider account. The last way to sign in
+// cannot be removed: a user without a password has to keep one provider.
+func (s *Service) UnlinkIdentity(c *gin.Context) {
+	userid := c.GetUint("userid")
+	providerName := c.Param("provider")
+
+	existinguser, err := s.Users.ByID(userid)
+	if err == nil {
+		// Without a password, the last provider is the only way to sign in
+		err = s.Identities.Unlink(userid, providerName, existinguser.Password == "")
+	}
+	if errors.Is(err, repository.ErrLastIdentity) {
+		err = errLastSignInMethod
+	}
+	if err != nil {
+		abortOIDCError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": providerName + " account unlinked successfully",
+	})
+}
+
+var (
+	errLastSignInMethod = apperr.Conflict("last_sign_in_method", "cannot unlink the only sign in method, set a password first")
+	errIdentityTaken    = apperr.Conflict("identity_taken", "this provider account is linked to another user")
+	errProviderLinked   = apperr.Conflict("provider_linked", "another account of this provider is already linked")
+	errEmailNotVerified = apperr.Forbidden("email_not_verified", "the provider has not verified your email, sign in and link the provider instead")
+)
+
+// startAuthorization stores a new login state, sets it in the state cookie
+// and returns the provider URL
+func (s *Service) startAuthorization(c *gin.Context, providerName string, linkUserID uint) (string, error) {
+	provider, err := oidcauth.Get(providerName)
+	if err != nil {
+		return "", err
+	}
+	stateValue, err := oidcauth.RandomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidcauth.RandomString()
+	if err != nil {
+		return "", err
+	}
+	state := model.OIDCLoginState{
+		State:        stateValue,
+		Provider:     providerName,
+		CodeVerifier: oidcauth.NewVerifier(),
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	if err := s.Identities.SaveLoginState(state); err != nil {
+		return "", err
+	}
+	setStateCookie(c, provider, state.State, int(oidcStateTTL.Seconds()))
+	return provider.AuthCodeURL(state.State, state.Nonce, state.CodeVerifier), nil
+}
+
+// setStateCookie sets the state cookie for the callback URL of the provider,
+// or removes it when maxAge is negative. It is sent on the top-level redirect
+// back from the provider, but is not readable by scripts.
+func setStateCookie(c *gin.Context, provider *oidcauth.Provider, value string, maxAge int) {
+	path, secure := "/", false
+	if callback, err := url.Parse(provider.OAuth2.RedirectURL); err == nil {
+		if callback.Path != "" {
+			path = callback.Path
+		}
+		secure = callback.Scheme == "https"
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, path, "", secure, true)
+}
+
+// resolveIdentity finds or creates the user for a verified provider identity
+func (s *Service) resolveIdentity(providerName string, identity *oidcauth.Identity, linkUserID uint) (model.User, error) {
+	linked, err := s.Identities.ByProviderSubject(providerName, identity.Subject)
+	if err == nil {
+		if linkUserID != 0 && linked.UserID != linkUserID {
+			return model.User{}, errIdentityTaken
+		}
+		return s.Users.ByID(linked.UserID)
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return model.User{}, err
+	}
+
+	link := &model.UserIdentity{
+		Provider: providerName,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	var existinguser, newUser *model.User
+	switch {
+	case linkUserID != 0:
+		user, err := s.Users.ByID(linkUserID)
+		if err != nil {
+			return model.User{}, err
+		}
+		identities, err := s.Identities.List(linkUserID)
+		if err != nil {
+			return model.User{}, err
+		}
+		for _, other := range identities {
+			if other.Provider == providerName {
+				return model.User{}, errProviderLinked
+			}
+		}
+		existinguser = &user
+	case identity.Email == "":
+		return model.User{}, errors.New("the provider did not share an email address")
+	case !identity.EmailVerified:
+		// Anyone can claim an unverified email. Linking on it would hand them
+		// the account, and creating one would hand the account to them once
+		// its owner signs in through a provider that verified it
+		return model.User{}, errEmailNotVerified
+	default:
+		user, err := s.Users.ByEmail(identity.Email)
+		switch {
+		case err == nil:
+			existinguser = &user
+		case errors.Is(err, repository.ErrNotFound):
+			// No password is set. An empty password never matches a bcrypt
+			// hash, so the account signs in through the provider until a
+			// password is set with the reset flow
+			newUser = &model.User{
+				Name:  identity.Name,
+				Email: identity.Email,
+			}
+			if newUser.Name == "" {
+				newUser.Name = identity.Email
+			}
+		default:
+			return model.User{}, err
+		}
+	}
+
+	if existinguser != nil {
+		link.UserID = existinguser.UserID
+	}
+	if err := s.Identities.Link(link, newUser); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			// Linked by a concurrent callback in the meantime
+			return model.User{}, errIdentityTaken
+		}
+		return model.User{}, err
+	}
+	if newUser != nil {
+		return *newUser, nil
+	}
+	return *existinguser, nil
+}
+
+// abortOIDCError responds to a failed social login step
+func abortOIDCError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, oidcauth.ErrUnknownProvider), errors.Is(err, repository.ErrNotFound):
+		apperr.Abort(c, apperr.NotFound("provider_not_found", "identity provider not found"))
+	case errors.Is(err, errLastSignInMethod), errors.Is(err, errIdentityTaken),
+		errors.Is(err, errProviderLinked), errors.Is(err, errEmailNotVerified):
+		apperr.Abort(c, err)
+	default:
+		apperr.Abort(c, apperr.Internal("internal", "failed to sign in with the provider").Wrap(err))
+	}
+}
@@ -0,0 +1,155 @@
+package user
+
+import (
+	"errors"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/model"
+	"gaming/otp"
+	"gaming/utility"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ForgotPassword sends a password reset OTP to the user's email
+func (s *Service) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	req.Email = strings.ToLower(req.Email)
+
+	// The response is the same whether or not the account exists, so the
+	// endpoint cannot be used to find out which emails are registered
+	response := gin.H{
+		"status":  http.StatusOK,
+		"message": "if the account exists, a reset code has been sent",
+	}
+
+	if _, err := s.Users.ByEmail(req.Email); err != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// Issuing a new code replaces any earlier reset code. During the resend
+	// cooldown the earlier code stays valid and nothing new is sent.
+	code, err := s.OTP.Issue(req.Email, otp.PurposeReset)
+	var cooldown *otp.CooldownError
+	if errors.As(err, &cooldown) {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to store OTP to the database").Wrap(err))
+		return
+	}
+
+	utility.SendOTPByEmail(req.Email, code, mailLocale(c), s.OTP.TTL)
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword sets a new password using the emailed reset OTP.
+// The OTP is consumed, and every existing session and API key of the user is
+// revoked since whoever took over the account may hold them.
+func (s *Service) ResetPassword(c *gin.Context) {
+	var req struct {
+		Email       string `json:"email" binding:"required,email"`
+		Otp         string `json:"otp" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required,min=8,max=72"` // bcrypt ignores bytes past 72
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	req.Email = strings.ToLower(req.Email)
+
+	// The reset code is single-use, Verify consumes it
+	if err := s.OTP.Verify(req.Email, otp.PurposeReset, req.Otp); err != nil {
+		abortOTPError(c, err)
+		return
+	}
+
+	existinguser, err := s.Users.ByEmail(req.Email)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+
+	if err := s.setPassword(&existinguser, req.NewPassword); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to update password").Wrap(err))
+		return
+	}
+
+	if err := s.Sessions.RevokeAll(existinguser.UserID, ""); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "password updated but failed to revoke sessions").Wrap(err))
+		return
+	}
+	if err := s.APIKeys.RevokeAll(existinguser.UserID, time.Now()); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "password updated but failed to revoke API keys").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionPasswordReset, ActorID: existinguser.UserID, Details: map[string]any{"sessions_revoked": "all", "api_keys_revoked": "all"}})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "password reset successfully, please login again",
+	})
+}
+
+// ChangePassword updates the password of the logged in user after checking
+// the current one. Sessions on other devices are revoked.
+func (s *Service) ChangePassword(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required,min=8,max=72"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(existinguser.Password), []byte(req.CurrentPassword)); err != nil {
+		apperr.Abort(c, apperr.Unauthorized("incorrect_password", "incorrect current password"))
+		return
+	}
+
+	if err := s.setPassword(&existinguser, req.NewPassword); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to update password").Wrap(err))
+		return
+	}
+
+	if err := s.Sessions.RevokeAll(userid, c.GetString("jti")); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "password updated but failed to revoke sessions").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionPasswordChanged, Details: map[string]any{"sessions_revoked": "others"}})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "password changed successfully",
+	})
+}
+
+// setPassword hashes and stores a new password for the user
+func (s *Service) setPassword(user *model.User, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.Users.Update(user.UserID, map[string]any{"password": string(hashedPassword)})
+}
@@ -0,0 +1,36 @@
+package user
+
+import (
+	"gaming/account"
+	"gaming/jwt"
+	"gaming/loginguard"
+	"gaming/otp"
+	"gaming/repository"
+)
+
+// Service handles the user routes.
+type Service struct {
+	Users      repository.UserRepo
+	Sessions   repository.SessionRepo
+	APIKeys    repository.APIKeyRepo
+	Identities repository.IdentityRepo
+	OTP        *otp.Service
+	Guard      *loginguard.Guard
+	Auth       *jwt.Auth
+	Accounts   *account.Service
+}
+
+// NewService returns a Service using the repositories it needs from repos and
+// the given dependencies.
+func NewService(repos repository.Repositories, otps *otp.Service, guard *loginguard.Guard, auth *jwt.Auth, accounts *account.Service) *Service {
+	return &Service{
+		Users:      repos.Users,
+		Sessions:   repos.Sessions,
+		APIKeys:    repos.APIKeys,
+		Identities: repos.Identities,
+		OTP:        otps,
+		Guard:      guard,
+		Auth:       auth,
+		Accounts:   accounts,
+	}
+}
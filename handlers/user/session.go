@@ -0,0 +1,109 @@
+package user
+
+import (
+	"gaming/apperr"
+	"gaming/audit"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logout revokes the token used for the current request
+func (s *Service) Logout(c *gin.Context) {
+	userid := c.GetUint("userid")
+	jti := c.GetString("jti")
+
+	session, err := s.Sessions.ByJTI(userid, jti)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("session_not_found", "session not found"))
+		return
+	}
+
+	if err := s.Sessions.Revoke(session); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to logout").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionLogout, TargetType: "session", TargetID: strconv.FormatUint(uint64(session.ID), 10)})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "logged out successfully",
+	})
+}
+
+// LogoutAll revokes every session of the current user, on all devices
+func (s *Service) LogoutAll(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	if err := s.Sessions.RevokeAll(userid, ""); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to logout from all devices").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionLogoutAll})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "logged out from all devices",
+	})
+}
+
+// ListSessions returns the active sessions of the current user
+func (s *Service) ListSessions(c *gin.Context) {
+	userid := c.GetUint("userid")
+	jti := c.GetString("jti")
+
+	sessions, err := s.Sessions.Active(userid, time.Now())
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to fetch sessions").Wrap(err))
+		return
+	}
+
+	var sessionview []gin.H
+	for _, session := range sessions {
+		sessionview = append(sessionview, gin.H{
+			"session_id": session.ID,
+			"device":     session.Device,
+			"ip":         session.IP,
+			"created_at": session.CreatedAt,
+			"expires_at": session.ExpiresAt,
+			"current":    session.JTI == jti, // Mark the session making this request
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "fetched sessions successfully",
+		"data":    sessionview,
+	})
+}
+
+// RevokeSession revokes one of the current user's sessions by its ID
+func (s *Service) RevokeSession(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Abort(c, apperr.Validation("invalid_id", "invalid session id"))
+		return
+	}
+
+	// Only sessions owned by the current user can be revoked
+	session, err := s.Sessions.ByID(userid, uint(id))
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("session_not_found", "session not found"))
+		return
+	}
+
+	if err := s.Sessions.Revoke(session); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to revoke session").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.ActionSessionRevoked, TargetType: "session", TargetID: c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "session revoked successfully",
+	})
+}
@@ -0,0 +1,203 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"gaming/apperr"
+	"gaming/audit"
+	"gaming/model"
+	"gaming/totp"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// totpIssuer is the account issuer shown in authenticator apps
+const totpIssuer = "Game App"
+
+// recoveryCodeCount is the number of recovery codes generated on confirmation
+const recoveryCodeCount = 10
+
+// Enroll2FA starts TOTP enrollment by generating a secret for the user.
+// It returns the otpauth URI and a QR code PNG for authenticator apps.
+// 2FA is not enforced until the first code is confirmed with Confirm2FA.
+func (s *Service) Enroll2FA(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	if existinguser.TOTPEnabled {
+		apperr.Abort(c, apperr.Conflict("two_factor_enabled", "two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to generate secret").Wrap(err))
+		return
+	}
+	if err := s.Users.Update(userid, map[string]any{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to store secret").Wrap(err))
+		return
+	}
+
+	uri := totp.URI(totpIssuer, existinguser.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to generate qr code").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "scan the qr code and confirm with a code from your authenticator app",
+		"data": gin.H{
+			"secret":      secret,
+			"otpauth_uri": uri,
+			"qr_png":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		},
+	})
+}
+
+// Confirm2FA enables 2FA after checking a first code from the authenticator app,
+// and returns the recovery codes. They are only shown this once.
+func (s *Service) Confirm2FA(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	if existinguser.TOTPSecret == "" || existinguser.TOTPEnabled {
+		apperr.Abort(c, apperr.Conflict("no_two_factor_enrollment", "no two-factor enrollment in progress"))
+		return
+	}
+
+	step, ok := totp.Validate(existinguser.TOTPSecret, req.Code, time.Now(), 1, existinguser.TOTPLastStep)
+	if !ok {
+		apperr.Abort(c, apperr.Unauthorized("invalid_code", "invalid code"))
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = s.Users.EnableTOTP(userid, step, hashes)
+	}
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to enable two-factor authentication").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.Action2FAEnabled})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "two-factor authentication enabled, store the recovery codes somewhere safe",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// Disable2FA turns 2FA off after checking a TOTP or recovery code
+func (s *Service) Disable2FA(c *gin.Context) {
+	userid := c.GetUint("userid")
+
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(userid)
+	if err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
+		return
+	}
+	if !existinguser.TOTPEnabled {
+		apperr.Abort(c, apperr.Conflict("two_factor_not_enabled", "two-factor authentication is not enabled"))
+		return
+	}
+	if !s.verifySecondFactor(&existinguser, req.Code, req.RecoveryCode) {
+		apperr.Abort(c, apperr.Unauthorized("invalid_code", "invalid code"))
+		return
+	}
+
+	if err := s.Users.DisableTOTP(userid); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to disable two-factor authentication").Wrap(err))
+		return
+	}
+	audit.Log(c, audit.Event{Action: audit.Action2FADisabled})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "two-factor authentication disabled",
+	})
+}
+
+// verifySecondFactor checks a TOTP code, or else a recovery code, for the user.
+// An accepted TOTP step is remembered and an accepted recovery code is used up.
+func (s *Service) verifySecondFactor(user *model.User, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), 1, user.TOTPLastStep)
+		if !ok {
+			return false
+		}
+		// Only advance the step if no concurrent request used it first
+		advanced, err := s.Users.AdvanceTOTPStep(user.UserID, step)
+		return err == nil && advanced
+	}
+	if recoveryCode != "" {
+		used, err := s.Users.UseRecoveryCode(user.UserID, hashRecoveryCode(recoveryCode))
+		return err == nil && used
+	}
+	return false
+}
+
+// newRecoveryCodes generates a fresh set of recovery codes. The plain codes
+// are shown to the user and only the hashes are stored.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, recoveryCodeCount)
+	hashes = make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. The codes are random
+// enough that a plain SHA-256 is sufficient.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
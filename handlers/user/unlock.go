@@ -0,0 +1,121 @@
+package user
+
+import (
+	"errors"
+	"gaming/apperr"
+	"gaming/logging"
+	"gaming/loginguard"
+	"gaming/mailer"
+	"gaming/model"
+	"gaming/otp"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestUnlock emails a new unlock code to the owner of a locked account
+func (s *Service) RequestUnlock(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	req.Email = strings.ToLower(req.Email)
+
+	// Same response whether or not the account exists or is locked
+	if existinguser, err := s.Users.ByEmail(req.Email); err == nil {
+		s.sendUnlockCode(c, existinguser)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "if the account is locked, an unlock code has been sent",
+	})
+}
+
+// UnlockAccount lifts an account lockout with the emailed unlock code
+func (s *Service) UnlockAccount(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+		Otp   string `json:"otp" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	req.Email = strings.ToLower(req.Email)
+
+	if err := s.OTP.Verify(req.Email, otp.PurposeUnlock, req.Otp); err != nil {
+		abortOTPError(c, err)
+		return
+	}
+	if err := s.Guard.Unlock(req.Email); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to unlock account").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "account unlocked, you can login again",
+	})
+}
+
+// recordLoginFailure counts a failed login and notifies the owner when it
+// locks their account. existinguser is nil when the email is unknown.
+func (s *Service) recordLoginFailure(c *gin.Context, email, ip string, existinguser *model.User) {
+	locked, err := s.Guard.Failure(email, ip)
+	if err != nil {
+		logging.For(c).Error("failed to record login failure", "error", err)
+		return
+	}
+	if locked && existinguser != nil {
+		s.sendUnlockCode(c, *existinguser)
+	}
+}
+
+// sendUnlockCode emails the lockout notice with a code to unlock the account
+func (s *Service) sendUnlockCode(c *gin.Context, existinguser model.User) {
+	locked, err := s.Guard.Store.Get(loginguard.AccountKey(existinguser.Email))
+	if err != nil || !time.Now().Before(locked.LockedUntil) {
+		return
+	}
+
+	// UnlockAccount verifies the code for the lowercased email it is sent
+	code, err := s.OTP.Issue(strings.ToLower(existinguser.Email), otp.PurposeUnlock)
+	var cooldown *otp.CooldownError
+	if errors.As(err, &cooldown) {
+		return // a code was sent moments ago
+	}
+	if err != nil {
+		logging.For(c).Error("failed to issue unlock code", "error", err)
+		return
+	}
+
+	data := gin.H{
+		"Code":      code,
+		"ExpiresIn": s.OTP.TTL,
+		"LockedFor": s.Guard.LockDuration,
+	}
+	if err := mailer.SendTemplate(existinguser.Email, mailLocale(c), mailer.TemplateAccountLocked, data); err != nil {
+		logging.For(c).Error("failed to queue lockout email", "error", err)
+	}
+}
+
+// abortThrottled responds to a login refused by the login guard
+func abortThrottled(c *gin.Context, err error) {
+	var throttled *loginguard.ThrottledError
+	if !errors.As(err, &throttled) {
+		apperr.Abort(c, apperr.Internal("internal", "failed to check login attempts").Wrap(err))
+		return
+	}
+
+	message := "too many failed attempts, please wait before trying again"
+	if throttled.Locked {
+		message = "account temporarily locked after too many failed attempts"
+	}
+	apperr.Abort(c, apperr.TooManyRequests("login_throttled", message, throttled.RetryAfter))
+}
@@ -1,199 +1,323 @@
 package user
 
 import (
-	"fmt"
-	database "gaming/database"
-	"gaming/generateotp"
+	"errors"
+	"gaming/apperr"
+	"gaming/audit"
 	"gaming/jwt"
+	"gaming/logging"
 	"gaming/model"
+	"gaming/otp"
 	"gaming/utility"
-	"log"
+	"gaming/validation"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Define user model variable
-var user model.User
-
 // Define user role constant
 const RoleUser = "user"
 
+// SignupRequest is the payload accepted by Signup
+type SignupRequest struct {
+	Name     string `json:"name" binding:"required,max=100,name"`
+	Email    string `json:"email" binding:"required,email"`
+	Phone    string `json:"phone" binding:"omitempty,e164"`           // verified later through RequestPhoneVerification
+	Password string `json:"password" binding:"required,min=8,max=72"` // bcrypt ignores bytes past 72
+}
+
+// VerifyOTPRequest is the payload accepted by VerifyOTP
+type VerifyOTPRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Otp   string `json:"otp" binding:"required"`
+}
+
 // Signup function handles user registration
-func Signup(c *gin.Context) {
-	// Bind the JSON input to the User model
-	if err := c.ShouldBindJSON(&user); err != nil {
-		log.Printf("error when binding json: %v", err) // Log binding error
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "Error when binding JSON",
-		})
+func (s *Service) Signup(c *gin.Context) {
+	var req SignupRequest
+	// Bind the JSON input to the signup request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
+	// Accounts and their OTPs are keyed by the lowercased email
+	req.Email = strings.ToLower(req.Email)
 
 	// Check if the user already exists in the database
-	var existingUsers []model.User
-	result := database.DB.Where("email = ?", user.Email).Find(&existingUsers)
+	taken, err := s.Users.EmailTaken(req.Email)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to check user").Wrap(err))
+		return
+	}
 
 	// If user already exists, return a conflict status
-	if result.RowsAffected > 0 {
-		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-			"status":  http.StatusConflict,
-			"message": "This user already exists",
-		})
+	if taken {
+		apperr.Abort(c, apperr.Conflict("email_taken", "this email is already in use"))
 		return
 	}
 
 	// Hash the password for security
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "error when hashing password",
-		})
+		apperr.Abort(c, apperr.Internal("internal", "error when hashing password").Wrap(err))
 		return
 	}
-	user.Password = string(hashedPassword) // Save the hashed password
-
-	// Generate a new OTP (One-Time Password)
-	otp := generateotp.GenerateOTP(6)
-	newOTP := model.OTP{
-		Email: user.Email,
-		Otp:   otp,
-		Exp:   time.Now().Add(5 * time.Minute), // Set expiration time for the OTP
-	}
-	fmt.Println("Generated OTP:", otp)
-
-	// Check if an OTP already exists for this user
-	var existingOTP model.OTP
-	if err := database.DB.Where("email = ?", user.Email).First(&existingOTP).Error; err == nil {
-		// If an existing OTP is found, update it
-		existingOTP.Otp = otp
-		existingOTP.Exp = time.Now().Add(5 * time.Minute) // Reset expiration time
-		if err := database.DB.Save(&existingOTP).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": "failed to update OTP in the database",
-			})
-			return
-		}
-	} else {
-		// Create a new OTP entry in the database
-		if err := database.DB.Create(&newOTP).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"Status":  http.StatusInternalServerError,
-				"Message": "Failed to store OTP to the database",
-			})
-			return
+
+	// Generate a new OTP (One-Time Password) for the signup. This comes first
+	// so that a signup refused by the resend cooldown, such as a concurrent one
+	// for the same email, does not replace the pending account below.
+	code, err := s.OTP.Issue(req.Email, otp.PurposeSignup)
+	if err != nil {
+		abortOTPError(c, err)
+		return
+	}
+
+	// Store the account as pending until the OTP is verified. Signing up again
+	// with the same email after the cooldown replaces the earlier pending account.
+	pending := model.PendingSignup{
+		Email:    req.Email,
+		Name:     req.Name,
+		Phone:    req.Phone,
+		Password: string(hashedPassword),
+	}
+	if err := s.Users.SavePendingSignup(pending); err != nil {
+		// Without its pending account the code is useless; discarding it lets
+		// the user retry without waiting for the cooldown
+		if err := s.OTP.Discard(req.Email, otp.PurposeSignup); err != nil {
+			logging.For(c).Error("failed to discard signup otp", "error", err)
 		}
+		apperr.Abort(c, apperr.Internal("internal", "failed to store signup").Wrap(err))
+		return
 	}
 
 	// Send the OTP to the user's email
-	utility.SendOTPByEmail(newOTP.Email, newOTP.Otp)
+	utility.SendOTPByEmail(req.Email, code, mailLocale(c), s.OTP.TTL)
+	audit.Log(c, audit.Event{Action: audit.ActionSignup, TargetType: "user", TargetID: req.Email})
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
-		"Message": "OTP sent successfully, please verify OTP",
+		"message": "OTP sent successfully, please verify OTP",
 	})
 }
 
 // VerifyOTP function handles OTP verification for user registration
-func VerifyOTP(c *gin.Context) {
-	var otp model.OTP
-	err := c.BindJSON(&otp) // Bind the JSON input to the OTP model
+func (s *Service) VerifyOTP(c *gin.Context) {
+	var req VerifyOTPRequest
+	err := c.ShouldBindJSON(&req) // Bind the JSON input to the verification request
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "failed to bind json",
-		})
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
+	req.Email = strings.ToLower(req.Email)
 
-	// Retrieve the existing OTP for the user from the database
-	var existingotp model.OTP
-	result := database.DB.Where("email=?", user.Email).First(&existingotp)
-	if result.Error != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to fetch otp",
+	// Check the OTP; a matching code is consumed so it cannot be used twice
+	if err := s.OTP.Verify(req.Email, otp.PurposeSignup, req.Otp); err != nil {
+		audit.Log(c, audit.Event{
+			Action:     audit.ActionOTPFailed,
+			TargetType: "user",
+			TargetID:   req.Email,
+			Details:    map[string]any{"purpose": otp.PurposeSignup, "error": err.Error()},
 		})
+		abortOTPError(c, err)
 		return
 	}
 
-	// Check if the OTP is expired
-	currentTime := time.Now()
-	if currentTime.After(existingotp.Exp) {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "otp expired",
-		})
+	// Create the user from the pending signup of this email, and remove the
+	// pending signup in the same transaction
+	user, err := s.Users.CompleteSignup(req.Email)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to create user").Wrap(err))
 		return
 	}
+	audit.Log(c, audit.Event{
+		Action:     audit.ActionOTPVerified,
+		ActorID:    user.UserID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.UserID), 10),
+		After:      user,
+		Details:    map[string]any{"purpose": otp.PurposeSignup},
+	})
 
-	// Check if the provided OTP matches the stored OTP
-	if existingotp.Otp != otp.Otp {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "otp expired",
-		})
-		return
-	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "User created successfully",
+	})
+}
 
-	// Create the new user in the database
-	create := database.DB.Create(&user)
-	fmt.Println(user)
-	if create.Error != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to create user",
-		})
-		return
-	} else {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  http.StatusOK,
-			"Message": "User created successfully",
-		})
-	}
+// LoginRequest is the payload accepted by Login. The second step of a login
+// with 2FA sends the mfa_token from the first response with a TOTP code or a
+// recovery code instead of the email and password.
+type LoginRequest struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 // Login function handles user authentication
-func Login(c *gin.Context) {
-	var userlogin model.User
+func (s *Service) Login(c *gin.Context) {
+	var userlogin LoginRequest
 	err := c.ShouldBindJSON(&userlogin) // Bind the JSON input for login
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to bind json",
-		})
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	userlogin.Email = strings.ToLower(userlogin.Email)
+
+	// Second step: upgrade the partial token once the code is verified
+	if userlogin.MFAToken != "" {
+		s.loginSecondFactor(c, userlogin)
+		return
+	}
+
+	// Refuse the attempt while the account or IP is throttled or locked
+	ip := c.ClientIP()
+	if err := s.Guard.Check(userlogin.Email, ip); err != nil {
+		logLoginFailure(c, userlogin.Email, 0, "throttled")
+		abortThrottled(c, err)
 		return
 	}
 
 	// Retrieve the existing user from the database
-	var existinguser model.User
-	result := database.DB.Where("email=?", userlogin.Email).First(&existinguser)
-	if result.Error != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"status":  http.StatusUnauthorized,
-			"message": "incorrect email or password",
-		})
+	existinguser, err := s.Users.ByEmail(userlogin.Email)
+	if err != nil {
+		s.recordLoginFailure(c, userlogin.Email, ip, nil)
+		logLoginFailure(c, userlogin.Email, 0, "unknown email")
+		apperr.Abort(c, apperr.Unauthorized("invalid_credentials", "incorrect email or password"))
 		return
 	}
 
 	// Compare the provided password with the hashed password
 	password := bcrypt.CompareHashAndPassword([]byte(existinguser.Password), []byte(userlogin.Password))
 	if password != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"status":  http.StatusUnauthorized,
-			"message": "incorrect email or password",
-		})
+		s.recordLoginFailure(c, userlogin.Email, ip, &existinguser)
+		logLoginFailure(c, userlogin.Email, existinguser.UserID, "wrong password")
+		apperr.Abort(c, apperr.Unauthorized("invalid_credentials", "incorrect email or password"))
 		return
-	} else {
-		// Generate a JWT token for the authenticated user
-		jwt.JwtToken(c, existinguser.UserID, userlogin.Email, RoleUser)
+	}
+
+	// Without 2FA the login is complete here; with 2FA the failures are only
+	// cleared once the second factor is verified as well
+	if !existinguser.TOTPEnabled {
+		if err := s.Guard.Success(existinguser.Email); err != nil {
+			logging.For(c).Error("failed to reset login failures", "error", err)
+		}
+	}
+	s.completeLogin(c, existinguser, "password")
+}
+
+// completeLogin issues the token for a user whose first factor was verified.
+// With 2FA enabled only a partial token is returned, to be upgraded by Login
+// together with a TOTP or recovery code. method names the first factor for
+// the audit log.
+func (s *Service) completeLogin(c *gin.Context, existinguser model.User, method string) {
+	if existinguser.TOTPEnabled {
+		mfaToken, err := jwt.MFAToken(existinguser.UserID, existinguser.Email)
+		if err != nil {
+			apperr.Abort(c, apperr.Internal("internal", "failed to create mfa token").Wrap(err))
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"status":  http.StatusOK,
-			"Message": "Login successfully",
+			"status":       http.StatusOK,
+			"message":      "mfa_required",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
 		})
+		return
+	}
+
+	// Generate a JWT token for the authenticated user
+	s.Auth.JwtToken(c, existinguser.UserID, existinguser.Email, tokenRole(existinguser))
+	logLogin(c, existinguser, method)
+}
+
+// logLogin records a successful login once the token has been issued
+func logLogin(c *gin.Context, existinguser model.User, method string) {
+	if c.Writer.Status() != http.StatusOK {
+		return
+	}
+	audit.Log(c, audit.Event{
+		Action:  audit.ActionLogin,
+		ActorID: existinguser.UserID,
+		Details: map[string]any{"method": method},
+	})
+}
+
+// logLoginFailure records a failed login. userID is 0 when the email is unknown.
+func logLoginFailure(c *gin.Context, email string, userID uint, reason string) {
+	audit.Log(c, audit.Event{
+		Action:     audit.ActionLoginFailed,
+		SubjectID:  userID,
+		TargetType: "user",
+		TargetID:   email,
+		Details:    map[string]any{"reason": reason},
+	})
+}
+
+// tokenRole returns the role to put in the user's token
+func tokenRole(existinguser model.User) string {
+	if existinguser.Role == jwt.RoleAdmin {
+		return jwt.RoleAdmin
 	}
-}
\ No newline at end of file
+	return RoleUser
+}
+
+// loginSecondFactor completes a login with 2FA by checking the TOTP or
+// recovery code for the user of the partial token
+func (s *Service) loginSecondFactor(c *gin.Context, userlogin LoginRequest) {
+	claims, err := jwt.ParseMFAToken(userlogin.MFAToken)
+	if err != nil {
+		apperr.Abort(c, apperr.Unauthorized("invalid_mfa_token", "invalid or expired mfa token"))
+		return
+	}
+
+	existinguser, err := s.Users.ByID(claims.ID)
+	if err != nil || !existinguser.TOTPEnabled {
+		apperr.Abort(c, apperr.Unauthorized("invalid_mfa_token", "invalid or expired mfa token"))
+		return
+	}
+
+	ip := c.ClientIP()
+	if err := s.Guard.Check(existinguser.Email, ip); err != nil {
+		logLoginFailure(c, existinguser.Email, existinguser.UserID, "throttled")
+		abortThrottled(c, err)
+		return
+	}
+
+	if !s.verifySecondFactor(&existinguser, userlogin.Code, userlogin.RecoveryCode) {
+		s.recordLoginFailure(c, existinguser.Email, ip, &existinguser)
+		logLoginFailure(c, existinguser.Email, existinguser.UserID, "wrong second factor")
+		apperr.Abort(c, apperr.Unauthorized("invalid_code", "invalid code"))
+		return
+	}
+
+	if err := s.Guard.Success(existinguser.Email); err != nil {
+		logging.For(c).Error("failed to reset login failures", "error", err)
+	}
+	s.Auth.JwtToken(c, existinguser.UserID, existinguser.Email, tokenRole(existinguser))
+	logLogin(c, existinguser, "password+2fa")
+}
+
+// mailLocale returns the language of the emails sent for a request, the one
+// the client prefers among those the messages are translated to
+func mailLocale(c *gin.Context) string {
+	return validation.Language(c.GetHeader("Accept-Language"))
+}
+
+// abortOTPError responds to a failed OTP issue or verification
+func abortOTPError(c *gin.Context, err error) {
+	var cooldown *otp.CooldownError
+	switch {
+	case errors.As(err, &cooldown):
+		apperr.Abort(c, apperr.TooManyRequests("otp_cooldown", cooldown.Error(), cooldown.RetryAfter))
+	case errors.Is(err, otp.ErrTooManyAttempts):
+		apperr.Abort(c, apperr.TooManyRequests("too_many_attempts", "too many failed attempts, request a new otp once this one expires", 0))
+	case errors.Is(err, otp.ErrNotFound), errors.Is(err, otp.ErrExpired), errors.Is(err, otp.ErrInvalid):
+		apperr.Abort(c, apperr.Unauthorized("invalid_otp", "invalid or expired otp"))
+	default:
+		apperr.Abort(c, apperr.Internal("internal", "failed to process otp").Wrap(err))
+	}
+}
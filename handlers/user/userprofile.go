@@ -1,11 +1,13 @@
 package user
 
 import (
-	database "gaming/database"
-	"gaming/model"
+	"encoding/json"
+	"gaming/apperr"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // Define a struct to represent a Person with UserID, Name, and Email fields
@@ -16,18 +18,14 @@ type Person struct {
 }
 
 // UserProfile handles retrieving a user's profile information
-func UserProfile(c *gin.Context) {
-	var user model.User
+func (s *Service) UserProfile(c *gin.Context) {
 	userid := c.GetUint("userid") // Retrieve the user ID from the context
 
 	// Query the database for the user with the given user ID
-	result := database.DB.Where("user_id=?", userid).First(&user)
-	if result.Error != nil {
+	user, err := s.Users.ByID(userid)
+	if err != nil {
 		// If there is an error finding the user, return an internal server error response
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to find user",
-		})
+		apperr.Abort(c, apperr.Internal("internal", "failed to find user").Wrap(err))
 		return
 	}
 
@@ -44,47 +42,50 @@ func UserProfile(c *gin.Context) {
 	})
 }
 
+// UpdateProfileRequest lists the profile fields a user can edit directly.
+// Email and phone are changed through their verification flows and the
+// password through ChangePassword, so they are rejected here.
+type UpdateProfileRequest struct {
+	Name *string `json:"name" binding:"omitempty,min=1,max=100,name"`
+}
+
 // EditUser handles updating a user's profile information
-func EditUser(c *gin.Context) {
-	var edit model.User
+func (s *Service) EditUser(c *gin.Context) {
+	var edit UpdateProfileRequest
 	id := c.GetUint("userid") // Retrieve the user ID from the context
 
-	// Bind the incoming JSON payload to the edit variable
-	result := c.BindJSON(&edit)
-	if result != nil {
-		// If binding fails, return an internal server error response
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to bind json",
-		})
+	// Unknown fields are an error so clients notice when they try to change a protected field
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&edit); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&edit); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
 		return
 	}
 
-	var editUser model.User
 	// Fetch the user from the database using the user ID
-	fetch := database.DB.First(&editUser, id)
-	if fetch.Error != nil {
-		// If there is an error fetching the user, return an internal server error response
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to fetch user",
-		})
+	if _, err := s.Users.ByID(id); err != nil {
+		apperr.Abort(c, apperr.NotFound("user_not_found", "user not found"))
 		return
 	}
 
-	if fetch.RowsAffected > 0 {
-		// If the user is found, update the user's details in the database
-		database.DB.Model(&editUser).Updates(edit)
-		c.JSON(http.StatusOK, gin.H{
-			"status":  http.StatusOK, // Corrected "stauts" to "status"
-			"Message": "User updated successfully",
-		})
-	} else {
-		// If no rows were affected, return an internal server error response
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": "failed to update user",
-		})
-		return
+	// Only the whitelisted fields that were sent are updated
+	updates := map[string]any{}
+	if edit.Name != nil {
+		updates["name"] = strings.TrimSpace(*edit.Name)
 	}
+	if len(updates) > 0 {
+		if err := s.Users.Update(id, updates); err != nil {
+			apperr.Abort(c, apperr.Internal("internal", "failed to update user").Wrap(err))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "User updated successfully",
+	})
 }
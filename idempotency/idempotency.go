@@ -0,0 +1,258 @@
+// Package idempotency makes retried POST requests safe as Gin middleware.
+//
+// A client that may retry a request sends it with an Idempotency-Key header,
+// a unique value it reuses for every retry. The first request with a key is
+// handled as usual and its response is kept for the TTL; a retry with the
+// same key and body gets that response again, marked with an
+// Idempotent-Replayed header, without running the handler twice. Reusing a
+// key for a different request is an error, and so is a retry that arrives
+// while the first request is still being handled.
+//
+// Keys belong to the user set by jwt.AuthMiddleware, which must run first.
+// Requests without the header, or without a user, are not affected.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gaming/apperr"
+	"gaming/config"
+	"gaming/logging"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Header is the request header that carries the key.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set to "true" on replayed responses.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// MaxKeyLength is the longest key accepted.
+const MaxKeyLength = 255
+
+// replayedHeaders are the response headers kept with the body. Others, such
+// as the rate limit headers, describe the retry rather than the response.
+var replayedHeaders = []string{"Content-Type", "Content-Language", "ETag", "Location", "Link"}
+
+var (
+	// ErrInvalidKey is returned for a key that is empty, too long or not
+	// visible ASCII.
+	ErrInvalidKey = apperr.Validation("invalid_idempotency_key", fmt.Sprintf("the %s header must be 1 to %d visible ASCII characters", Header, MaxKeyLength))
+	// ErrKeyReused is returned for a key already used with another request.
+	ErrKeyReused = apperr.Unprocessable("idempotency_key_reused", "the idempotency key was already used for a different request")
+	// ErrInProgress is returned while the first request with a key is still
+	// being handled.
+	ErrInProgress = apperr.Conflict("idempotency_key_in_progress", "a request with this idempotency key is still being handled, please retry later")
+)
+
+// Record is the request made with a key and, once handled, its response.
+type Record struct {
+	UserID      uint
+	Key         string
+	RequestHash string // of the method, URL and body
+	Status      int    // 0 while the request is being handled
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Store keeps the records.
+type Store interface {
+	// Begin saves r for a request that starts being handled and returns true,
+	// unless an unexpired record of its user and key exists; then it returns
+	// that record and false.
+	Begin(r Record, now time.Time) (Record, bool, error)
+	// Complete saves the response of a request saved by Begin.
+	Complete(r Record) error
+	// Release removes the record of a request that failed with a server error
+	// or a panic, so that a retry runs it again. It is never called once the
+	// handler succeeded.
+	Release(userID uint, key string) error
+	// DeleteExpired removes the records that expired before now.
+	DeleteExpired(now time.Time) error
+}
+
+// Cache keeps the responses of requests with a key for the TTL.
+type Cache struct {
+	Store Store
+	TTL   time.Duration
+}
+
+// Default is the cache used by Middleware.
+var Default = &Cache{Store: NewMemoryStore(), TTL: 24 * time.Hour}
+
+// Configure sets the store and TTL of the default cache from the config. The
+// database store keeps the records in db.
+func Configure(cfg config.Idempotency, db *gorm.DB) error {
+	switch cfg.Store {
+	case "", "memory":
+		Default.Store = NewMemoryStore()
+	case "database":
+		Default.Store = DBStore{DB: db}
+	default:
+		return fmt.Errorf("unknown idempotency store %q", cfg.Store)
+	}
+	if cfg.TTL > 0 {
+		Default.TTL = cfg.TTL
+	}
+	return nil
+}
+
+// Middleware replays responses with the default cache.
+func Middleware() gin.HandlerFunc {
+	return Default.Middleware()
+}
+
+// StartCleanup removes the expired records of the default cache periodically
+// in the background.
+func StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := Default.Store.DeleteExpired(time.Now()); err != nil {
+				slog.Error("failed to clean up idempotency records", "error", err)
+			}
+		}
+	}()
+}
+
+// Middleware handles the first request with a key and replays its response
+// to the retries. Server errors are not kept, so that a retry runs again. If
+// the response to a successful request cannot be saved, the key is kept in
+// progress until it expires rather than let a retry repeat the request.
+func (k *Cache) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, userID := c.GetHeader(Header), c.GetUint("userid")
+		if key == "" || userID == 0 {
+			c.Next()
+			return
+		}
+		if !validKey(key) {
+			apperr.Abort(c, ErrInvalidKey)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			apperr.Abort(c, apperr.Validation("invalid_request", "the request body could not be read").Wrap(err))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := Record{UserID: userID, Key: key, RequestHash: requestHash(c.Request, body), ExpiresAt: now.Add(k.TTL)}
+		existing, started, err := k.Store.Begin(record, now)
+		if err != nil {
+			// Unlike the rate limits this fails closed: running a retry twice
+			// could charge twice
+			apperr.Abort(c, apperr.Internal("internal", "failed to check the idempotency key").Wrap(err))
+			return
+		}
+		if !started {
+			replay(c, record, existing)
+			return
+		}
+
+		handled := false
+		defer func() {
+			// Also runs when the handler panics
+			if handled {
+				return
+			}
+			if err := k.Store.Release(userID, key); err != nil {
+				logging.For(c).Error("failed to release idempotency key", "error", err)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			return
+		}
+		// The handler succeeded, so its side effects happened: from here on a
+		// retry must never run it again, even if the response is not saved
+		handled = true
+		record.Status = recorder.Status()
+		record.Body = recorder.body.Bytes()
+		record.Header = http.Header{}
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				record.Header.Set(name, value)
+			}
+		}
+		if err := k.Store.Complete(record); err != nil {
+			// The key stays in progress until it expires, so retries get
+			// ErrInProgress instead of running the handler again
+			logging.For(c).Error("failed to save idempotent response", "error", err)
+		}
+	}
+}
+
+// replay answers a retry with the response to the first request with its key.
+func replay(c *gin.Context, retry, first Record) {
+	switch {
+	case first.RequestHash != retry.RequestHash:
+		apperr.Abort(c, ErrKeyReused)
+	case first.Status == 0:
+		apperr.Abort(c, ErrInProgress)
+	default:
+		for name, values := range first.Header {
+			for _, value := range values {
+				c.Writer.Header().Add(name, value)
+			}
+		}
+		c.Header(ReplayedHeader, "true")
+		c.Status(first.Status)
+		c.Writer.Write(first.Body)
+		c.Abort()
+	}
+}
+
+// requestHash identifies a request by its method, URL and body, so that a key
+// reused for another request is noticed.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validKey reports whether a key is 1 to MaxKeyLength visible ASCII characters.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > MaxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder keeps a copy of the body written to the response.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failingStore is a MemoryStore that cannot save responses.
+type failingStore struct {
+	*MemoryStore
+}
+
+func (failingStore) Complete(Record) error {
+	return errors.New("database is down")
+}
+
+// newTestRouter returns a router whose POST /pay answers with status, after
+// counting the call in calls, for user 7 with the cache on store.
+func newTestRouter(store Store, status int, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	cache := &Cache{Store: store, TTL: time.Hour}
+	r := gin.New()
+	r.POST("/pay", func(c *gin.Context) { c.Set("userid", uint(7)) }, cache.Middleware(), func(c *gin.Context) {
+		*calls++
+		c.JSON(status, gin.H{"call": *calls})
+	})
+	return r
+}
+
+func pay(r http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/pay", strings.NewReader(body))
+	req.Header.Set(Header, key)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReplay(t *testing.T) {
+	calls := 0
+	r := newTestRouter(NewMemoryStore(), http.StatusCreated, &calls)
+
+	first := pay(r, "k1", `{"amount":5}`)
+	retry := pay(r, "k1", `{"amount":5}`)
+	if calls != 1 || retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() || retry.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("retry = %d %s after %d calls, want the first response replayed", retry.Code, retry.Body, calls)
+	}
+	if rec := pay(r, "k1", `{"amount":6}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key: got %d, want 422", rec.Code)
+	}
+	if rec := pay(r, "bad key", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid key: got %d, want 400", rec.Code)
+	}
+}
+
+func TestServerErrorReleasesKey(t *testing.T) {
+	calls := 0
+	r := newTestRouter(NewMemoryStore(), http.StatusInternalServerError, &calls)
+	pay(r, "k1", `{}`)
+	pay(r, "k1", `{}`)
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want a retry after a server error to run again", calls)
+	}
+}
+
+func TestUnsavedResponseKeepsKey(t *testing.T) {
+	calls := 0
+	r := newTestRouter(failingStore{NewMemoryStore()}, http.StatusCreated, &calls)
+	if rec := pay(r, "k1", `{}`); rec.Code != http.StatusCreated {
+		t.Fatalf("first request: got %d", rec.Code)
+	}
+	if rec := pay(r, "k1", `{}`); rec.Code != http.StatusConflict || calls != 1 {
+		t.Errorf("retry: got %d after %d calls, want 409 without running the handler again", rec.Code, calls)
+	}
+}
@@ -0,0 +1,140 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"gaming/model"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// recordKey identifies a record.
+type recordKey struct {
+	userID uint
+	key    string
+}
+
+// MemoryStore keeps records in memory. The records are lost on restart and
+// not shared between replicas, so it suits a single instance or tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[recordKey]Record
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[recordKey]Record)}
+}
+
+func (s *MemoryStore) Begin(r Record, now time.Time) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[recordKey{r.UserID, r.Key}]; ok && existing.ExpiresAt.After(now) {
+		return existing, false, nil
+	}
+	s.records[recordKey{r.UserID, r.Key}] = r
+	return r, true, nil
+}
+
+func (s *MemoryStore) Complete(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[recordKey{r.UserID, r.Key}] = r
+	return nil
+}
+
+func (s *MemoryStore) Release(userID uint, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, recordKey{userID, key})
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, r := range s.records {
+		if !r.ExpiresAt.After(now) {
+			delete(s.records, k)
+		}
+	}
+	return nil
+}
+
+// DBStore keeps records in the idempotency_records table, shared by all
+// replicas. The primary key on user and key lets only one of two concurrent
+// requests with the same key begin.
+type DBStore struct {
+	DB *gorm.DB
+}
+
+func (s DBStore) Begin(r Record, now time.Time) (Record, bool, error) {
+	var existing Record
+	started := false
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		// An expired record does not hold the key any more
+		if err := tx.Where("user_id = ? AND key = ? AND expires_at <= ?", r.UserID, r.Key, now).Delete(&model.IdempotencyRecord{}).Error; err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.IdempotencyRecord{
+			UserID:      r.UserID,
+			Key:         r.Key,
+			RequestHash: r.RequestHash,
+			CreatedAt:   now,
+			ExpiresAt:   r.ExpiresAt,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			started = true
+			return nil
+		}
+
+		var row model.IdempotencyRecord
+		if err := tx.Where("user_id = ? AND key = ?", r.UserID, r.Key).First(&row).Error; err != nil {
+			return err
+		}
+		existing = Record{
+			UserID:      row.UserID,
+			Key:         row.Key,
+			RequestHash: row.RequestHash,
+			Status:      row.Status,
+			Body:        []byte(row.Body),
+			ExpiresAt:   row.ExpiresAt,
+		}
+		if row.Header != "" {
+			return json.Unmarshal([]byte(row.Header), &existing.Header)
+		}
+		return nil
+	})
+	if started {
+		return r, true, err
+	}
+	return existing, false, err
+}
+
+func (s DBStore) Complete(r Record) error {
+	header, err := json.Marshal(r.Header)
+	if err != nil {
+		return err
+	}
+	result := s.DB.Model(&model.IdempotencyRecord{}).
+		Where("user_id = ? AND key = ?", r.UserID, r.Key).
+		Updates(map[string]any{"status": r.Status, "header": string(header), "body": string(r.Body)})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errors.New("idempotency record not found")
+	}
+	return result.Error
+}
+
+func (s DBStore) Release(userID uint, key string) error {
+	return s.DB.Where("user_id = ? AND key = ?", userID, key).Delete(&model.IdempotencyRecord{}).Error
+}
+
+func (s DBStore) DeleteExpired(now time.Time) error {
+	return s.DB.Where("expires_at <= ?", now).Delete(&model.IdempotencyRecord{}).Error
+}
@@ -0,0 +1,8 @@
+// Package integration tests HTTP-level flows through the whole API on
+// in-memory SQLite databases, with no external services:
+//
+//	go test ./integration [-run TestScenarios/regexp] [-args -server-logs]
+//
+// Each scenario gets a freshly migrated database. The scenarios are skipped
+// with -short.
+package integration
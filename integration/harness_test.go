@@ -0,0 +1,206 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gaming/audit"
+	"gaming/config"
+	database "gaming/database"
+	"gaming/idempotency"
+	"gaming/jwt"
+	"gaming/loginguard"
+	"gaming/mailer"
+	"gaming/otp"
+	"gaming/ratelimit"
+	"gaming/repository"
+	"gaming/server"
+	"gaming/sms"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Harness is the API running on a fresh in-memory SQLite database, with
+// emails and text messages kept in memory.
+type Harness struct {
+	Handler http.Handler
+	DB      *gorm.DB
+	Repos   repository.Repositories
+	OTP     *otp.Service // without a resend cooldown, which scenarios may set
+	Mail    *mailer.MemoryMailer
+	SMS     *sms.FakeSender
+}
+
+// NewHarness migrates a new database and builds the router on it. The
+// package level defaults the handlers use are replaced, so harnesses must
+// not be used concurrently.
+func NewHarness() (*Harness, error) {
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		return nil, err
+	}
+	if !*serverLogs {
+		db.Logger = logger.Discard
+	}
+
+	if err := jwt.LoadKeys(config.JWT{TokenTTL: time.Hour, MFATokenTTL: 5 * time.Minute}); err != nil {
+		return nil, err
+	}
+	// Every request comes from the same address, so the per-IP limits are raised
+	if err := ratelimit.Configure(config.RateLimit{Auth: "1000/1m", Write: "1000/1m", Read: "1000/1m"}, db); err != nil {
+		return nil, err
+	}
+	if err := idempotency.Configure(config.Idempotency{Store: "database", TTL: time.Hour}, db); err != nil {
+		return nil, err
+	}
+
+	h := &Harness{
+		DB:    db,
+		Repos: repository.NewGorm(db),
+		Mail:  mailer.NewMemoryMailer(),
+		SMS:   sms.NewFakeSender(),
+	}
+	mailer.Default = h.Mail
+	mailer.DefaultOutbox.DB = db
+	audit.Default = audit.DBStore{DB: db}
+	sms.Default = h.SMS
+
+	h.OTP = otp.NewService(h.Repos.OTPs)
+	h.OTP.ResendCooldown = 0
+	// Failed logins lock accounts as usual, but without slowing the flows down
+	guard := *loginguard.Default
+	guard.Store = loginguard.NewMemoryStore()
+	guard.BaseDelay = 0
+	h.Handler = server.New(h.Repos, h.OTP, &guard)
+	return h, nil
+}
+
+// Response is a decoded JSON response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   map[string]any
+}
+
+// Do sends a request with body encoded as JSON, authenticated with the
+// token unless it is empty, with extra headers given as name, value pairs.
+func (h *Harness) Do(method, path string, body any, token string, headers ...string) (Response, error) {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return Response{}, err
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.Handler.ServeHTTP(rec, req)
+
+	resp := Response{Status: rec.Code, Header: rec.Header()}
+	if rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp.Body); err != nil {
+			return resp, fmt.Errorf("%s %s: response is not a JSON object: %s", method, path, rec.Body.String())
+		}
+	}
+	return resp, nil
+}
+
+// Expect sends a request like Do and fails unless the response has the
+// wanted status.
+func (h *Harness) Expect(want int, method, path string, body any, token string) (Response, error) {
+	resp, err := h.Do(method, path, body, token)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Status != want {
+		return resp, fmt.Errorf("%s %s: got status %d, want %d: %v", method, path, resp.Status, want, resp.Body)
+	}
+	return resp, nil
+}
+
+var otpCode = regexp.MustCompile(`\b\d{6}\b`)
+
+// LastOTP delivers the mail outbox and returns the code in the latest email
+// sent to the address.
+func (h *Harness) LastOTP(email string) (string, error) {
+	if _, err := mailer.DefaultOutbox.ProcessDue(context.Background(), h.Mail); err != nil {
+		return "", err
+	}
+	msg, ok := h.Mail.Last(email)
+	if !ok {
+		return "", fmt.Errorf("no email was sent to %s", email)
+	}
+	code := otpCode.FindString(msg.Text)
+	if code == "" {
+		return "", fmt.Errorf("no code in the email to %s: %q", email, msg.Text)
+	}
+	return code, nil
+}
+
+// SignUp registers and verifies an account, logs it in and returns its user
+// ID and token.
+func (h *Harness) SignUp(name, email, password string) (uint, string, error) {
+	signup := map[string]any{"name": name, "email": email, "password": password}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/signup", signup, ""); err != nil {
+		return 0, "", err
+	}
+	code, err := h.LastOTP(email)
+	if err != nil {
+		return 0, "", err
+	}
+	verify := map[string]any{"email": email, "otp": code}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/verification", verify, ""); err != nil {
+		return 0, "", err
+	}
+	token, err := h.Login(email, password)
+	if err != nil {
+		return 0, "", err
+	}
+	user, err := h.Repos.Users.ByEmail(email)
+	return user.UserID, token, err
+}
+
+// Login logs an account in and returns its token.
+func (h *Harness) Login(email, password string) (string, error) {
+	resp, err := h.Expect(http.StatusOK, "POST", "/user/login", map[string]any{"email": email, "password": password}, "")
+	if err != nil {
+		return "", err
+	}
+	token, _ := resp.Body["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("login of %s returned no token: %v", email, resp.Body)
+	}
+	return token, nil
+}
+
+// Promote gives the account the admin role. It takes effect at the next login.
+func (h *Harness) Promote(userID uint) error {
+	return h.Repos.Users.Update(userID, map[string]any{"role": "admin"})
+}
+
+// SetScore sets the score of a team in table directly, without the start and
+// If-Match checks of the score routes.
+func (h *Harness) SetScore(table string, teamID, score float64) error {
+	return h.DB.Table(table).Where("id = ?", uint(teamID)).Update("score", score).Error
+}
+
+// SetStartTime moves the start of a competition in table, e.g. into the past
+// which the create rules do not allow.
+func (h *Harness) SetStartTime(table string, id float64, start time.Time) error {
+	return h.DB.Table(table).Where("id = ?", uint(id)).Update("start_time", start).Error
+}
@@ -0,0 +1,48 @@
+package integration
+
+import (
+	"flag"
+	"fmt"
+	"gaming/config"
+	"gaming/logging"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverLogs shows the logs of the server and its database queries.
+var serverLogs = flag.Bool("server-logs", false, "show the logs of the server")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	gin.SetMode(gin.TestMode)
+	var logs io.Writer = os.Stderr
+	if !*serverLogs {
+		logs = io.Discard
+	}
+	if err := logging.Configure(config.Log{Level: "debug", Format: "text"}, logs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	os.Exit(m.Run())
+}
+
+// TestScenarios runs every scenario on a new harness.
+func TestScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration scenarios are skipped with -short")
+	}
+	for _, scenario := range Scenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			h, err := NewHarness()
+			if err != nil {
+				t.Fatalf("setting up: %v", err)
+			}
+			if err := scenario.Run(h); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1266 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gaming/config"
+	"gaming/logging"
+	"gaming/oidcauth"
+	"gaming/oidcauth/mockissuer"
+	"gaming/repository"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scenario is a flow through the API, run on its own harness.
+type Scenario struct {
+	Name string
+	Run  func(h *Harness) error
+}
+
+// Scenarios are the flows run by the suite.
+var Scenarios = []Scenario{
+	{"auth/signup-login-logout", signupLoginLogout},
+	{"auth/duplicate-signup", duplicateSignup},
+	{"auth/concurrent-signup", concurrentSignup},
+	{"auth/concurrent-signups", concurrentSignups},
+	{"auth/wrong-otp", wrongOTP},
+	{"auth/password-reset", passwordReset},
+	{"auth/admin-routes", adminRoutes},
+	{"user/email-change", emailChange},
+	{"user/oidc-login", oidcLogin},
+	{"errors/problem-details", problemDetails},
+	{"errors/validation", validationErrors},
+	{"logging/request-ids", requestLogs},
+	{"league/create-team-join", leagueFlow},
+	{"league/update-delete-restore", leagueLifecycle},
+	{"league/list-pages", leagueListing},
+	{"league/concurrent-edits", leagueConcurrentEdits},
+	{"league/idempotent-retries", leagueIdempotentRetries},
+	{"tournament/team-guards", tournamentTeamGuards},
+	{"tournament/create-teams-join", tournamentFlow},
+	{"result/league-winner", leagueResult},
+	{"result/tournament-winner", tournamentResult},
+	{"result/report-scores", reportScores},
+}
+
+func signupLoginLogout(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	resp, err := h.Expect(http.StatusOK, "GET", "/user/profile", nil, token)
+	if err != nil {
+		return err
+	}
+	if id := number(resp.Body, "user", "UserID"); id != float64(userID) {
+		return fmt.Errorf("profile has user id %v, want %d", id, userID)
+	}
+	if _, err := h.Expect(http.StatusUnauthorized, "POST", "/user/login", map[string]any{"email": "ada@example.com", "password": "wrong"}, ""); err != nil {
+		return err
+	}
+	resp, err = h.Expect(http.StatusUnauthorized, "GET", "/user/profile", nil, "")
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "token_missing"); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/logout", nil, token); err != nil {
+		return err
+	}
+	_, err = h.Expect(http.StatusUnauthorized, "GET", "/user/profile", nil, token)
+	return err
+}
+
+func duplicateSignup(h *Harness) error {
+	if _, _, err := h.SignUp("Ada", "ada@example.com", "correct horse"); err != nil {
+		return err
+	}
+	// Emails are compared ignoring case, so the address cannot be taken twice
+	for _, email := range []string{"ada@example.com", "Ada@Example.COM"} {
+		if _, err := h.Expect(http.StatusConflict, "POST", "/user/signup", map[string]any{"name": "Ada", "email": email, "password": "another horse"}, ""); err != nil {
+			return err
+		}
+	}
+	_, err := h.Login("ADA@example.com", "correct horse")
+	return err
+}
+
+func concurrentSignup(h *Harness) error {
+	// Of several signups for one email at once, only the first to get a code
+	// stores its pending account; the others are refused by the cooldown
+	h.OTP.ResendCooldown = time.Minute
+	const n = 5
+	statuses := make([]int, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			signup := map[string]any{"name": "Ada", "email": "ada@example.com", "password": fmt.Sprintf("password %d", i)}
+			resp, err := h.Do("POST", "/user/signup", signup, "")
+			statuses[i], errs[i] = resp.Status, err
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	winner := -1
+	for i, status := range statuses {
+		switch {
+		case status == http.StatusOK && winner < 0:
+			winner = i
+		case status != http.StatusTooManyRequests:
+			return fmt.Errorf("concurrent signups got statuses %v, want one 200 and 429 for the others", statuses)
+		}
+	}
+	if winner < 0 {
+		return fmt.Errorf("concurrent signups got statuses %v, want one 200", statuses)
+	}
+
+	// A signup during the cooldown does not replace the password either
+	if _, err := h.Expect(http.StatusTooManyRequests, "POST", "/user/signup", map[string]any{"name": "Eve", "email": "ada@example.com", "password": "eve's password"}, ""); err != nil {
+		return err
+	}
+	code, err := h.LastOTP("ada@example.com")
+	if err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/verification", map[string]any{"email": "ada@example.com", "otp": code}, ""); err != nil {
+		return err
+	}
+	token, err := h.Login("ada@example.com", fmt.Sprintf("password %d", winner))
+	if err != nil {
+		return err
+	}
+	resp, err := h.Expect(http.StatusOK, "GET", "/user/profile", nil, token)
+	if err != nil {
+		return err
+	}
+	if user, _ := resp.Body["user"].(map[string]any); user["Name"] != "Ada" {
+		return fmt.Errorf("profile of the new account = %v, want the winning signup", resp.Body)
+	}
+	return nil
+}
+
+func concurrentSignups(h *Harness) error {
+	// Signups for different emails at once each keep their own name and
+	// password until they are verified
+	const n = 5
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			signup := map[string]any{"name": fmt.Sprintf("Player %d", i), "email": fmt.Sprintf("player%d@example.com", i), "password": fmt.Sprintf("password %d", i)}
+			_, errs[i] = h.Expect(http.StatusOK, "POST", "/user/signup", signup, "")
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		email := fmt.Sprintf("player%d@example.com", i)
+		code, err := h.LastOTP(email)
+		if err != nil {
+			return err
+		}
+		if _, err := h.Expect(http.StatusOK, "POST", "/user/verification", map[string]any{"email": email, "otp": code}, ""); err != nil {
+			return err
+		}
+		token, err := h.Login(email, fmt.Sprintf("password %d", i))
+		if err != nil {
+			return fmt.Errorf("%s cannot log in with its own password: %w", email, err)
+		}
+		resp, err := h.Expect(http.StatusOK, "GET", "/user/profile", nil, token)
+		if err != nil {
+			return err
+		}
+		if user, _ := resp.Body["user"].(map[string]any); user["Name"] != fmt.Sprintf("Player %d", i) || user["Email"] != email {
+			return fmt.Errorf("profile of %s = %v, want its own signup", email, resp.Body)
+		}
+	}
+	return nil
+}
+
+func wrongOTP(h *Harness) error {
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/signup", map[string]any{"name": "Ada", "email": "ada@example.com", "password": "correct horse"}, ""); err != nil {
+		return err
+	}
+	code, err := h.LastOTP("ada@example.com")
+	if err != nil {
+		return err
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if _, err := h.Expect(http.StatusUnauthorized, "POST", "/user/verification", map[string]any{"email": "ada@example.com", "otp": wrong}, ""); err != nil {
+		return err
+	}
+	// The account is only created once the right code is given
+	if _, err := h.Expect(http.StatusUnauthorized, "POST", "/user/login", map[string]any{"email": "ada@example.com", "password": "correct horse"}, ""); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/verification", map[string]any{"email": "ada@example.com", "otp": code}, ""); err != nil {
+		return err
+	}
+	_, err = h.Login("ada@example.com", "correct horse")
+	return err
+}
+
+func passwordReset(h *Harness) error {
+	_, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	resp, err := h.Expect(http.StatusOK, "POST", "/user/api-keys", map[string]any{"name": "bot", "scopes": []string{"read"}}, token)
+	if err != nil {
+		return err
+	}
+	key, _ := resp.Body["data"].(map[string]any)["key"].(string)
+	resp, err = h.Do("GET", "/user/profile", nil, "", "X-API-Key", key)
+	if err != nil {
+		return err
+	}
+	if resp.Status != http.StatusOK {
+		return fmt.Errorf("API key before the reset: got %d, want 200", resp.Status)
+	}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/password/forgot", map[string]any{"email": "ada@example.com"}, ""); err != nil {
+		return err
+	}
+	code, err := h.LastOTP("ada@example.com")
+	if err != nil {
+		return err
+	}
+	// bcrypt would silently drop the end of a longer password
+	reset := map[string]any{"email": "ada@example.com", "otp": code, "new_password": strings.Repeat("x", 73)}
+	resp, err = h.Expect(http.StatusBadRequest, "POST", "/user/password/reset", reset, "")
+	if err != nil {
+		return err
+	}
+	if got := fieldCodes(resp); got["new_password"] != "max" {
+		return fmt.Errorf("got field errors %v, want new_password max", got)
+	}
+	reset["new_password"] = "battery staple"
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/password/reset", reset, ""); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusUnauthorized, "POST", "/user/login", map[string]any{"email": "ada@example.com", "password": "correct horse"}, ""); err != nil {
+		return err
+	}
+	// The reset revokes the API keys of the account along with its sessions
+	resp, err = h.Do("GET", "/user/profile", nil, "", "X-API-Key", key)
+	if err != nil {
+		return err
+	}
+	if resp.Status != http.StatusUnauthorized {
+		return fmt.Errorf("API key after the reset: got %d, want 401", resp.Status)
+	}
+	_, err = h.Login("ada@example.com", "battery staple")
+	return err
+}
+
+func emailChange(h *Harness) error {
+	if _, _, err := h.SignUp("Ada", "ada@example.com", "correct horse"); err != nil {
+		return err
+	}
+	_, token, err := h.SignUp("Bob", "bob@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusConflict, "POST", "/user/email", map[string]any{"email": "ADA@example.com"}, token); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/email", map[string]any{"email": "Bobby@Example.com"}, token); err != nil {
+		return err
+	}
+	code, err := h.LastOTP("bobby@example.com")
+	if err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/email/confirm", map[string]any{"otp": code}, token); err != nil {
+		return err
+	}
+	_, err = h.Login("bobby@example.com", "correct horse")
+	return err
+}
+
+func oidcLogin(h *Harness) error {
+	userID, _, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	iss, err := mockissuer.Start("gaming", mockissuer.User{Subject: "ada-1", Email: "Ada@Example.com", EmailVerified: true, Name: "Ada"})
+	if err != nil {
+		return err
+	}
+	defer iss.Close()
+	provider, err := oidcauth.NewProvider(context.Background(), "mock", iss.URL, "gaming", "", "http://localhost/auth/mock/callback", []string{"openid", "email", "profile"})
+	if err != nil {
+		return err
+	}
+	oidcauth.Register(provider)
+
+	callback, cookie, err := startOIDCLogin(h, "mock")
+	if err != nil {
+		return err
+	}
+	if cookie.Path != "/auth/mock/callback" || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		return fmt.Errorf("state cookie %s is not limited to the callback", cookie)
+	}
+	// The callback is refused in a browser that did not start the login
+	for _, header := range []string{"", "oidc_state=" + strings.Repeat("0", len(cookie.Value))} {
+		resp, err := h.Do("GET", callback, nil, "", "Cookie", header)
+		if err != nil {
+			return err
+		}
+		if err := problemCode(resp, "invalid_login_state"); err != nil {
+			return fmt.Errorf("callback with cookie %q: %w", header, err)
+		}
+	}
+
+	// The verified email is matched to the account regardless of case
+	resp, err := h.Do("GET", callback, nil, "", "Cookie", cookie.String())
+	if err != nil {
+		return err
+	}
+	token, _ := resp.Body["token"].(string)
+	if resp.Status != http.StatusOK || token == "" {
+		return fmt.Errorf("callback: got %d %v, want a token", resp.Status, resp.Body)
+	}
+	resp, err = h.Expect(http.StatusOK, "GET", "/user/profile", nil, token)
+	if err != nil {
+		return err
+	}
+	if id := number(resp.Body, "user", "UserID"); id != float64(userID) {
+		return fmt.Errorf("provider login signed in user %v, want the account %d with the email", id, userID)
+	}
+
+	// The state is single-use
+	resp, err = h.Do("GET", callback, nil, "", "Cookie", cookie.String())
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "invalid_login_state"); err != nil {
+		return err
+	}
+
+	// An unverified email neither signs in to the account with it nor
+	// creates one, so it cannot be claimed before its owner signs up
+	for i, email := range []string{"ada@example.com", "grace@example.com"} {
+		iss.SetUser(mockissuer.User{Subject: fmt.Sprintf("unverified-%d", i), Email: email, Name: "Eve"})
+		callback, cookie, err := startOIDCLogin(h, "mock")
+		if err != nil {
+			return err
+		}
+		resp, err := h.Do("GET", callback, nil, "", "Cookie", cookie.String())
+		if err != nil {
+			return err
+		}
+		if err := problemCode(resp, "email_not_verified"); err != nil {
+			return fmt.Errorf("unverified %s: %w", email, err)
+		}
+	}
+	_, err = h.Repos.Users.ByEmail("grace@example.com")
+	if !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("an unverified provider email created an account: %v", err)
+	}
+	return nil
+}
+
+// startOIDCLogin starts a login with the provider and has the issuer approve
+// it. It returns the callback path and query the issuer redirects to, and the
+// state cookie set by the login.
+func startOIDCLogin(h *Harness, provider string) (string, *http.Cookie, error) {
+	rec := httptest.NewRecorder()
+	h.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/"+provider+"/login", nil))
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "oidc_state" {
+			cookie = c
+		}
+	}
+	if rec.Code != http.StatusFound || cookie == nil {
+		return "", nil, fmt.Errorf("login: got %d with cookies %v, want a redirect setting the state cookie", rec.Code, rec.Result().Cookies())
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		return "", nil, err
+	}
+	resp.Body.Close()
+	callback, err := resp.Location()
+	if err != nil {
+		return "", nil, fmt.Errorf("the issuer did not redirect back: %w", err)
+	}
+	return callback.RequestURI(), cookie, nil
+}
+
+func adminRoutes(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	resp, err := h.Expect(http.StatusForbidden, "GET", "/admin/audit", nil, token)
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "forbidden"); err != nil {
+		return err
+	}
+	if err := h.Promote(userID); err != nil {
+		return err
+	}
+	token, err = h.Login("ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	resp, err = h.Expect(http.StatusOK, "GET", "/admin/audit?action=user.signup", nil, token)
+	if err != nil {
+		return err
+	}
+	if events, _ := resp.Body["data"].([]any); len(events) != 1 {
+		return fmt.Errorf("got %d signup audit events, want 1", len(events))
+	}
+	return nil
+}
+
+func problemDetails(h *Harness) error {
+	resp, err := h.Expect(http.StatusNotFound, "GET", "/no/such/route", nil, "")
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "route_not_found"); err != nil {
+		return err
+	}
+	resp, err = h.Expect(http.StatusBadRequest, "POST", "/user/signup", map[string]any{"name": "Ada", "email": "not an email"}, "")
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "invalid_request"); err != nil {
+		return err
+	}
+	fields := fieldCodes(resp)
+	if fields["email"] != "email" || fields["password"] != "required" {
+		return fmt.Errorf("got field errors %v, want email and password", fields)
+	}
+	_, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	resp, err = h.Expect(http.StatusNotFound, "POST", "/user/leagues/join", map[string]any{"league_id": 42}, token)
+	if err != nil {
+		return err
+	}
+	return problemCode(resp, "league_not_found")
+}
+
+func requestLogs(h *Harness) error {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	if err := logging.Configure(config.Log{Level: "debug", Format: "json"}, &logs); err != nil {
+		return err
+	}
+
+	signup := map[string]any{"name": "Ada", "email": "ada@example.com", "password": "correct horse"}
+	resp, err := h.Do("POST", "/user/signup", signup, "", "X-Request-ID", "signup-1")
+	if err != nil {
+		return err
+	}
+	if resp.Status != http.StatusOK || resp.Header.Get("X-Request-ID") != "signup-1" {
+		return fmt.Errorf("signup: got status %d and request id %q, want 200 and signup-1", resp.Status, resp.Header.Get("X-Request-ID"))
+	}
+	code, err := h.LastOTP("ada@example.com")
+	if err != nil {
+		return err
+	}
+	// An ID that could forge log lines is replaced
+	resp, err = h.Do("GET", "/user/profile", nil, "Bearer not.a.token", "X-Request-ID", "bad id\n")
+	if err != nil {
+		return err
+	}
+	if id := resp.Header.Get("X-Request-ID"); len(id) != 32 {
+		return fmt.Errorf("got generated request id %q, want 32 hex digits", id)
+	}
+
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return fmt.Errorf("log line is not JSON: %s", line)
+		}
+		if record["msg"] == "request" && record["request_id"] == "signup-1" && record["status"] == float64(http.StatusOK) {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no request record with id signup-1 in the log:\n%s", logs.String())
+	}
+	for _, secret := range []string{"ada@example.com", "correct horse", code, "not.a.token"} {
+		if strings.Contains(logs.String(), secret) {
+			return fmt.Errorf("the log contains %q:\n%s", secret, logs.String())
+		}
+	}
+	return nil
+}
+
+func validationErrors(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	league := map[string]any{"name": " Spring Cup", "prize_pool": -5, "start_time": time.Now().Add(-time.Hour), "id": 7}
+	resp, err := h.Expect(http.StatusBadRequest, "POST", "/user/leagues", league, token)
+	if err != nil {
+		return err
+	}
+	want := map[string]string{"name": "name", "prize_pool": "money", "start_time": "future"}
+	if got := fieldCodes(resp); !equalCodes(got, want) {
+		return fmt.Errorf("got field errors %v, want %v", got, want)
+	}
+	league = map[string]any{"name": "Spring Cup", "prize_pool": 10.005, "start_time": nextWeek()}
+	if resp, err = h.Expect(http.StatusBadRequest, "POST", "/user/leagues", league, token); err != nil {
+		return err
+	}
+	if got := fieldCodes(resp); got["prize_pool"] != "money" {
+		return fmt.Errorf("got field errors %v, want prize_pool money", got)
+	}
+
+	// Messages follow Accept-Language, codes do not
+	resp, err = h.Do("POST", "/user/tournament", map[string]any{"prize_pool": 100}, token, "Accept-Language", "es-ES, en;q=0.5")
+	if err != nil {
+		return err
+	}
+	problems, _ := resp.Body["errors"].([]any)
+	if len(problems) != 2 {
+		return fmt.Errorf("got %d field errors, want 2", len(problems))
+	}
+	if message, _ := problems[0].(map[string]any)["message"].(string); message != "es obligatorio" {
+		return fmt.Errorf("got message %q, want it in Spanish", message)
+	}
+
+	// Clients cannot set the score of a team
+	leagueID, err := createLeague(h, token, "Spring Cup", 1000)
+	if err != nil {
+		return err
+	}
+	team := map[string]any{"name": "Rockets", "player_id": userID, "league_id": leagueID, "score": 99}
+	if resp, err = h.Expect(http.StatusOK, "POST", "/user/league/team", team, token); err != nil {
+		return err
+	}
+	if score := number(resp.Body, "data", "score"); score != 0 {
+		return fmt.Errorf("new team has score %v, want 0", score)
+	}
+	return nil
+}
+
+func leagueFlow(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	leagueID, err := createLeague(h, token, "Spring Cup", 1000)
+	if err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusConflict, "POST", "/user/leagues", map[string]any{"name": "Spring Cup", "start_time": nextWeek()}, token); err != nil {
+		return err
+	}
+	if _, err := createTeam(h, token, "teams", "/user/league/team", "Rockets", userID, "league_id", leagueID, 10); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusConflict, "POST", "/user/league/team", map[string]any{"name": "Rockets", "player_id": userID, "league_id": leagueID}, token); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusNotFound, "POST", "/user/league/team", map[string]any{"name": "Comets", "player_id": userID, "league_id": leagueID + 100}, token); err != nil {
+		return err
+	}
+
+	resp, err := h.Expect(http.StatusOK, "GET", "/user/leagues", nil, token)
+	if err != nil {
+		return err
+	}
+	leagues, _ := resp.Body["data"].([]any)
+	if len(leagues) != 1 {
+		return fmt.Errorf("got %d leagues, want 1", len(leagues))
+	}
+	if teams, _ := leagues[0].(map[string]any)["teams"].([]any); len(teams) != 1 {
+		return fmt.Errorf("league has %d teams, want 1", len(teams))
+	}
+
+	if _, err := h.Expect(http.StatusOK, "POST", "/user/leagues/join", map[string]any{"league_id": leagueID}, token); err != nil {
+		return err
+	}
+	_, err = h.Expect(http.StatusNotFound, "POST", "/user/leagues/join", map[string]any{"league_id": leagueID + 100}, token)
+	return err
+}
+
+func leagueLifecycle(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	_, other, err := h.SignUp("Bob", "bob@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	leagueID, err := createLeague(h, token, "Spring Cup", 1000)
+	if err != nil {
+		return err
+	}
+	teamID, err := createTeam(h, token, "teams", "/user/league/team", "Rockets", userID, "league_id", leagueID, 10)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/user/leagues/%d", int(leagueID))
+	teamPath := fmt.Sprintf("/user/league/team/%d", int(teamID))
+
+	resp, err := h.Expect(http.StatusOK, "PATCH", path, map[string]any{"name": "Summer Cup", "prize_pool": 2000}, token)
+	if err != nil {
+		return err
+	}
+	if name, _ := resp.Body["data"].(map[string]any)["name"].(string); name != "Summer Cup" {
+		return fmt.Errorf("got league name %q after update, want Summer Cup", name)
+	}
+	resp, err = h.Expect(http.StatusForbidden, "PATCH", path, map[string]any{"name": "Bob Cup"}, other)
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "not_owner"); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusBadRequest, "PATCH", teamPath, map[string]any{"score": 99}, token); err != nil {
+		return err
+	}
+
+	if _, err := h.Expect(http.StatusOK, "DELETE", path, nil, token); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusNotFound, "GET", path, nil, token); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusNotFound, "GET", teamPath, nil, token); err != nil {
+		return err
+	}
+	clashID, err := createLeague(h, token, "Summer Cup", 0)
+	if err != nil {
+		return err
+	}
+
+	if err := h.Promote(userID); err != nil {
+		return err
+	}
+	admin, err := h.Login("ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	restorePath := fmt.Sprintf("/admin/restore/league/%d", int(leagueID))
+	resp, err = h.Expect(http.StatusConflict, "POST", restorePath, nil, admin)
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "name_taken"); err != nil {
+		return err
+	}
+	resp, err = h.Expect(http.StatusConflict, "POST", fmt.Sprintf("/admin/restore/team/%d", int(teamID)), nil, admin)
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "competition_deleted"); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusOK, "DELETE", fmt.Sprintf("/user/leagues/%d", int(clashID)), nil, admin); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusOK, "POST", restorePath, nil, admin); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusNotFound, "POST", restorePath, nil, admin); err != nil {
+		return err
+	}
+	_, err = h.Expect(http.StatusOK, "GET", teamPath, nil, token)
+	return err
+}
+
+func leagueListing(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	for i, game := range []string{"chess", "go", "chess", "go", "chess"} {
+		league := map[string]any{"name": fmt.Sprintf("Cup %d", i), "game": game, "prize_pool": 100 * (i % 3), "start_time": nextWeek()}
+		resp, err := h.Expect(http.StatusOK, "POST", "/user/leagues", league, token)
+		if err != nil {
+			return err
+		}
+		if i%2 == 0 {
+			leagueID := number(resp.Body, "data", "id")
+			if _, err := createTeam(h, token, "teams", "/user/league/team", fmt.Sprintf("Team %d", i), userID, "league_id", leagueID, 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Walk the leagues by descending prize pool, two at a time
+	var names []string
+	path := "/user/leagues?limit=2&sort=-prize_pool&fields=league_name,prize_pool"
+	for pages := 0; path != ""; pages++ {
+		if pages == 3 {
+			return fmt.Errorf("more than 3 pages of 5 leagues")
+		}
+		resp, err := h.Expect(http.StatusOK, "GET", path, nil, token)
+		if err != nil {
+			return err
+		}
+		leagues, _ := resp.Body["data"].([]any)
+		for _, league := range leagues {
+			league := league.(map[string]any)
+			if len(league) != 2 {
+				return fmt.Errorf("got fields %v, want league_name and prize_pool", league)
+			}
+			names = append(names, league["league_name"].(string))
+		}
+		path = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			path = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+		if (path == "") != (resp.Body["next_cursor"] == nil) {
+			return fmt.Errorf("got Link %q with next_cursor %v", path, resp.Body["next_cursor"])
+		}
+	}
+	if want := "Cup 2,Cup 4,Cup 1,Cup 3,Cup 0"; strings.Join(names, ",") != want {
+		return fmt.Errorf("got leagues %s, want %s", strings.Join(names, ","), want)
+	}
+
+	resp, err := h.Expect(http.StatusOK, "GET", "/user/leagues?offset=2&limit=2", nil, token)
+	if err != nil {
+		return err
+	}
+	if link := resp.Header.Get("Link"); !strings.Contains(link, "offset=4") {
+		return fmt.Errorf("got Link %q, want the offset of the next page", link)
+	}
+
+	filters := map[string]int{
+		"game=chess":                        3,
+		"game=chess&min_prize_pool=100":     2,
+		"max_prize_pool=100&joined=true":    2,
+		"status=started":                    0,
+		"starts_after=2000-01-01T00:00:00Z": 5,
+	}
+	for filter, want := range filters {
+		resp, err := h.Expect(http.StatusOK, "GET", "/user/leagues?"+filter, nil, token)
+		if err != nil {
+			return err
+		}
+		if leagues, _ := resp.Body["data"].([]any); len(leagues) != want {
+			return fmt.Errorf("%s: got %d leagues, want %d", filter, len(leagues), want)
+		}
+	}
+
+	invalid := map[string]string{
+		"limit=1000":                            "limit",
+		"offset=2&cursor=abc":                   "cursor",
+		"fields=league_name,owner":              "fields",
+		"sort=score":                            "sort",
+		"min_prize_pool=200&max_prize_pool=100": "max_prize_pool",
+	}
+	for query, field := range invalid {
+		resp, err := h.Expect(http.StatusBadRequest, "GET", "/user/leagues?"+query, nil, token)
+		if err != nil {
+			return err
+		}
+		if _, ok := fieldCodes(resp)[field]; !ok {
+			return fmt.Errorf("%s: got errors %v, want one for %s", query, resp.Body["errors"], field)
+		}
+	}
+	return nil
+}
+
+func leagueConcurrentEdits(h *Harness) error {
+	_, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	leagueID, err := createLeague(h, token, "Spring Cup", 1000)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/user/leagues/%d", int(leagueID))
+	resp, err := h.Expect(http.StatusOK, "GET", path, nil, token)
+	if err != nil {
+		return err
+	}
+	read := resp.Header.Get("ETag")
+	if read != `"1"` {
+		return fmt.Errorf("got ETag %s for a new league, want \"1\"", read)
+	}
+
+	steps := []struct {
+		method, ifMatch string
+		want            int
+	}{
+		{"PATCH", read, http.StatusOK},
+		{"PATCH", read, http.StatusPreconditionFailed}, // the other organizer's edit
+		{"PATCH", `W/"2"`, http.StatusPreconditionFailed},
+		{"DELETE", read, http.StatusPreconditionFailed},
+		{"PATCH", `"2"`, http.StatusOK},
+	}
+	for i, step := range steps {
+		resp, err = h.Do(step.method, path, map[string]any{"prize_pool": 100 * i}, token, "If-Match", step.ifMatch)
+		if err != nil {
+			return err
+		}
+		if resp.Status != step.want {
+			return fmt.Errorf("step %d: %s with If-Match %s: got status %d, want %d: %v", i, step.method, step.ifMatch, resp.Status, step.want, resp.Body)
+		}
+		if resp.Status == http.StatusPreconditionFailed {
+			if err := problemCode(resp, "version_mismatch"); err != nil {
+				return err
+			}
+		}
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"3"` {
+		return fmt.Errorf("got ETag %s after two edits, want \"3\"", etag)
+	}
+
+	// An edit that read version 2 but writes after another edit loses
+	if err := h.Repos.Leagues.Update(uint(leagueID), 2, map[string]any{"prize_pool": 1}); !errors.Is(err, repository.ErrVersionConflict) {
+		return fmt.Errorf("got %v for an update of an outdated version, want ErrVersionConflict", err)
+	}
+	_, err = h.Expect(http.StatusOK, "DELETE", path, nil, token)
+	return err
+}
+
+func leagueIdempotentRetries(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	_, otherToken, err := h.SignUp("Bob", "bob@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	league := map[string]any{"name": "Spring Cup", "start_time": nextWeek()}
+
+	// A retry gets the response to the first request instead of a conflict
+	var leagueID float64
+	for i := 0; i < 2; i++ {
+		resp, err := h.Do("POST", "/user/leagues", league, token, "Idempotency-Key", "create-spring-cup")
+		if err != nil {
+			return err
+		}
+		if resp.Status != http.StatusOK {
+			return fmt.Errorf("attempt %d: got status %d, want %d: %v", i, resp.Status, http.StatusOK, resp.Body)
+		}
+		replayed := resp.Header.Get("Idempotent-Replayed") == "true"
+		if replayed != (i > 0) {
+			return fmt.Errorf("attempt %d: replayed is %v", i, replayed)
+		}
+		if i == 0 {
+			leagueID = number(resp.Body, "data", "id")
+		} else if id := number(resp.Body, "data", "id"); id != leagueID {
+			return fmt.Errorf("retry returned league %v, want %v", id, leagueID)
+		}
+	}
+	if page, err := h.Repos.Leagues.List(repository.ListOptions{}); err != nil || len(page.Items) != 1 {
+		return fmt.Errorf("got %d leagues after a retried create, want 1 (%v)", len(page.Items), err)
+	}
+
+	// The same key for another request is refused, for another user it is not
+	league["name"] = "Summer Cup"
+	resp, err := h.Do("POST", "/user/leagues", league, token, "Idempotency-Key", "create-spring-cup")
+	if err != nil {
+		return err
+	}
+	if resp.Status != http.StatusUnprocessableEntity {
+		return fmt.Errorf("key reused with another body: got status %d, want %d", resp.Status, http.StatusUnprocessableEntity)
+	}
+	if err := problemCode(resp, "idempotency_key_reused"); err != nil {
+		return err
+	}
+	if resp, err = h.Do("POST", "/user/leagues", league, otherToken, "Idempotency-Key", "create-spring-cup"); err != nil {
+		return err
+	}
+	if resp.Status != http.StatusOK || resp.Header.Get("Idempotent-Replayed") != "" {
+		return fmt.Errorf("key of another user: got status %d, replayed %q", resp.Status, resp.Header.Get("Idempotent-Replayed"))
+	}
+
+	team := map[string]any{"name": "Rockets", "player_id": userID, "league_id": leagueID}
+	first, err := h.Do("POST", "/user/league/team", team, token, "Idempotency-Key", "team-rockets")
+	if err != nil {
+		return err
+	}
+	retry, err := h.Do("POST", "/user/league/team", team, token, "Idempotency-Key", "team-rockets")
+	if err != nil {
+		return err
+	}
+	if first.Status != http.StatusOK || retry.Status != http.StatusOK || number(first.Body, "data", "id") != number(retry.Body, "data", "id") {
+		return fmt.Errorf("team retry: got %d and %d: %v, %v", first.Status, retry.Status, first.Body, retry.Body)
+	}
+
+	resp, err = h.Do("POST", "/user/leagues/join", map[string]any{"league_id": leagueID}, token, "Idempotency-Key", "bad key")
+	if err != nil {
+		return err
+	}
+	if resp.Status != http.StatusBadRequest {
+		return fmt.Errorf("key with a space: got status %d, want %d", resp.Status, http.StatusBadRequest)
+	}
+	return problemCode(resp, "invalid_idempotency_key")
+}
+
+func tournamentTeamGuards(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	_, other, err := h.SignUp("Bob", "bob@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	tournamentID, err := createTournament(h, token, "Finals", 500)
+	if err != nil {
+		return err
+	}
+	teamID, err := createTeam(h, token, "team_as", "/user/tournament/teamA", "Reds", userID, "tournament_id", tournamentID, 3)
+	if err != nil {
+		return err
+	}
+	teamPath := fmt.Sprintf("/user/tournament/teamA/%d", int(teamID))
+
+	if _, err := h.Expect(http.StatusOK, "PATCH", teamPath, map[string]any{"name": "Crimsons"}, token); err != nil {
+		return err
+	}
+	if _, err := h.Expect(http.StatusForbidden, "DELETE", teamPath, nil, other); err != nil {
+		return err
+	}
+	if err := h.SetStartTime("tournaments", tournamentID, time.Now().Add(-time.Hour)); err != nil {
+		return err
+	}
+	for _, path := range []string{teamPath, fmt.Sprintf("/user/tournament/%d", int(tournamentID))} {
+		resp, err := h.Expect(http.StatusConflict, "DELETE", path, nil, token)
+		if err != nil {
+			return err
+		}
+		if err := problemCode(resp, "competition_started"); err != nil {
+			return err
+		}
+	}
+	resp, err := h.Expect(http.StatusOK, "GET", teamPath, nil, other)
+	if err != nil {
+		return err
+	}
+	if name, _ := resp.Body["data"].(map[string]any)["name"].(string); name != "Crimsons" {
+		return fmt.Errorf("got team name %q, want Crimsons", name)
+	}
+	return nil
+}
+
+func tournamentFlow(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	tournamentID, err := createTournament(h, token, "Finals", 500)
+	if err != nil {
+		return err
+	}
+	if _, err := createTeam(h, token, "team_as", "/user/tournament/teamA", "Reds", userID, "tournament_id", tournamentID, 3); err != nil {
+		return err
+	}
+	if _, err := createTeam(h, token, "team_bs", "/user/tournament/teamB", "Blues", userID, "tournament_id", tournamentID, 2); err != nil {
+		return err
+	}
+
+	resp, err := h.Expect(http.StatusOK, "GET", "/user/tournament", nil, token)
+	if err != nil {
+		return err
+	}
+	if tournaments, _ := resp.Body["data"].([]any); len(tournaments) != 1 {
+		return fmt.Errorf("got %d tournaments, want 1", len(tournaments))
+	}
+	_, err = h.Expect(http.StatusOK, "POST", "/user/tournament/join", map[string]any{"tournament_id": tournamentID}, token)
+	return err
+}
+
+func leagueResult(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	leagueID, err := createLeague(h, token, "Spring Cup", 1000)
+	if err != nil {
+		return err
+	}
+	if _, err := createTeam(h, token, "teams", "/user/league/team", "Rockets", userID, "league_id", leagueID, 10); err != nil {
+		return err
+	}
+	winnerID, err := createTeam(h, token, "teams", "/user/league/team", "Comets", userID, "league_id", leagueID, 25)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.Expect(http.StatusOK, "GET", "/user/leagues/price", nil, token)
+	if err != nil {
+		return err
+	}
+	leagues, _ := resp.Body["data"].([]any)
+	if len(leagues) != 1 {
+		return fmt.Errorf("got %d leagues, want 1", len(leagues))
+	}
+	league, _ := leagues[0].(map[string]any)
+	if id := number(league, "prize_distribution", "winning_team_id"); id != winnerID {
+		return fmt.Errorf("winning team is %v, want %v", id, winnerID)
+	}
+	if prize := number(league, "prize_distribution", "prize"); prize != 1000 {
+		return fmt.Errorf("prize is %v, want 1000", prize)
+	}
+	_, err = h.Expect(http.StatusOK, "GET", "/user/leagues/result", nil, token)
+	return err
+}
+
+func tournamentResult(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	tournamentID, err := createTournament(h, token, "Finals", 500)
+	if err != nil {
+		return err
+	}
+	if _, err := createTeam(h, token, "team_as", "/user/tournament/teamA", "Reds", userID, "tournament_id", tournamentID, 3); err != nil {
+		return err
+	}
+	winnerID, err := createTeam(h, token, "team_bs", "/user/tournament/teamB", "Blues", userID, "tournament_id", tournamentID, 7)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.Expect(http.StatusOK, "GET", "/user/tournament/result", nil, token)
+	if err != nil {
+		return err
+	}
+	tournaments, _ := resp.Body["data"].([]any)
+	if len(tournaments) != 1 {
+		return fmt.Errorf("got %d tournaments, want 1", len(tournaments))
+	}
+	if id := number(tournaments[0].(map[string]any), "winning_team", "team_id"); id != winnerID {
+		return fmt.Errorf("winning team is %v, want %v", id, winnerID)
+	}
+	_, err = h.Expect(http.StatusOK, "GET", "/user/tournament/price", nil, token)
+	return err
+}
+
+func reportScores(h *Harness) error {
+	userID, token, err := h.SignUp("Ada", "ada@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	captainID, captainToken, err := h.SignUp("Bob", "bob@example.com", "correct horse")
+	if err != nil {
+		return err
+	}
+	leagueID, err := createLeague(h, token, "Spring Cup", 1000)
+	if err != nil {
+		return err
+	}
+	rocketsID, err := createTeam(h, token, "teams", "/user/league/team", "Rockets", userID, "league_id", leagueID, 0)
+	if err != nil {
+		return err
+	}
+	cometsID, err := createTeam(h, token, "teams", "/user/league/team", "Comets", captainID, "league_id", leagueID, 0)
+	if err != nil {
+		return err
+	}
+	comets := fmt.Sprintf("/user/league/team/%v/score", cometsID)
+
+	report := func(path, token, ifMatch string, score float64) (Response, error) {
+		return h.Do("PUT", path, map[string]any{"score": score}, token, "If-Match", ifMatch)
+	}
+	resp, err := report(comets, captainToken, `"1"`, 25)
+	if err != nil {
+		return err
+	}
+	if err := problemCode(resp, "competition_not_started"); err != nil {
+		return err
+	}
+	if err := h.SetStartTime("leagues", leagueID, time.Now().Add(-time.Hour)); err != nil {
+		return err
+	}
+
+	steps := []struct {
+		path, token, ifMatch string
+		score                float64
+		want                 int
+		code                 string
+	}{
+		// Reports must be based on a read of the team
+		{comets, captainToken, "", 25, http.StatusPreconditionRequired, "version_required"},
+		{comets, captainToken, `"1"`, 25, http.StatusOK, ""},
+		// A second report based on the same read does not replace the first
+		{comets, token, `"1"`, 20, http.StatusPreconditionFailed, "version_mismatch"},
+		{fmt.Sprintf("/user/league/team/%v/score", rocketsID), captainToken, `"1"`, 99, http.StatusForbidden, "not_owner"},
+		// The creator of the league overrides the reported score
+		{comets, token, `"2"`, 30, http.StatusOK, ""},
+	}
+	for i, step := range steps {
+		resp, err := report(step.path, step.token, step.ifMatch, step.score)
+		if err != nil {
+			return err
+		}
+		if resp.Status != step.want {
+			return fmt.Errorf("step %d: got status %d, want %d: %v", i, resp.Status, step.want, resp.Body)
+		}
+		if step.code != "" {
+			if err := problemCode(resp, step.code); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+	}
+	for _, actor := range []struct{ token, action string }{{captainToken, "result.reported"}, {token, "result.overridden"}} {
+		resp, err := h.Expect(http.StatusOK, "GET", "/user/audit?action="+actor.action, nil, actor.token)
+		if err != nil {
+			return err
+		}
+		if events, _ := resp.Body["data"].([]any); len(events) != 1 {
+			return fmt.Errorf("got %d %s audit events, want 1", len(events), actor.action)
+		}
+	}
+
+	resp, err = h.Expect(http.StatusOK, "GET", "/user/leagues/price", nil, token)
+	if err != nil {
+		return err
+	}
+	leagues, _ := resp.Body["data"].([]any)
+	if len(leagues) != 1 {
+		return fmt.Errorf("got %d leagues, want 1", len(leagues))
+	}
+	league, _ := leagues[0].(map[string]any)
+	if id := number(league, "prize_distribution", "winning_team_id"); id != cometsID {
+		return fmt.Errorf("winning team is %v, want %v", id, cometsID)
+	}
+
+	// A bot reports with an API key of the captain that has the results:submit scope
+	for _, scope := range []string{"read", "results:submit"} {
+		resp, err := h.Expect(http.StatusOK, "POST", "/user/api-keys", map[string]any{"name": "bot " + scope, "scopes": []string{scope}}, captainToken)
+		if err != nil {
+			return err
+		}
+		key, _ := resp.Body["data"].(map[string]any)["key"].(string)
+		want := http.StatusForbidden
+		if scope == "results:submit" {
+			want = http.StatusOK
+		}
+		resp, err = h.Do("PUT", comets, map[string]any{"score": 40}, "", "X-API-Key", key, "If-Match", `"3"`)
+		if err != nil {
+			return err
+		}
+		if resp.Status != want {
+			return fmt.Errorf("report with a %s key: got %d, want %d: %v", scope, resp.Status, want, resp.Body)
+		}
+	}
+	return nil
+}
+
+// createLeague creates a league and returns its ID.
+func createLeague(h *Harness, token, name string, prizePool float64) (float64, error) {
+	league := map[string]any{"name": name, "prize_pool": prizePool, "start_time": nextWeek()}
+	resp, err := h.Expect(http.StatusOK, "POST", "/user/leagues", league, token)
+	if err != nil {
+		return 0, err
+	}
+	return number(resp.Body, "data", "id"), nil
+}
+
+// createTournament creates a tournament and returns its ID.
+func createTournament(h *Harness, token, name string, prizePool float64) (float64, error) {
+	tournament := map[string]any{"name": name, "prize_pool": prizePool, "start_time": nextWeek()}
+	resp, err := h.Expect(http.StatusOK, "POST", "/user/tournament", tournament, token)
+	if err != nil {
+		return 0, err
+	}
+	return number(resp.Body, "data", "id"), nil
+}
+
+// createTeam creates a team on path, in the competition given by the parent
+// field, gives it a score in table and returns its ID.
+func createTeam(h *Harness, token, table, path, name string, playerID uint, parent string, parentID, score float64) (float64, error) {
+	team := map[string]any{"name": name, "player_id": playerID, parent: parentID}
+	resp, err := h.Expect(http.StatusOK, "POST", path, team, token)
+	if err != nil {
+		return 0, err
+	}
+	id := number(resp.Body, "data", "id")
+	return id, h.SetScore(table, id, score)
+}
+
+// number returns the number at the path of keys in a decoded JSON object,
+// or -1 if there is none.
+func number(body map[string]any, path ...string) float64 {
+	var value any = body
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return -1
+		}
+		value = object[key]
+	}
+	n, ok := value.(float64)
+	if !ok {
+		return -1
+	}
+	return n
+}
+
+// problemCode checks that the response is a problem with the given code.
+func problemCode(resp Response, code string) error {
+	if got, _ := resp.Body["code"].(string); got != code {
+		return fmt.Errorf("got problem code %q, want %q", got, code)
+	}
+	return nil
+}
+
+// fieldCodes returns the code of each field error of a problem.
+func fieldCodes(resp Response) map[string]string {
+	codes := map[string]string{}
+	problems, _ := resp.Body["errors"].([]any)
+	for _, problem := range problems {
+		field, _ := problem.(map[string]any)
+		name, _ := field["field"].(string)
+		code, _ := field["code"].(string)
+		codes[name] = code
+	}
+	return codes
+}
+
+// equalCodes reports whether two sets of field codes are the same.
+func equalCodes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for field, code := range a {
+		if b[field] != code {
+			return false
+		}
+	}
+	return true
+}
+
+// nextWeek is a start time that passes the future rule.
+func nextWeek() time.Time {
+	return time.Now().Add(7 * 24 * time.Hour)
+}
@@ -0,0 +1,250 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gaming/config"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a single signing or verification key identified by its kid.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+}
+
+// KeySet holds the key used to sign new tokens and every key that is still
+// accepted for verification. Keeping the previous keys in the set lets keys be
+// rotated without invalidating tokens that were issued before the rotation.
+type KeySet struct {
+	mu         sync.RWMutex
+	signingKID string
+	private    crypto.PrivateKey
+	keys       map[string]Key
+}
+
+// Keys is the key set used by JwtToken and AuthMiddleware.
+var Keys = &KeySet{keys: make(map[string]Key)}
+
+// LoadKeys loads the signing key, a PEM encoded RSA or Ed25519 private key,
+// and the extra verification keys, PEM public keys listed as
+// "kid=path,kid=path". It also sets the token lifetimes.
+//
+// When no signing key is configured an ephemeral Ed25519 key is generated, which
+// is only suitable for local development.
+func LoadKeys(cfg config.JWT) error {
+	kid := cfg.SigningKeyID
+	path := cfg.SigningKeyFile
+
+	var private crypto.PrivateKey
+	if path == "" {
+		slog.Warn("JWT_SIGNING_KEY_FILE not set, using an ephemeral Ed25519 key")
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		private = key
+		if kid == "" {
+			kid = "ephemeral"
+		}
+	} else {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read signing key: %w", err)
+		}
+		private, err = ParsePrivateKey(pemBytes)
+		if err != nil {
+			return fmt.Errorf("parse signing key: %w", err)
+		}
+		if kid == "" {
+			return errors.New("JWT_SIGNING_KEY_ID is required with JWT_SIGNING_KEY_FILE")
+		}
+	}
+
+	set := &KeySet{keys: make(map[string]Key)}
+	if err := set.SetSigningKey(kid, private); err != nil {
+		return err
+	}
+
+	for _, entry := range strings.Split(cfg.VerifyKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, file, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid JWT_VERIFY_KEYS entry %q", entry)
+		}
+		pemBytes, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read verification key %s: %w", id, err)
+		}
+		public, err := ParsePublicKey(pemBytes)
+		if err != nil {
+			return fmt.Errorf("parse verification key %s: %w", id, err)
+		}
+		if err := set.AddVerificationKey(id, public); err != nil {
+			return err
+		}
+	}
+
+	Keys.replace(set)
+	TokenTTL, MFATokenTTL = cfg.TokenTTL, cfg.MFATokenTTL
+	return nil
+}
+
+// SetSigningKey makes the private key the one used to sign new tokens.
+// Its public half is added to the verification keys.
+func (s *KeySet) SetSigningKey(kid string, private crypto.PrivateKey) error {
+	var public crypto.PublicKey
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		public = &key.PublicKey
+	case ed25519.PrivateKey:
+		public = key.Public()
+	default:
+		return fmt.Errorf("unsupported signing key type %T", private)
+	}
+	if err := s.AddVerificationKey(kid, public); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signingKID = kid
+	s.private = private
+	return nil
+}
+
+// AddVerificationKey adds a public key that is accepted when verifying tokens.
+func (s *KeySet) AddVerificationKey(kid string, public crypto.PublicKey) error {
+	method, err := methodFor(public)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = Key{ID: kid, Method: method, Public: public}
+	return nil
+}
+
+// Sign signs the claims with the current signing key and sets the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.private == nil {
+		return "", errors.New("no signing key loaded")
+	}
+	token := jwt.NewWithClaims(s.keys[s.signingKID].Method, claims)
+	token.Header["kid"] = s.signingKID
+	return token.SignedString(s.private)
+}
+
+// Keyfunc resolves the verification key of a token from its kid header.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	// Reject tokens whose alg does not match the key, e.g. HS256 signed with a public key
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// JWKS returns the verification keys as a JSON Web Key Set.
+func (s *KeySet) JWKS() []gin.H {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var jwks []gin.H
+	for _, key := range s.keys {
+		jwk := gin.H{
+			"kid": key.ID,
+			"alg": key.Method.Alg(),
+			"use": "sig",
+		}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// replace swaps in the keys of another set.
+func (s *KeySet) replace(other *KeySet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signingKID = other.signingKID
+	s.private = other.private
+	s.keys = other.keys
+}
+
+// JWKSHandler serves the verification keys at /.well-known/jwks.json so other
+// services can verify tokens issued by this one.
+func JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": Keys.JWKS()})
+}
+
+// methodFor returns the signing method matching a public key type.
+func methodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS#8 or PKCS#1 private key.
+func ParsePrivateKey(pemBytes []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// ParsePublicKey parses a PEM encoded PKIX or PKCS#1 public key.
+func ParsePublicKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
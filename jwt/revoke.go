@@ -0,0 +1,35 @@
+package jwt
+
+import (
+	"log/slog"
+	"time"
+)
+
+// IsRevoked reports whether the token with the given jti has been revoked.
+// Tokens without a jti were issued before sessions existed and are treated as revoked.
+func (a *Auth) IsRevoked(jti string) bool {
+	if jti == "" {
+		return true
+	}
+	revoked, err := a.Sessions.IsRevoked(jti)
+	if err != nil {
+		slog.Error("failed to check revoked token", "error", err)
+		return true
+	}
+	return revoked
+}
+
+// StartCleanup removes the sessions and revocations of expired tokens
+// periodically in the background, since an expired token is rejected
+// regardless of the revocation list.
+func (a *Auth) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := a.Sessions.DeleteExpired(time.Now()); err != nil {
+				slog.Error("failed to clean up expired tokens", "error", err)
+			}
+		}
+	}()
+}
@@ -3,22 +3,28 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"gaming/apperr"
 	"gaming/model"
+	"gaming/repository"
 	"net/http"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
 )
 
-// SecretKey is used to sign and verify the JWT tokens.
-var SecretKey = []byte("qwertuiouplkhgfdsazxcvbnm")
-
 // Userdetails holds the information of the authenticated user.
 var Userdetails model.User
 
-// BlacklistedToken keeps track of revoked tokens.
-var BlacklistedToken = make(map[string]bool)
+// RoleAdmin is the role of administrators. Admin tokens are accepted on
+// every route, whatever role the route requires.
+const RoleAdmin = "admin"
+
+// TokenTTL is how long an issued token stays valid. LoadKeys sets it from the config.
+var TokenTTL = time.Hour * 2
 
 // Claims represents the structure of the JWT claims.
 type Claims struct {
@@ -28,59 +34,160 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
+// Auth issues tokens and authenticates requests, keeping a session for every
+// token and looking up API keys in its repositories.
+type Auth struct {
+	Sessions repository.SessionRepo
+	APIKeys  repository.APIKeyRepo
+}
+
+// NewAuth returns an Auth using the given repositories.
+func NewAuth(sessions repository.SessionRepo, apiKeys repository.APIKeyRepo) *Auth {
+	return &Auth{Sessions: sessions, APIKeys: apiKeys}
+}
+
 // JwtToken generates a new JWT token for a user with the given ID, email, and role.
 // It sends the signed token as a JSON response.
-func JwtToken(c *gin.Context, id uint, email string, role string) {
+// A session row is stored for every token so that it can later be listed and revoked.
+func (a *Auth) JwtToken(c *gin.Context, id uint, email string, role string) {
+	jti, err := newTokenID()
+	if err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to generate token id").Wrap(err))
+		return
+	}
+	expiresAt := time.Now().Add(TokenTTL)
 	claims := Claims{
 		ID:    id,
 		Email: email,
 		Role:  role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 2).Unix(), // Token expiration time
+			Id:        jti,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(), // Token expiration time
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(SecretKey)
+	signedToken, err := Keys.Sign(claims)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Failed to sign token"})
+		apperr.Abort(c, apperr.Internal("internal", "failed to sign token").Wrap(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"Token": signedToken})
+	// Record the session with the device and IP that requested it
+	session := model.Session{
+		JTI:       jti,
+		UserID:    id,
+		Device:    c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+		ExpiresAt: expiresAt,
+	}
+	if err := a.Sessions.Create(&session); err != nil {
+		apperr.Abort(c, apperr.Internal("internal", "failed to create session").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "token": signedToken})
 }
 
-// AuthMiddleware is a middleware that checks if the JWT token is valid
-func AuthMiddleware(requiredRole string) gin.HandlerFunc {
+// AuthMiddleware is a middleware that checks if the JWT token is valid.
+// Instead of a token, an API key can be sent in the X-API-Key header; API keys
+// act with the user role and are limited by their scopes, see AllowScopes.
+func (a *Auth) AuthMiddleware(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenstring := c.GetHeader("Authorization")
-		if tokenstring == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"Error": "Token not provided"})
-			c.Abort()
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			apiKey, err := a.authenticateAPIKey(key)
+			if err != nil {
+				apperr.Abort(c, apperr.Unauthorized("api_key_invalid", "invalid api key"))
+				return
+			}
+			if requiredRole != "user" || !apiKeyAllowed(c, apiKey) {
+				apperr.Abort(c, apperr.Forbidden("api_key_scope", "api key scope does not allow this request"))
+				return
+			}
+			c.Set("userid", apiKey.UserID) // Store user ID in the context for further processing
+			c.Set("apikeyid", apiKey.ID)   // Mark the request as made with an API key
+			c.Next()
 			return
 		}
-		if BlacklistedToken[tokenstring] {
-			c.JSON(http.StatusBadRequest, gin.H{"Error": "Token removed"})
-			c.Abort()
+
+		tokenstring := c.GetHeader("Authorization")
+		if tokenstring == "" {
+			apperr.Abort(c, apperr.Unauthorized("token_missing", "token not provided"))
 			return
 		}
-
 		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenstring, claims, func(token *jwt.Token) (interface{}, error) {
-			return SecretKey, nil
-		})
+		token, err := jwt.ParseWithClaims(tokenstring, claims, Keys.Keyfunc)
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid token"})
-			c.Abort()
+			apperr.Abort(c, apperr.Unauthorized("token_invalid", "invalid token"))
+			return
+		}
+		if a.IsRevoked(claims.Id) {
+			apperr.Abort(c, apperr.Unauthorized("token_revoked", "token has been revoked"))
 			return
 		}
-		if claims.Role != requiredRole {
-			c.JSON(http.StatusBadRequest, gin.H{"Error": "No permission"})
-			c.Abort()
+		if claims.Role != requiredRole && claims.Role != RoleAdmin {
+			apperr.Abort(c, apperr.Forbidden("forbidden", "no permission"))
 			return
 		}
 
 		c.Set("userid", claims.ID) // Store user ID in the context for further processing
+		c.Set("jti", claims.Id)    // Store token ID so the session can be revoked
+		c.Set("role", claims.Role) // Store the role for checks within handlers, see IsAdmin
 		c.Next()                   // Proceed to the next handler
 	}
 }
+
+// IsAdmin reports whether the request was authenticated with an admin
+// token. Requests made with an API key never are.
+func IsAdmin(c *gin.Context) bool {
+	return c.GetString("role") == RoleAdmin
+}
+
+// newTokenID returns a random identifier used as the jti claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RoleMFAPending is the role of a partial token issued after the password
+// check, while the second factor is still missing. AuthMiddleware never
+// accepts it for a protected route.
+const RoleMFAPending = "mfa_pending"
+
+// MFATokenTTL is how long the user has to enter the second factor.
+var MFATokenTTL = 5 * time.Minute
+
+// MFAToken returns a partial token for a user who still has to pass the second factor.
+func MFAToken(id uint, email string) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	claims := Claims{
+		ID:    id,
+		Email: email,
+		Role:  RoleMFAPending,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(MFATokenTTL).Unix(),
+		},
+	}
+	return Keys.Sign(claims)
+}
+
+// ParseMFAToken validates a partial token issued by MFAToken.
+func ParseMFAToken(tokenstring string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenstring, claims, Keys.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid mfa token")
+	}
+	if claims.Role != RoleMFAPending {
+		return nil, errors.New("not an mfa token")
+	}
+	return claims, nil
+}
@@ -0,0 +1,115 @@
+package jwt
+
+import (
+	"encoding/json"
+	"gaming/config"
+	"gaming/model"
+	"gaming/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestAuth returns an Auth on in-memory repositories and a router that
+// issues tokens on POST /login and answers GET and POST /private with the
+// user ID when the request is authenticated for the user role.
+func newTestAuth(t *testing.T) (*Auth, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := LoadKeys(config.JWT{TokenTTL: time.Hour, MFATokenTTL: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	repos := repository.NewMemory()
+	auth := NewAuth(repos.Sessions, repos.APIKeys)
+
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) { auth.JwtToken(c, 7, "player@example.com", "user") })
+	private := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"userid": c.GetUint("userid")}) }
+	r.GET("/private", auth.AuthMiddleware("user"), private)
+	r.POST("/private", auth.AuthMiddleware("user"), private)
+	r.PUT("/results", AllowScopes(ScopeResultsSubmit), auth.AuthMiddleware("user"), private)
+	return auth, r
+}
+
+func serve(r http.Handler, method, path string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTokenRevocation(t *testing.T) {
+	auth, r := newTestAuth(t)
+
+	rec := serve(r, "POST", "/login")
+	var body struct{ Token string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Token == "" {
+		t.Fatalf("login returned %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(r, "GET", "/private", "Authorization", body.Token); rec.Code != http.StatusOK {
+		t.Fatalf("valid token: got %d %s", rec.Code, rec.Body)
+	}
+
+	sessions, err := auth.Sessions.Active(7, time.Now())
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("Active = %+v, %v, want the session of the token", sessions, err)
+	}
+	if err := auth.Sessions.Revoke(sessions[0]); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(r, "GET", "/private", "Authorization", body.Token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: got %d, want 401", rec.Code)
+	}
+	if rec := serve(r, "GET", "/private"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: got %d, want 401", rec.Code)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	auth, r := newTestAuth(t)
+
+	newKey := func(scopes string) string {
+		key, prefix, hash, err := GenerateAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.APIKeys.Create(&model.APIKey{UserID: 7, Name: scopes, Prefix: prefix, KeyHash: hash, Scopes: scopes}); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	read, submit, none := newKey(ScopeRead), newKey(ScopeResultsSubmit), newKey("")
+
+	tests := []struct {
+		method, path, key string
+		want              int
+	}{
+		{"GET", "/private", read, http.StatusOK},
+		{"POST", "/private", read, http.StatusForbidden},
+		{"GET", "/private", submit, http.StatusForbidden},
+		{"POST", "/private", submit, http.StatusForbidden},
+		{"PUT", "/results", submit, http.StatusOK},
+		{"PUT", "/results", read, http.StatusForbidden},
+		{"GET", "/private", none, http.StatusForbidden},
+		{"GET", "/private", read + "x", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if rec := serve(r, tt.method, tt.path, "X-API-Key", tt.key); rec.Code != tt.want {
+			t.Errorf("%s %s with a %q key: got %d, want %d", tt.method, tt.path, tt.key[:11], rec.Code, tt.want)
+		}
+	}
+
+	keys, _ := auth.APIKeys.List(7)
+	if err := auth.APIKeys.Revoke(7, keys[len(keys)-1].ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(r, "GET", "/private", "X-API-Key", read); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: got %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,210 @@
+// Package listing parses the query of the competition list endpoints and
+// writes their pages.
+//
+// Lists are paged either by offset, with limit and offset, or by cursor,
+// with limit and the next_cursor of the previous page. Cursors stay correct
+// while rows are added and are the way to walk a long list; offsets are
+// bounded by MaxOffset. Every page carries a next_cursor, null on the last
+// page, and a Link header to the next page in the same paging mode.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"gaming/apperr"
+	"gaming/repository"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxOffset is the largest offset accepted, as in the binding tag of
+// Request.Offset, so that no query skips more rows.
+const MaxOffset = 10000
+
+// Request is the query accepted by the list endpoints. Limits above
+// repository.MaxLimit are rejected rather than capped so that clients notice.
+type Request struct {
+	Limit        int       `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset       int       `form:"offset" binding:"omitempty,min=0,max=10000"`
+	Cursor       string    `form:"cursor"`
+	Sort         string    `form:"sort" binding:"omitempty,oneof=id -id name -name prize_pool -prize_pool start_time -start_time"`
+	Status       string    `form:"status" binding:"omitempty,oneof=upcoming started"`
+	StartsAfter  time.Time `form:"starts_after"`
+	StartsBefore time.Time `form:"starts_before"`
+	MinPrizePool *float64  `form:"min_prize_pool" binding:"omitempty,min=0"`
+	MaxPrizePool *float64  `form:"max_prize_pool" binding:"omitempty,min=0"`
+	Game         string    `form:"game" binding:"omitempty,max=50"`
+	Joined       bool      `form:"joined"` // only competitions where the caller has a team
+	Fields       string    `form:"fields"` // comma separated, all when empty
+}
+
+// Query is a parsed list request.
+type Query struct {
+	Options repository.ListOptions
+	sort    string          // as requested, e.g. "-prize_pool", to tie cursors to it
+	fields  map[string]bool // nil for all fields
+	offset  bool            // paged by offset rather than cursor
+}
+
+// cursor is the encoded form of a repository.Cursor.
+type cursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v,omitempty"`
+	ID    uint            `json:"id"`
+}
+
+// Parse binds the list query of the request. fields are the fields of a
+// listed item that the client may select. It aborts with a problem and
+// returns false when the query is invalid.
+func Parse(c *gin.Context, fields []string) (Query, bool) {
+	var req Request
+	if err := c.ShouldBindQuery(&req); err != nil {
+		apperr.Abort(c, apperr.Invalid(err))
+		return Query{}, false
+	}
+
+	var problems []apperr.FieldError
+	if req.Cursor != "" && req.Offset > 0 {
+		problems = append(problems, apperr.FieldRule("cursor", "excluded", "offset"))
+	}
+	if req.MinPrizePool != nil && req.MaxPrizePool != nil && *req.MaxPrizePool < *req.MinPrizePool {
+		problems = append(problems, apperr.FieldRule("max_prize_pool", "min", "min_prize_pool"))
+	}
+	if !req.StartsAfter.IsZero() && !req.StartsBefore.IsZero() && !req.StartsBefore.After(req.StartsAfter) {
+		problems = append(problems, apperr.FieldRule("starts_before", "gt", "starts_after"))
+	}
+
+	query := Query{
+		Options: repository.ListOptions{
+			Status:       req.Status,
+			Now:          time.Now(),
+			StartsAfter:  req.StartsAfter,
+			StartsBefore: req.StartsBefore,
+			MinPrizePool: req.MinPrizePool,
+			MaxPrizePool: req.MaxPrizePool,
+			Game:         req.Game,
+			Limit:        req.Limit,
+			Offset:       req.Offset,
+		},
+		sort:   req.Sort,
+		offset: req.Offset > 0,
+	}
+	query.Options.Sort, query.Options.Desc = strings.TrimPrefix(req.Sort, "-"), strings.HasPrefix(req.Sort, "-")
+	if req.Joined {
+		query.Options.PlayerID = c.GetUint("userid")
+	}
+	if req.Cursor != "" {
+		after, ok := decodeCursor(req.Cursor, req.Sort)
+		if !ok {
+			problems = append(problems, apperr.FieldRule("cursor", "invalid", ""))
+		}
+		query.Options.After = after
+	}
+	if req.Fields != "" {
+		query.fields = map[string]bool{}
+		for _, field := range strings.Split(req.Fields, ",") {
+			field = strings.TrimSpace(field)
+			if !slices.Contains(fields, field) {
+				problems = append(problems, apperr.FieldRule("fields", "oneof", strings.Join(fields, " ")))
+				break
+			}
+			query.fields[field] = true
+		}
+	}
+
+	if len(problems) > 0 {
+		apperr.Abort(c, apperr.Validation("invalid_request", "the request has invalid fields", problems...))
+		return Query{}, false
+	}
+	return query, true
+}
+
+// Wants reports whether the client asked for the field of the listed items.
+func (q Query) Wants(field string) bool {
+	return q.fields == nil || q.fields[field]
+}
+
+// Select drops the fields that the client did not ask for from an item.
+func (q Query) Select(item gin.H) gin.H {
+	if q.fields == nil {
+		return item
+	}
+	for field := range item {
+		if !q.fields[field] {
+			delete(item, field)
+		}
+	}
+	return item
+}
+
+// Respond writes a page of items, with the cursor of the next page in the
+// body and its URL in a Link header.
+func Respond(c *gin.Context, q Query, message string, items []gin.H, next *repository.Cursor) {
+	var nextCursor any
+	if next != nil {
+		encoded := encodeCursor(*next, q.sort)
+		nextCursor = encoded
+
+		link := c.Request.URL.Query()
+		if q.offset {
+			limit := q.Options.Limit
+			if limit <= 0 {
+				limit = repository.DefaultLimit
+			}
+			link.Set("offset", strconv.Itoa(q.Options.Offset+limit))
+		} else {
+			link.Set("cursor", encoded)
+		}
+		c.Header("Link", "<"+c.Request.URL.Path+"?"+link.Encode()+`>; rel="next"`)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      http.StatusOK,
+		"message":     message,
+		"data":        items,
+		"next_cursor": nextCursor,
+	})
+}
+
+// encodeCursor encodes the position of a row in the sort order, which it
+// names so that the cursor cannot be used with another order.
+func encodeCursor(at repository.Cursor, sort string) string {
+	value, _ := json.Marshal(at.Value)
+	raw, _ := json.Marshal(cursor{Sort: sort, Value: value, ID: at.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor decodes a cursor of encodeCursor for the sort order.
+func decodeCursor(encoded, sort string) (*repository.Cursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	var decoded cursor
+	if err := json.Unmarshal(raw, &decoded); err != nil || decoded.Sort != sort {
+		return nil, false
+	}
+
+	at := &repository.Cursor{ID: decoded.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case repository.SortName:
+		at.Value, err = decodeValue[string](decoded.Value)
+	case repository.SortPrizePool:
+		at.Value, err = decodeValue[float64](decoded.Value)
+	case repository.SortStartTime:
+		at.Value, err = decodeValue[time.Time](decoded.Value)
+	}
+	return at, err == nil
+}
+
+// decodeValue decodes the sort value of a cursor.
+func decodeValue[T any](raw json.RawMessage) (T, error) {
+	var value T
+	err := json.Unmarshal(raw, &value)
+	return value, err
+}
@@ -0,0 +1,76 @@
+// Package logging sets up the structured application log.
+//
+// Records are written with log/slog, as JSON by default, from the level set
+// in the config. Secrets and personal data are redacted before a record is
+// written, see Redact. The standard log package writes through the same
+// handler, so nothing bypasses the redaction.
+//
+// Middleware gives every request an ID, taken from its X-Request-ID header or
+// generated, and a logger that adds the ID to every record. Handlers get it
+// with For, other code that is passed the request context with FromContext.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"gaming/config"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextKey is the context key of the request logger.
+type contextKey struct{}
+
+// Configure makes a logger writing to w with the level and format of the
+// config the default slog logger.
+func Configure(cfg config.Log, w io.Writer) error {
+	handler, err := NewHandler(cfg, w)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// NewHandler returns a handler that writes the records of the configured
+// level and above to w, redacted.
+func NewHandler(cfg config.Log, w io.Writer) (slog.Handler, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: Redact}
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		return slog.NewJSONHandler(w, opts), nil
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+}
+
+// WithLogger returns a copy of ctx that carries the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// For returns the logger of the request, with the authenticated user if any.
+func For(c *gin.Context) *slog.Logger {
+	logger := FromContext(c.Request.Context())
+	if id := c.GetUint("userid"); id != 0 {
+		logger = logger.With("user_id", id)
+	}
+	return logger
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces the value of a secret.
+const redacted = "[redacted]"
+
+// secretKeys are attribute and JSON field names whose values are never
+// logged, as are names ending in _password, _secret or _token.
+var secretKeys = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"otp":           true,
+	"authorization": true,
+	"cookie":        true,
+	"api_key":       true,
+	"key_hash":      true,
+	"code_hash":     true,
+	"salt":          true,
+}
+
+// Patterns of secrets and personal data found inside text.
+var (
+	emailPattern  = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,})`)
+	phonePattern  = regexp.MustCompile(`\+[1-9]\d{4,12}(\d{2})\b`)
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`)
+	jwtPattern    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	apiKeyPattern = regexp.MustCompile(`\b(gk_[0-9a-f]{8})_[0-9a-f]+`)
+)
+
+// Redact is the ReplaceAttr function of the handlers. It replaces the values
+// of secret attributes, and masks email addresses, phone numbers, bearer
+// tokens, JWTs and API keys in the message and in every other value. Values
+// that are neither strings nor errors are redacted field by field in their
+// JSON form, so that e.g. a logged user never shows its password hash.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.SourceKey) {
+		return a
+	}
+	if isSecret(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Scrub(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Scrub(v.Error()))
+		case []byte:
+			return slog.String(a.Key, Scrub(string(v)))
+		default:
+			return slog.Any(a.Key, redactJSON(v))
+		}
+	}
+	return a
+}
+
+// Scrub masks the email addresses, phone numbers, bearer tokens, JWTs and
+// API keys in s, e.g. "ada@example.com" becomes "a***@example.com".
+func Scrub(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = apiKeyPattern.ReplaceAllString(s, "${1}_"+redacted)
+	s = emailPattern.ReplaceAllString(s, "${1}***@${2}")
+	return phonePattern.ReplaceAllString(s, "+***${1}")
+}
+
+// isSecret reports whether the values of a name are secret.
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	return secretKeys[key] ||
+		strings.HasSuffix(key, "_password") ||
+		strings.HasSuffix(key, "_secret") ||
+		strings.HasSuffix(key, "_token")
+}
+
+// redactJSON returns the JSON form of v with the secret fields replaced and
+// the text scrubbed, or v itself when it does not marshal to an object or list.
+func redactJSON(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return v
+	}
+	return redactValue(decoded)
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if isSecret(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+		return v
+	case string:
+		return Scrub(v)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,179 @@
+// Package loginguard protects the login against password guessing.
+//
+// Failed attempts are counted per account and per client IP. Every failure
+// pushes the next allowed attempt further out (a progressive delay), and once
+// too many failures pile up the account or IP is locked for a while.
+package loginguard
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned by Check while an account or IP is locked.
+var ErrLocked = errors.New("too many failed login attempts")
+
+// ThrottledError is returned by Check while the progressive delay is running,
+// and wraps ErrLocked when the lock is a full lockout.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return ErrLocked.Error()
+	}
+	return "login attempted too soon after a failure"
+}
+
+func (e *ThrottledError) Unwrap() error {
+	if e.Locked {
+		return ErrLocked
+	}
+	return nil
+}
+
+// Record is the failure state of one key.
+type Record struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	NextAttemptAt time.Time
+	LockedUntil   time.Time
+}
+
+// Store keeps the failure records.
+type Store interface {
+	// Get returns the record of the key, or a zero record with the key set.
+	Get(key string) (Record, error)
+	// Update stores the record fn returns for the record of the key, as Get
+	// would return it. No other Update of the key runs in between, so that
+	// concurrent failures are all counted.
+	Update(key string, fn func(r Record) Record) error
+	// Delete removes the record of the key.
+	Delete(key string) error
+	// Locked returns the records locked at the given time.
+	Locked(now time.Time) ([]Record, error)
+}
+
+// Guard applies the limits.
+type Guard struct {
+	Store              Store
+	MaxAccountFailures int           // failures before an account is locked
+	MaxIPFailures      int           // failures before an IP is locked
+	LockDuration       time.Duration // how long a lockout lasts
+	BaseDelay          time.Duration // delay after the first failure, doubled on each one after
+	MaxDelay           time.Duration
+	Window             time.Duration // failures older than this are forgotten
+}
+
+// Default is the guard used by the login handlers.
+var Default = &Guard{
+	Store:              NewMemoryStore(),
+	MaxAccountFailures: 5,
+	MaxIPFailures:      20,
+	LockDuration:       15 * time.Minute,
+	BaseDelay:          time.Second,
+	MaxDelay:           30 * time.Second,
+	Window:             15 * time.Minute,
+}
+
+// AccountKey returns the record key of an account.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey returns the record key of a client IP.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a *ThrottledError when a login for the email from the IP is
+// not allowed yet.
+func (g *Guard) Check(email, ip string) error {
+	now := time.Now()
+	var worst *ThrottledError
+	for _, key := range []string{AccountKey(email), IPKey(ip)} {
+		r, err := g.Store.Get(key)
+		if err != nil {
+			return err
+		}
+		var e *ThrottledError
+		switch {
+		case now.Before(r.LockedUntil):
+			e = &ThrottledError{RetryAfter: r.LockedUntil.Sub(now), Locked: true}
+		case now.Before(r.NextAttemptAt):
+			e = &ThrottledError{RetryAfter: r.NextAttemptAt.Sub(now)}
+		}
+		if e != nil && (worst == nil || e.RetryAfter > worst.RetryAfter) {
+			worst = e
+		}
+	}
+	if worst != nil {
+		return worst
+	}
+	return nil
+}
+
+// Failure records a failed login. It reports whether this failure locked the
+// account, so the owner can be notified.
+func (g *Guard) Failure(email, ip string) (accountLocked bool, err error) {
+	accountLocked, err = g.fail(AccountKey(email), g.MaxAccountFailures)
+	if err != nil {
+		return false, err
+	}
+	if _, err := g.fail(IPKey(ip), g.MaxIPFailures); err != nil {
+		return accountLocked, err
+	}
+	return accountLocked, nil
+}
+
+// Success clears the failures of the account after a successful login.
+func (g *Guard) Success(email string) error {
+	return g.Store.Delete(AccountKey(email))
+}
+
+// Unlock clears the failures and lock of an account.
+func (g *Guard) Unlock(email string) error {
+	return g.Store.Delete(AccountKey(email))
+}
+
+// Locked returns the accounts and IPs that are currently locked.
+func (g *Guard) Locked() ([]Record, error) {
+	return g.Store.Locked(time.Now())
+}
+
+func (g *Guard) fail(key string, max int) (bool, error) {
+	locked := false
+	err := g.Store.Update(key, func(r Record) Record {
+		now := time.Now()
+		if now.Sub(r.LastFailureAt) > g.Window && now.After(r.LockedUntil) {
+			r = Record{Key: key}
+		}
+		r.Failures++
+		r.LastFailureAt = now
+		r.NextAttemptAt = now.Add(g.delay(r.Failures))
+
+		locked = false
+		if r.Failures >= max && !now.Before(r.LockedUntil) {
+			r.LockedUntil = now.Add(g.LockDuration)
+			locked = true
+		}
+		return r
+	})
+	return locked, err
+}
+
+// delay returns the wait before the next attempt after the given number of failures.
+func (g *Guard) delay(failures int) time.Duration {
+	d := g.BaseDelay
+	for i := 1; i < failures && d < g.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > g.MaxDelay {
+		d = g.MaxDelay
+	}
+	return d
+}
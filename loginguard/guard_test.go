@@ -0,0 +1,80 @@
+package loginguard
+
+import (
+	"errors"
+	database "gaming/database"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// stores returns a fresh store of every kind.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Logger = logger.Discard
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{"memory": NewMemoryStore(), "database": DBStore{DB: db}}
+}
+
+func newTestGuard(store Store) *Guard {
+	guard := *Default
+	guard.Store = store
+	return &guard
+}
+
+func TestConcurrentFailures(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			guard := newTestGuard(store)
+			guard.MaxAccountFailures = 5
+
+			// Every failure is counted, and only the one reaching the limit locks
+			const n = 8
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			locks := 0
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					locked, err := guard.Failure("ada@example.com", "10.0.0.1")
+					if err != nil {
+						t.Error(err)
+					}
+					if locked {
+						mu.Lock()
+						locks++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			r, err := store.Get(AccountKey("Ada@Example.com"))
+			if err != nil || r.Failures != n || !r.LockedUntil.After(time.Now()) {
+				t.Fatalf("record = %+v, %v, want %d failures and a lock", r, err, n)
+			}
+			if locks != 1 {
+				t.Errorf("%d failures reported locking the account, want 1", locks)
+			}
+			if err := guard.Check("ada@example.com", "10.0.0.2"); !errors.Is(err, ErrLocked) {
+				t.Errorf("Check of a locked account = %v, want ErrLocked", err)
+			}
+
+			if err := guard.Unlock("ada@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if err := guard.Check("ada@example.com", "10.0.0.2"); err != nil {
+				t.Errorf("Check after Unlock = %v", err)
+			}
+		})
+	}
+}
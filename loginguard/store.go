@@ -0,0 +1,129 @@
+package loginguard
+
+import (
+	"errors"
+	"gaming/model"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MemoryStore keeps records in memory. The records are lost on restart and
+// not shared between replicas, so it suits a single instance or tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Get(key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[key]
+	if !ok {
+		return Record{Key: key}, nil
+	}
+	return r, nil
+}
+
+func (s *MemoryStore) Update(key string, fn func(r Record) Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[key]
+	if !ok {
+		r = Record{Key: key}
+	}
+	s.records[key] = fn(r)
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func (s *MemoryStore) Locked(now time.Time) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var locked []Record
+	for _, r := range s.records {
+		if now.Before(r.LockedUntil) {
+			locked = append(locked, r)
+		}
+	}
+	return locked, nil
+}
+
+// DBStore keeps records in the login_attempts table, shared by all replicas.
+type DBStore struct {
+	DB *gorm.DB
+}
+
+func (s DBStore) Get(key string) (Record, error) {
+	var row model.LoginAttempt
+	err := s.DB.Where("key = ?", key).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Record{Key: key}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return fromRow(row), nil
+}
+
+// Update locks the row of the key for the duration of a transaction. A zero
+// row is inserted first when there is none, so that there is always a row to
+// lock, even for the first failures of a key.
+func (s DBStore) Update(key string, fn func(r Record) Record) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.LoginAttempt{Key: key}).Error; err != nil {
+			return err
+		}
+		var row model.LoginAttempt
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&row).Error; err != nil {
+			return err
+		}
+		r := fn(fromRow(row))
+		return tx.Save(&model.LoginAttempt{
+			Key:           key,
+			Failures:      r.Failures,
+			LastFailureAt: r.LastFailureAt,
+			NextAttemptAt: r.NextAttemptAt,
+			LockedUntil:   r.LockedUntil,
+		}).Error
+	})
+}
+
+func (s DBStore) Delete(key string) error {
+	return s.DB.Where("key = ?", key).Delete(&model.LoginAttempt{}).Error
+}
+
+func (s DBStore) Locked(now time.Time) ([]Record, error) {
+	var rows []model.LoginAttempt
+	if err := s.DB.Where("locked_until > ?", now).Order("locked_until desc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	locked := make([]Record, len(rows))
+	for i, row := range rows {
+		locked[i] = fromRow(row)
+	}
+	return locked, nil
+}
+
+func fromRow(row model.LoginAttempt) Record {
+	return Record{
+		Key:           row.Key,
+		Failures:      row.Failures,
+		LastFailureAt: row.LastFailureAt,
+		NextAttemptAt: row.NextAttemptAt,
+		LockedUntil:   row.LockedUntil,
+	}
+}
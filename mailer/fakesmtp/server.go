@@ -0,0 +1,162 @@
+// Package fakesmtp is a minimal SMTP server that accepts every message and
+// keeps it in memory. It stands in for a real mail server during local
+// development and lets tests assert on the emails the app sends.
+package fakesmtp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message is an email received by the server.
+type Message struct {
+	From string
+	To   []string
+	Data []byte // raw message including headers
+}
+
+// Server is a running fake SMTP server.
+type Server struct {
+	// OnMessage, when set, is called for every received message. Set it
+	// before any mail is sent to the server.
+	OnMessage func(Message)
+
+	listener net.Listener
+	mu       sync.Mutex
+	messages []Message
+	wg       sync.WaitGroup
+}
+
+// Start listens on addr (for example "127.0.0.1:0") and serves connections in the background.
+func Start(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Messages returns a copy of the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stops the server and waits for open connections to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle speaks just enough SMTP for net/smtp.SendMail without AUTH or STARTTLS.
+func (s *Server) handle(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...any) bool {
+		return tp.PrintfLine(format, args...) == nil
+	}
+
+	if !reply("220 fakesmtp ready") {
+		return
+	}
+
+	var current Message
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reply("250 fakesmtp")
+		case "EHLO":
+			reply("250-fakesmtp")
+			reply("250 8BITMIME")
+		case "MAIL":
+			current = Message{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			current.To = append(current.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			if len(current.To) == 0 {
+				reply("503 need RCPT first")
+				continue
+			}
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			current.Data = data
+			s.store(current)
+			current = Message{}
+			reply("250 OK")
+		case "RSET":
+			current = Message{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func (s *Server) store(m Message) {
+	s.mu.Lock()
+	s.messages = append(s.messages, m)
+	onMessage := s.OnMessage
+	s.mu.Unlock()
+	if onMessage != nil {
+		onMessage(m)
+	}
+}
+
+// address extracts the address from "FROM:<a@b>" or "TO:<a@b>".
+func address(arg string) string {
+	_, value, _ := strings.Cut(arg, ":")
+	value = strings.TrimSpace(value)
+	if i := strings.IndexByte(value, ' '); i >= 0 {
+		value = value[:i] // drop parameters such as BODY=8BITMIME
+	}
+	return strings.Trim(value, "<>")
+}
+
+// String describes the message for logs.
+func (m Message) String() string {
+	return fmt.Sprintf("from=%s to=%v size=%d", m.From, m.To, len(m.Data))
+}
@@ -0,0 +1,94 @@
+// Package mailer sends transactional emails such as OTPs, invites, match
+// reminders and payout notices.
+//
+// Emails are rendered from localized templates and queued in a persistent
+// outbox; the outbox delivers them through a Mailer implementation and
+// retries failed deliveries with backoff.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"gaming/config"
+	"gaming/mailer/fakesmtp"
+	"log/slog"
+)
+
+// Message is a single email.
+type Message struct {
+	To      string
+	Subject string
+	Text    string // plain text body
+	HTML    string // optional HTML alternative
+}
+
+// Mailer delivers a message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default is the mailer used by the outbox.
+var Default Mailer = NewMemoryMailer()
+
+// FromConfig builds a mailer from the config. Without a driver, smtp is
+// used when a host is set and memory otherwise.
+//
+// The fakesmtp driver starts a local SMTP stand-in on the host and port
+// (127.0.0.1:2525 by default) that logs every message it receives.
+func FromConfig(cfg config.Mail) (Mailer, error) {
+	driver := cfg.Driver
+	if driver == "" {
+		driver = "smtp"
+		if cfg.Host == "" {
+			slog.Warn("SMTP host not set, emails are kept in memory and not delivered")
+			driver = "memory"
+		}
+	}
+
+	switch driver {
+	case "smtp":
+		if cfg.Host == "" {
+			return nil, fmt.Errorf("SMTP host is required for the smtp mail driver")
+		}
+		return &SMTPMailer{
+			Host:     cfg.Host,
+			Port:     portOr(cfg.Port, 587),
+			Username: cfg.Username,
+			Password: cfg.Password,
+			From:     cfg.From,
+		}, nil
+	case "fakesmtp":
+		host, port := cfg.Host, portOr(cfg.Port, 2525)
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		server, err := fakesmtp.Start(fmt.Sprintf("%s:%d", host, port))
+		if err != nil {
+			return nil, err
+		}
+		server.OnMessage = func(m fakesmtp.Message) {
+			slog.Info("fakesmtp: received mail", "mail", m.String())
+		}
+		slog.Info("fakesmtp: listening", "addr", server.Addr())
+		return &SMTPMailer{Host: host, Port: port, From: cfg.From}, nil
+	case "file":
+		m, err := NewFileMailer(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		m.From = cfg.From
+		return m, nil
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
+
+// portOr returns port, or def when it is not set.
+func portOr(port, def int) int {
+	if port == 0 {
+		return def
+	}
+	return port
+}
@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"context"
+	"gaming/logging"
+	"gaming/model"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbox stores emails in the database and delivers them in the background,
+// so a slow or unavailable mail server never blocks a request and no email is
+// lost on restart.
+//
+// Bodies can hold secrets such as OTPs, so they are blanked as soon as an
+// email is delivered or given up on; the rest of the row is kept for the
+// retention period to trace deliveries, then purged.
+type Outbox struct {
+	DB          *gorm.DB
+	MaxAttempts int           // deliveries tried before an email is given up on
+	BaseBackoff time.Duration // delay after the first failure, doubled on each retry
+	MaxBackoff  time.Duration
+	BatchSize   int
+	Retention   time.Duration // how long delivered and abandoned emails are kept
+}
+
+// claimLease is how long a claimed email is hidden from other workers while it is sent.
+const claimLease = 5 * time.Minute
+
+// DefaultOutbox is the outbox used by Enqueue. Its DB has to be set before use.
+var DefaultOutbox = &Outbox{
+	MaxAttempts: 8,
+	BaseBackoff: 30 * time.Second,
+	MaxBackoff:  time.Hour,
+	BatchSize:   50,
+	Retention:   30 * 24 * time.Hour,
+}
+
+// Enqueue stores the message in the default outbox.
+func Enqueue(msg Message) error {
+	return DefaultOutbox.Enqueue(msg)
+}
+
+// Enqueue stores the message for delivery.
+func (o *Outbox) Enqueue(msg Message) error {
+	email := model.OutboxEmail{
+		To:            msg.To,
+		Subject:       msg.Subject,
+		Text:          msg.Text,
+		HTML:          msg.HTML,
+		NextAttemptAt: time.Now(),
+	}
+	return o.DB.Create(&email).Error
+}
+
+// ProcessDue tries to deliver every email that is due, using the mailer.
+// It returns the number of emails delivered.
+func (o *Outbox) ProcessDue(ctx context.Context, mailer Mailer) (int, error) {
+	// Claim a batch by pushing its next attempt past the lease. SKIP LOCKED lets
+	// several replicas work through the outbox without sending an email twice.
+	var emails []model.OutboxEmail
+	err := o.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND attempts < ? AND next_attempt_at <= ?", o.MaxAttempts, time.Now()).
+			Order("next_attempt_at").Limit(o.BatchSize).Find(&emails).Error
+		if err != nil || len(emails) == 0 {
+			return err
+		}
+		ids := make([]uint, len(emails))
+		for i, email := range emails {
+			ids[i] = email.ID
+		}
+		return tx.Model(&model.OutboxEmail{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(claimLease)).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, email := range emails {
+		msg := Message{To: email.To, Subject: email.Subject, Text: email.Text, HTML: email.HTML}
+		email.Attempts++
+		if err := mailer.Send(ctx, msg); err != nil {
+			email.LastError = err.Error()
+			email.NextAttemptAt = time.Now().Add(o.backoff(email.Attempts))
+			if email.Attempts >= o.MaxAttempts {
+				logging.FromContext(ctx).Warn("giving up on email", "email_id", email.ID, "to", email.To, "error", err)
+				email.Text, email.HTML = "", ""
+			}
+		} else {
+			now := time.Now()
+			email.SentAt = &now
+			email.LastError = ""
+			email.Text, email.HTML = "", ""
+			sent++
+		}
+		if err := o.DB.Save(&email).Error; err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// backoff returns the delay before the next delivery attempt.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.BaseBackoff
+	for i := 1; i < attempts && delay < o.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > o.MaxBackoff {
+		delay = o.MaxBackoff
+	}
+	return delay
+}
+
+// Purge deletes the emails delivered or given up on that were queued more than
+// the retention period before now.
+func (o *Outbox) Purge(now time.Time) error {
+	return o.DB.Where("created_at < ? AND (sent_at IS NOT NULL OR attempts >= ?)", now.Add(-o.Retention), o.MaxAttempts).
+		Delete(&model.OutboxEmail{}).Error
+}
+
+// Start processes the outbox periodically in the background with the Default
+// mailer, and purges it once an hour.
+func (o *Outbox) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var purged time.Time
+		for now := range ticker.C {
+			if _, err := o.ProcessDue(context.Background(), Default); err != nil {
+				slog.Error("failed to process mail outbox", "error", err)
+			}
+			if now.Sub(purged) >= time.Hour {
+				purged = now
+				if err := o.Purge(now); err != nil {
+					slog.Error("failed to purge mail outbox", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// SendTemplate renders a template and queues it in the default outbox.
+func SendTemplate(to, locale, name string, data any) error {
+	msg, err := Render(to, locale, name, data)
+	if err != nil {
+		return err
+	}
+	return Enqueue(msg)
+}
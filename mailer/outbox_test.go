@@ -0,0 +1,122 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	database "gaming/database"
+	"gaming/mailer/fakesmtp"
+	"gaming/model"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+func newTestOutbox(t *testing.T) *Outbox {
+	t.Helper()
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Logger = logger.Discard
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return &Outbox{DB: db, MaxAttempts: 2, MaxBackoff: time.Minute, BatchSize: 10, Retention: time.Hour}
+}
+
+// onlyEmail returns the single email in the outbox.
+func onlyEmail(t *testing.T, o *Outbox) model.OutboxEmail {
+	t.Helper()
+	var emails []model.OutboxEmail
+	if err := o.DB.Find(&emails).Error; err != nil || len(emails) != 1 {
+		t.Fatalf("outbox = %+v, %v, want one email", emails, err)
+	}
+	return emails[0]
+}
+
+func TestOutboxDeliversOverSMTP(t *testing.T) {
+	server, err := fakesmtp.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Addr())
+	portNumber, _ := strconv.Atoi(port)
+	smtpMailer := &SMTPMailer{Host: host, Port: portNumber, From: "no-reply@localhost"}
+
+	outbox := newTestOutbox(t)
+	msg, err := Render("ada@example.com", "es-ES", TemplateOTP, map[string]any{"Code": "482913", "ExpiresIn": 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Enqueue(msg); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := outbox.ProcessDue(context.Background(), smtpMailer); err != nil || sent != 1 {
+		t.Fatalf("ProcessDue = %d, %v, want 1 sent", sent, err)
+	}
+
+	received := server.Messages()
+	if len(received) != 1 || received[0].To[0] != "ada@example.com" {
+		t.Fatalf("the SMTP server received %v, want one email to ada@example.com", received)
+	}
+	if data := string(received[0].Data); !strings.Contains(data, "482913") || !strings.Contains(data, "Caduca en") {
+		t.Errorf("the email is not the Spanish OTP email:\n%s", data)
+	}
+
+	// The code is not kept once delivered, and the row goes after the retention
+	email := onlyEmail(t, outbox)
+	if email.SentAt == nil || email.Text != "" || email.HTML != "" {
+		t.Errorf("delivered email = %+v, want it sent with the bodies blanked", email)
+	}
+	if err := outbox.Purge(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	onlyEmail(t, outbox)
+	if err := outbox.Purge(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	outbox.DB.Model(&model.OutboxEmail{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d emails left after the retention, want none", count)
+	}
+}
+
+// failingMailer refuses every message.
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, Message) error {
+	return errors.New("mail server unavailable")
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+	outbox := newTestOutbox(t)
+	if err := outbox.Enqueue(Message{To: "ada@example.com", Subject: "Code", Text: "482913"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The body is kept while the delivery is retried
+	outbox.ProcessDue(context.Background(), failingMailer{})
+	if email := onlyEmail(t, outbox); email.Attempts != 1 || email.Text != "482913" {
+		t.Fatalf("email after a failed delivery = %+v, want it kept for a retry", email)
+	}
+	outbox.DB.Model(&model.OutboxEmail{}).Where("1 = 1").Update("next_attempt_at", time.Now())
+	outbox.ProcessDue(context.Background(), failingMailer{})
+	if email := onlyEmail(t, outbox); email.Attempts != 2 || email.Text != "" || email.LastError == "" {
+		t.Fatalf("email after the last delivery = %+v, want it given up with the body blanked", email)
+	}
+
+	if err := outbox.Purge(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	outbox.DB.Model(&model.OutboxEmail{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d emails left after the retention, want none", count)
+	}
+}
@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template names.
+const (
+	TemplateOTP           = "otp"
+	TemplateInvite        = "invite"
+	TemplateMatchReminder = "match_reminder"
+	TemplatePayout        = "payout"
+	TemplateAccountLocked = "account_locked"
+)
+
+// DefaultLocale is used when a template has no translation for the requested locale.
+const DefaultLocale = "en"
+
+// Each template file defines a "subject", a "text" and an "html" block.
+//
+//go:embed templates/*/*.tmpl
+var templateFS embed.FS
+
+// Render renders a template in the given locale into a message for the recipient.
+// The locale may be a language tag such as "es-ES"; only the language is used.
+func Render(to, locale, name string, data any) (Message, error) {
+	path, err := templatePath(locale, name)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Subject and text are rendered without HTML escaping, the html block with it
+	text, err := texttemplate.ParseFS(templateFS, path)
+	if err != nil {
+		return Message{}, err
+	}
+	html, err := htmltemplate.ParseFS(templateFS, path)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.ExecuteTemplate(&textBody, "text", data); err != nil {
+		return Message{}, err
+	}
+	if err := html.ExecuteTemplate(&htmlBody, "html", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    textBody.String(),
+		HTML:    htmlBody.String(),
+	}, nil
+}
+
+// templatePath returns the template file for the locale, falling back to DefaultLocale.
+func templatePath(locale, name string) (string, error) {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	for _, l := range []string{lang, DefaultLocale} {
+		path := fmt.Sprintf("templates/%s/%s.tmpl", l, name)
+		if _, err := templateFS.Open(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("mailer: unknown template %q", name)
+}
@@ -1,47 +1,109 @@
 package main
 
 import (
+	"context"
+	"gaming/account"
+	"gaming/audit"
 	"gaming/config"
-	"gaming/handlers/leagues"
-	"gaming/handlers/result"
-	team "gaming/handlers/team"
-	"gaming/handlers/tournament"
-	"gaming/handlers/user"
+	"gaming/idempotency"
 	"gaming/jwt"
+	"gaming/logging"
+	"gaming/loginguard"
+	"gaming/mailer"
+	"gaming/oidcauth"
+	"gaming/otp"
+	"gaming/ratelimit"
+	"gaming/repository"
+	"gaming/server"
+	"gaming/sms"
+	"log"
+	"log/slog"
+	"os"
+	"time"
 
 	database "gaming/database"
-
-	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	//connect env
-	config.LoadEnv()
+	//load and validate the configuration
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	//log as structured records with secrets redacted
+	if err := logging.Configure(cfg.Log, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("configuration loaded", "config", cfg.String())
+	//load token signing keys
+	if err := jwt.LoadKeys(cfg.JWT); err != nil {
+		fatal("failed to load jwt keys", err)
+	}
 	//connect database
-	database.DBconnect()
+	database.DBconnect(cfg.DSN)
+	//"gaming migrate ..." manages the schema instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	//refuse to serve against an outdated schema
+	if err := database.CheckSchema(database.DB); err != nil {
+		fatal("the database schema is outdated, run \"gaming migrate up\" to apply pending migrations", err)
+	}
+	repos := repository.NewGorm(database.DB)
+	//record audit events in the database
+	audit.Default = audit.DBStore{DB: database.DB}
+	//configure one-time passwords
+	otps := otp.NewService(repos.OTPs)
+	otps.Length = cfg.OTP.Length
+	otps.TTL = cfg.OTP.TTL
+	otps.MaxAttempts = cfg.OTP.MaxAttempts
+	otps.ResendCooldown = cfg.OTP.ResendCooldown
+	//configure outgoing mail and deliver the outbox in the background
+	mail, err := mailer.FromConfig(cfg.Mail)
+	if err != nil {
+		fatal("failed to configure mailer", err)
+	}
+	mailer.Default = mail
+	mailer.DefaultOutbox.DB = database.DB
+	mailer.DefaultOutbox.Start(10 * time.Second)
+	//configure outgoing text messages
+	sender, err := sms.FromConfig(cfg.SMS)
+	if err != nil {
+		fatal("failed to configure sms", err)
+	}
+	sms.Default = sender
+	//keep failed login attempts in memory or in the database
+	if cfg.LoginGuardStore != "memory" {
+		loginguard.Default.Store = loginguard.DBStore{DB: database.DB}
+	}
+	//configure the rate limits
+	if err := ratelimit.Configure(cfg.RateLimit, database.DB); err != nil {
+		fatal("failed to configure rate limits", err)
+	}
+	//keep the responses to requests with an Idempotency-Key for replay
+	if err := idempotency.Configure(cfg.Idempotency, database.DB); err != nil {
+		fatal("failed to configure idempotency", err)
+	}
+	//discover the configured social login providers
+	if err := oidcauth.LoadProviders(context.Background(), cfg.OIDC); err != nil {
+		fatal("failed to load identity providers", err)
+	}
+	//remove expired sessions and revocations
+	jwt.NewAuth(repos.Sessions, repos.APIKeys).StartCleanup(time.Hour)
+	//remove expired idempotency records
+	idempotency.StartCleanup(time.Hour)
+	//remove idle rate limit buckets
+	ratelimit.StartCleanup(time.Hour)
+	//anonymize accounts whose deletion grace period has passed
+	account.NewService(repos.Accounts, loginguard.Default).StartDeletion(time.Hour)
 
-	//initialize gin
-	r := gin.Default()
-	//user authentication
-	r.POST("/user/signup", user.Signup)
-	r.POST("/user/verification", user.VerifyOTP)
-	r.POST("/user/login", user.Login)
-	//user middleware
-	r.GET("/user/profile", jwt.AuthMiddleware("user"), user.UserProfile)
-	r.PATCH("/user/profile", jwt.AuthMiddleware("user"), user.EditUser)
-	r.POST("/user/leagues", jwt.AuthMiddleware("user"), leagues.CreateLeagues)
-	r.GET("/user/leagues", jwt.AuthMiddleware("user"), leagues.ViewLeagues)
-	r.POST("/user/league/team", jwt.AuthMiddleware("user"), team.CreateTeam)
-	r.POST("/user/leagues/join", jwt.AuthMiddleware("user"), leagues.JoinLeague)
-	r.POST("/user/tournament", jwt.AuthMiddleware("user"), tournament.CreateTournament)
-	r.GET("/user/tournament", jwt.AuthMiddleware("user"), tournament.ViewTournaments)
-	r.POST("/user/tournament/teamA", jwt.AuthMiddleware("user"), team.CreateTeamA)
-	r.POST("/user/tournament/teamB", jwt.AuthMiddleware("user"), team.CreateTeamB)
-	r.POST("user/tournament/join", jwt.AuthMiddleware("user"), tournament.JoinTournament)
-	r.GET("/user/leagues/result", jwt.AuthMiddleware("user"), result.LeagueResult)
-	r.GET("/user/leagues/price", jwt.AuthMiddleware("user"), result.PriceDistribution)
-	r.GET("/user/tournament/result", jwt.AuthMiddleware("user"), result.TournamentResult)
-	r.GET("/user/tournament/price", jwt.AuthMiddleware("user"), result.TournamentPriceDistribution)
+	//serve the API
+	r := server.New(repos, otps, loginguard.Default)
+	r.Run(cfg.ListenAddr)
+}
 
-	r.Run(":8080")
+// fatal logs the error that keeps the server from starting and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -12,54 +12,244 @@ type User struct {
 	Email    string `gorm:"unique;not null" json:"email"`
 	Phone    string `json:"phone"`
 	Password string `json:"password" gorm:"not null"`
+	Role     string `json:"role" gorm:"not null;default:user"` // "user" or "admin"
+
+	// Email and phone changes wait here until confirmed with an OTP sent to
+	// the new address or number.
+	PendingEmail  string `json:"pending_email,omitempty"`
+	PendingPhone  string `json:"pending_phone,omitempty"`
+	PhoneVerified bool   `json:"phone_verified"`
+
+	// Account deletion. The row is anonymized once DeletionScheduledAt has
+	// passed, which also sets AnonymizedAt.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" gorm:"index"`
+	AnonymizedAt        *time.Time `json:"-"`
+
+	// Two-factor authentication. TOTPSecret is set at enrollment and only
+	// enforced once TOTPEnabled is set by confirming a first code.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"` // last accepted time step, to reject replayed codes
+}
+
+// RecoveryCode is a one-time code that can replace a TOTP code, e.g. when the
+// authenticator device is lost. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID       uint       `json:"id" gorm:"primaryKey"`
+	UserID   uint       `json:"user_id" gorm:"index;not null"`
+	CodeHash string     `json:"-" gorm:"not null"`
+	UsedAt   *time.Time `json:"used_at"`
 }
 
+// OTP is a one-time password issued to an email for a single purpose.
+// Only a salted hash of the code is stored.
 type OTP struct {
 	gorm.Model
-	Email string `json:"email"`
-	Otp   string `json:"otp"`
-	Exp   time.Time
+	Email      string `json:"email" gorm:"uniqueIndex:idx_otp_email_purpose;not null"`
+	Purpose    string `json:"purpose" gorm:"uniqueIndex:idx_otp_email_purpose;not null"`
+	CodeHash   string `json:"-" gorm:"not null"`
+	Salt       string `json:"-" gorm:"not null"`
+	Attempts   int    `json:"attempts"`
+	Exp        time.Time
+	LastSentAt time.Time
 }
 
+// PendingSignup holds an account that is waiting for OTP verification.
+// It is keyed by email so concurrent signups never share state.
+type PendingSignup struct {
+	Email     string    `json:"email" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"not null"`
+	Phone     string    `json:"phone"`
+	Password  string    `json:"-" gorm:"not null"` // bcrypt hash
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// League is a competition between any number of teams. Leagues, like
+// tournaments and teams, are soft deleted, and have a Version that every
+// edit increments so that concurrent edits cannot overwrite each other.
 type League struct {
-	ID        uint      `json:"id"`
-	Name      string    `json:"name"`
-	PrizePool float64   `json:"prize_pool"`
-	Teams     []Team    `json:"teams"`
-	StartTime time.Time `json:"start_time"`
+	ID        uint           `json:"id"`
+	Name      string         `json:"name"`
+	Game      string         `json:"game" gorm:"index;not null;default:''"`
+	PrizePool float64        `json:"prize_pool" gorm:"index"`
+	Teams     []Team         `json:"teams"`
+	StartTime time.Time      `json:"start_time" gorm:"index"`
+	CreatedBy uint           `json:"created_by" gorm:"index"` // 0 for leagues created before it was recorded
+	Version   uint           `json:"version" gorm:"not null;default:1"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Started reports whether the league has started at now, after which it can
+// no longer be changed. A league without a start time has not started.
+func (l League) Started(now time.Time) bool {
+	return !l.StartTime.IsZero() && !now.Before(l.StartTime)
 }
 
 type Team struct {
-	ID       uint    `json:"id"`
-	Name     string  `json:"name"`
-	PlayerID uint    `json:"player_id"`
-	Score    float64 `json:"score"`
-	LeagueID uint    `json:"league_id"` // Foreign key reference to League
+	ID        uint           `json:"id"`
+	Name      string         `json:"name"`
+	PlayerID  uint           `json:"player_id" gorm:"index"`
+	Score     float64        `json:"score"`
+	LeagueID  uint           `json:"league_id"` // Foreign key reference to League
+	Version   uint           `json:"version" gorm:"not null;default:1"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 type TeamA struct {
-	ID           uint    `json:"id" gorm:"primaryKey"`
-	Name         string  `json:"name" gorm:"not null"`
-	PlayerID     uint    `json:"player_id"`
-	Score        float64 `json:"score"`
-	TournamentID uint    `json:"tournament_id"`
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	Name         string         `json:"name" gorm:"not null"`
+	PlayerID     uint           `json:"player_id" gorm:"index"`
+	Score        float64        `json:"score"`
+	TournamentID uint           `json:"tournament_id"`
+	Version      uint           `json:"version" gorm:"not null;default:1"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 // TeamBID struct represents Team B in the tournament.
 type TeamB struct {
-	ID           uint    `json:"id" gorm:"primaryKey"`
-	Name         string  `json:"name" gorm:"not null"`
-	PlayerID     uint    `json:"player_id"`
-	Score        float64 `json:"score"`
-	TournamentID uint    `json:"tournament_id"`
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	Name         string         `json:"name" gorm:"not null"`
+	PlayerID     uint           `json:"player_id" gorm:"index"`
+	Score        float64        `json:"score"`
+	TournamentID uint           `json:"tournament_id"`
+	Version      uint           `json:"version" gorm:"not null;default:1"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 // Tournament struct represents a tournament between Team A and Team B.
 type Tournament struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name" gorm:"not null"`
+	Game      string         `json:"game" gorm:"index;not null;default:''"`
+	PrizePool float64        `json:"prize_pool" gorm:"index"`
+	TeamA     []TeamA        `json:"TeamA"`
+	TeamB     []TeamB        `json:"TeamB"`
+	StartTime time.Time      `json:"start_time" gorm:"index"`
+	CreatedBy uint           `json:"created_by" gorm:"index"` // 0 for tournaments created before it was recorded
+	Version   uint           `json:"version" gorm:"not null;default:1"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Started reports whether the tournament has started at now, see League.Started.
+func (t Tournament) Started(now time.Time) bool {
+	return !t.StartTime.IsZero() && !now.Before(t.StartTime)
+}
+
+// Session records a token issued to a user so it can be listed and revoked.
+type Session struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
-	Name      string    `json:"name" gorm:"not null"`
-	PrizePool float64   `json:"prize_pool"`
-	TeamA     []TeamA   `json:"TeamA"`
-	TeamB     []TeamB   `json:"TeamB"`
-	StartTime time.Time `json:"start_time"`
+	JTI       string    `json:"-" gorm:"uniqueIndex;not null"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RevokedToken marks a token ID (jti) as no longer valid until it expires.
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+}
+
+// OutboxEmail is an email waiting to be delivered by the mail outbox.
+type OutboxEmail struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	To            string     `json:"to" gorm:"not null"`
+	Subject       string     `json:"subject"`
+	Text          string     `json:"-"`
+	HTML          string     `json:"-"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	SentAt        *time.Time `json:"sent_at" gorm:"index"`
+	LastError     string     `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// UserIdentity links an account at an external OpenID Connect provider to a user.
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	Provider  string    `json:"provider" gorm:"uniqueIndex:idx_identity_provider_subject;not null"`
+	Subject   string    `json:"-" gorm:"uniqueIndex:idx_identity_provider_subject;not null"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLoginState is a pending OpenID Connect authorization, looked up by the
+// state parameter when the provider redirects back.
+type OIDCLoginState struct {
+	State        string    `gorm:"primaryKey"`
+	Provider     string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"` // PKCE verifier
+	Nonce        string    `gorm:"not null"`
+	LinkUserID   uint      // set when an authenticated user links a provider
+	ExpiresAt    time.Time `gorm:"index"`
+}
+
+// LoginAttempt tracks failed logins of an account or IP, keyed as
+// "account:<email>" or "ip:<address>".
+type LoginAttempt struct {
+	Key           string    `json:"key" gorm:"primaryKey"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LockedUntil   time.Time `json:"locked_until" gorm:"index"`
+}
+
+// RateLimitBucket is a token bucket shared by all replicas.
+type RateLimitBucket struct {
+	Key        string    `gorm:"primaryKey"`
+	Tokens     float64   `gorm:"not null"`
+	RefilledAt time.Time `gorm:"index"`
+}
+
+// APIKey is a personal key that lets bots and integrations call the API on
+// behalf of a user. Only a hash of the secret is stored; Prefix identifies
+// the key in listings and lookups.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"uniqueIndex;not null"`
+	KeyHash    string     `json:"-" gorm:"not null"`
+	Scopes     string     `json:"scopes" gorm:"not null"` // comma separated
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AuditEvent is an entry of the security audit log. ActorID is the user who
+// acted and SubjectID the user affected; both are 0 when unknown, e.g. for a
+// failed login with an unknown email. Before, After and Details hold JSON.
+type AuditEvent struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Action     string    `json:"action" gorm:"index;not null"`
+	ActorID    uint      `json:"actor_id" gorm:"index"`
+	SubjectID  uint      `json:"subject_id" gorm:"index"`
+	TargetType string    `json:"target_type"`
+	TargetID   string    `json:"target_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Before     string    `json:"before,omitempty" gorm:"type:text"`
+	After      string    `json:"after,omitempty" gorm:"type:text"`
+	Details    string    `json:"details,omitempty" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// IdempotencyRecord is the response to a request sent with an
+// Idempotency-Key header, replayed when the user retries the request with the
+// same key. Status is 0 while the first request is still being handled.
+type IdempotencyRecord struct {
+	UserID      uint   `gorm:"primaryKey;autoIncrement:false"`
+	Key         string `gorm:"primaryKey"`
+	RequestHash string `gorm:"not null"`
+	Status      int
+	Header      string `gorm:"type:text"` // the replayed headers as JSON
+	Body        string `gorm:"type:text"`
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index"`
 }
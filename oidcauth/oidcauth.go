@@ -0,0 +1,149 @@
+// Package oidcauth configures external OpenID Connect providers for social
+// login. It runs the authorization code flow with PKCE and verifies the ID
+// tokens returned by the providers.
+package oidcauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gaming/config"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Provider is a configured OpenID Connect provider.
+type Provider struct {
+	Name     string
+	OAuth2   *oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+}
+
+// Identity is the verified user information taken from an ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// ErrUnknownProvider is returned for a provider that is not configured.
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]*Provider{}
+)
+
+// LoadProviders configures the providers listed in the config, which has been
+// validated. Discovery contacts every issuer, so this is called once at startup.
+func LoadProviders(ctx context.Context, cfg config.OIDC) error {
+	for _, name := range cfg.Names() {
+		settings := cfg.Provider[name]
+		scopes := []string{oidc.ScopeOpenID, "email", "profile"}
+		scopes = append(scopes, strings.Fields(settings.Scopes)...)
+
+		provider, err := NewProvider(ctx, name, settings.Issuer, settings.ClientID, settings.ClientSecret, settings.RedirectURL, scopes)
+		if err != nil {
+			return err
+		}
+		Register(provider)
+	}
+	return nil
+}
+
+// NewProvider discovers the issuer and returns a provider for it.
+func NewProvider(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string, scopes []string) (*Provider, error) {
+	discovered, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover %s: %w", name, err)
+	}
+	return &Provider{
+		Name: name,
+		OAuth2: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     discovered.Endpoint(),
+			Scopes:       scopes,
+		},
+		Verifier: discovered.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// Register adds or replaces a provider.
+func Register(p *Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[p.Name] = p
+}
+
+// Get returns the provider with the given name.
+func Get(name string) (*Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// AuthCodeURL returns the provider's authorization URL for the state, nonce and PKCE verifier.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.OAuth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange trades the authorization code for tokens and verifies the ID token,
+// including its nonce, and returns the identity it describes.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	token, err := p.OAuth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := p.Verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Subject:       idToken.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// NewVerifier returns a PKCE code verifier.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// RandomString returns a random hex string for state and nonce values.
+func RandomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
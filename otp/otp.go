@@ -0,0 +1,174 @@
+// Package otp issues and verifies one-time passwords sent to users by email.
+//
+// Codes are generated with crypto/rand and only a salted hash is stored. Each
+// code is scoped to a purpose, can be verified once, is invalidated after too
+// many failed attempts and cannot be re-sent before a cooldown has passed.
+package otp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gaming/generateotp"
+	"gaming/model"
+	"gaming/repository"
+	"time"
+)
+
+// Purpose scopes an OTP so a code issued for one flow cannot be used in another.
+type Purpose string
+
+const (
+	PurposeSignup      Purpose = "signup"
+	PurposeReset       Purpose = "reset"
+	PurposeEmailChange Purpose = "email_change"
+	PurposeLogin       Purpose = "login"
+	PurposeUnlock      Purpose = "unlock"
+	// PurposePhone codes are sent by SMS and keyed by the phone number
+	// instead of an email.
+	PurposePhone Purpose = "phone"
+)
+
+var (
+	// ErrNotFound is returned when no OTP was issued for the email and purpose.
+	ErrNotFound = errors.New("otp not found")
+	// ErrExpired is returned when the OTP is past its expiry time.
+	ErrExpired = errors.New("otp expired")
+	// ErrInvalid is returned when the code does not match.
+	ErrInvalid = errors.New("invalid otp")
+	// ErrTooManyAttempts is returned once the failed attempts reach the limit.
+	// The OTP is kept, refusing every code, and no new one is issued until it
+	// expires, so that requesting a new code does not reset the limit.
+	ErrTooManyAttempts = errors.New("too many failed otp attempts")
+)
+
+// CooldownError is returned by Issue when a new code is requested too soon.
+type CooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("otp was sent recently, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// Service issues and verifies OTPs.
+type Service struct {
+	Repo           repository.OTPRepo
+	Length         int           // number of digits in a code
+	TTL            time.Duration // how long a code stays valid
+	MaxAttempts    int           // failed attempts allowed before the code is invalidated
+	ResendCooldown time.Duration // minimum time between two codes for the same email and purpose
+}
+
+// NewService returns a service storing the codes in repo, with 6 digit codes
+// valid for 10 minutes, 5 attempts and a resend cooldown of a minute.
+func NewService(repo repository.OTPRepo) *Service {
+	return &Service{
+		Repo:           repo,
+		Length:         6,
+		TTL:            10 * time.Minute,
+		MaxAttempts:    5,
+		ResendCooldown: time.Minute,
+	}
+}
+
+// Issue generates a new code for the email and purpose, replacing any earlier
+// one, and returns it so it can be sent to the user.
+func (s *Service) Issue(email string, purpose Purpose) (string, error) {
+	code, err := generateotp.GenerateOTP(s.Length)
+	if err != nil {
+		return "", err
+	}
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.Repo.Update(email, string(purpose), func(existing *model.OTP) (*model.OTP, error) {
+		if existing != nil {
+			if wait := existing.LastSentAt.Add(s.ResendCooldown).Sub(now); wait > 0 {
+				return nil, &CooldownError{RetryAfter: wait}
+			}
+			if wait := existing.Exp.Sub(now); existing.Attempts >= s.MaxAttempts && wait > 0 {
+				return nil, &CooldownError{RetryAfter: wait}
+			}
+		}
+		// A new code also resets the failed attempts of the previous one
+		return &model.OTP{
+			CodeHash:   hashCode(salt, email, purpose, code),
+			Salt:       salt,
+			Exp:        now.Add(s.TTL),
+			LastSentAt: now,
+		}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Verify checks the code for the email and purpose. A matching code is
+// consumed; a wrong one counts as a failed attempt.
+func (s *Service) Verify(email string, purpose Purpose, code string) error {
+	// The domain error is kept outside the update so the failed attempt is
+	// stored instead of being discarded with the error
+	var result error
+	err := s.Repo.Update(email, string(purpose), func(row *model.OTP) (*model.OTP, error) {
+		if row == nil {
+			result = ErrNotFound
+			return nil, nil
+		}
+		if time.Now().After(row.Exp) {
+			result = ErrExpired
+			return nil, nil
+		}
+		if row.Attempts >= s.MaxAttempts {
+			result = ErrTooManyAttempts
+			return row, nil
+		}
+
+		expected, _ := hex.DecodeString(row.CodeHash)
+		actual, _ := hex.DecodeString(hashCode(row.Salt, email, purpose, code))
+		if subtle.ConstantTimeCompare(expected, actual) == 1 {
+			return nil, nil
+		}
+
+		// Wrong code: count the attempt. The code is locked, not deleted, once
+		// the limit is reached, see ErrTooManyAttempts
+		row.Attempts++
+		result = ErrInvalid
+		if row.Attempts >= s.MaxAttempts {
+			result = ErrTooManyAttempts
+		}
+		return row, nil
+	})
+	if err != nil {
+		return err
+	}
+	return result
+}
+
+// Discard removes any pending code for the email and purpose.
+func (s *Service) Discard(email string, purpose Purpose) error {
+	return s.Repo.Delete(email, string(purpose))
+}
+
+// hashCode binds the code to its email and purpose so a stored hash cannot be
+// reused for another account or flow.
+func hashCode(salt, email string, purpose Purpose, code string) string {
+	sum := sha256.Sum256([]byte(salt + "|" + string(purpose) + "|" + email + "|" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSalt returns a random per-code salt.
+func newSalt() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
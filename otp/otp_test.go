@@ -0,0 +1,73 @@
+package otp
+
+import (
+	"errors"
+	"gaming/repository"
+	"testing"
+	"time"
+)
+
+func newTestService() *Service {
+	s := NewService(repository.NewMemory().OTPs)
+	s.ResendCooldown = 0
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestService()
+	code, err := s.Issue("ada@example.com", PurposeSignup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify("ada@example.com", PurposeReset, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Verify for another purpose = %v, want ErrNotFound", err)
+	}
+	if err := s.Verify("ada@example.com", PurposeSignup, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify("ada@example.com", PurposeSignup, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Verify = %v, want ErrNotFound as the code is consumed", err)
+	}
+}
+
+func TestAttemptLimitSurvivesReissue(t *testing.T) {
+	s := newTestService()
+	code, err := s.Issue("ada@example.com", PurposeSignup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < s.MaxAttempts; i++ {
+		if err := s.Verify("ada@example.com", PurposeSignup, "wrong"); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("attempt %d = %v, want ErrInvalid", i, err)
+		}
+	}
+	if err := s.Verify("ada@example.com", PurposeSignup, "wrong"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("last attempt = %v, want ErrTooManyAttempts", err)
+	}
+
+	// Neither the right code nor a new one gets past the limit
+	if err := s.Verify("ada@example.com", PurposeSignup, code); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("right code after the limit = %v, want ErrTooManyAttempts", err)
+	}
+	_, err = s.Issue("ada@example.com", PurposeSignup)
+	var cooldown *CooldownError
+	if !errors.As(err, &cooldown) || cooldown.RetryAfter < s.TTL-time.Minute {
+		t.Fatalf("Issue after the limit = %v, want a cooldown until the code expires", err)
+	}
+}
+
+func TestResendCooldown(t *testing.T) {
+	s := newTestService()
+	s.ResendCooldown = time.Minute
+	if _, err := s.Issue("ada@example.com", PurposeSignup); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Issue("ada@example.com", PurposeSignup)
+	var cooldown *CooldownError
+	if !errors.As(err, &cooldown) {
+		t.Errorf("second Issue = %v, want a CooldownError", err)
+	}
+	if _, err := s.Issue("ada@example.com", PurposeReset); err != nil {
+		t.Errorf("Issue for another purpose = %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"gaming/model"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MemoryBackend keeps buckets in memory. Idle buckets are dropped after
+// the idle timeout so the map does not grow without bound.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	idle    time.Duration
+	swept   time.Time
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{buckets: make(map[string]*bucket), idle: time.Hour}
+}
+
+func (m *MemoryBackend) Take(key string, limit Limit, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.swept) > m.idle {
+		m.deleteIdle(now.Add(-m.idle))
+		m.swept = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{}
+		m.buckets[key] = b
+	}
+	return b.take(limit, now), nil
+}
+
+func (m *MemoryBackend) DeleteIdle(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteIdle(before)
+	return nil
+}
+
+func (m *MemoryBackend) deleteIdle(before time.Time) {
+	for k, b := range m.buckets {
+		if b.Updated.Before(before) {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+// DBBackend keeps buckets in the rate_limit_buckets table so every replica
+// sharing the database enforces the same limits. Each take inserts the row of
+// its bucket if missing and locks it for the duration of a short transaction,
+// so that two first takes of a key cannot both find no row.
+type DBBackend struct {
+	DB *gorm.DB
+}
+
+func (d DBBackend) Take(key string, limit Limit, now time.Time) (Result, error) {
+	var result Result
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		// A new row has no refill time, which take treats as a full bucket
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.RateLimitBucket{Key: key}).Error; err != nil {
+			return err
+		}
+		var row model.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&row).Error; err != nil {
+			return err
+		}
+		b := bucket{Tokens: row.Tokens, Updated: row.RefilledAt}
+		result = b.take(limit, now)
+		return tx.Save(&model.RateLimitBucket{Key: key, Tokens: b.Tokens, RefilledAt: b.Updated}).Error
+	})
+	return result, err
+}
+
+func (d DBBackend) DeleteIdle(before time.Time) error {
+	return d.DB.Where("refilled_at < ?", before).Delete(&model.RateLimitBucket{}).Error
+}
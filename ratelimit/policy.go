@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"fmt"
+	"gaming/config"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The policies applied to the routes. Auth routes are limited per IP since
+// the caller is not known yet; the others per API key or user.
+var (
+	Auth  = Policy{Name: "auth", Limit: Limit{Requests: 10, Period: time.Minute, Burst: 10}, Key: ByIP}
+	Write = Policy{Name: "write", Limit: Limit{Requests: 60, Period: time.Minute, Burst: 30}, Key: ByAPIKey}
+	Read  = Policy{Name: "read", Limit: Limit{Requests: 300, Period: time.Minute, Burst: 100}, Key: ByAPIKey}
+)
+
+// Configure sets the policy limits and the backend from the config. The
+// database backend keeps the buckets in db.
+func Configure(cfg config.RateLimit, db *gorm.DB) error {
+	for _, p := range []struct {
+		value  string
+		policy *Policy
+	}{
+		{cfg.Auth, &Auth},
+		{cfg.Write, &Write},
+		{cfg.Read, &Read},
+	} {
+		if p.value == "" {
+			continue
+		}
+		limit, err := ParseLimit(p.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.policy.Name, err)
+		}
+		p.policy.Limit = limit
+	}
+
+	switch cfg.Backend {
+	case "", "memory":
+		Default.Backend = NewMemoryBackend()
+	case "database":
+		Default.Backend = DBBackend{DB: db}
+	default:
+		return fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+	return nil
+}
+
+// StartCleanup removes the idle buckets of the default limiter periodically
+// in the background. A bucket is idle once it has had time to fill up under
+// every policy, so removing it does not change any limit.
+func StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := Default.Backend.DeleteIdle(time.Now().Add(-idleAfter())); err != nil {
+				slog.Error("failed to clean up rate limit buckets", "error", err)
+			}
+		}
+	}()
+}
+
+// idleAfter returns the longest time a bucket of the policies takes to fill.
+func idleAfter() time.Duration {
+	var idle time.Duration
+	for _, p := range []Policy{Auth, Write, Read} {
+		if fill := p.Limit.fill(); fill > idle {
+			idle = fill
+		}
+	}
+	return idle
+}
@@ -0,0 +1,196 @@
+// Package ratelimit provides token-bucket rate limiting as Gin middleware.
+//
+// A Policy combines a Limit with a KeyFunc that decides who is limited (an IP,
+// a user or an API key). The buckets live in a Backend: MemoryBackend for a
+// single instance, or any shared implementation such as DBBackend when
+// several replicas must enforce one limit together.
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gaming/apperr"
+	"gaming/logging"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit allows Requests per Period on average, with bursts of up to Burst requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// ParseLimit parses "requests/period" or "requests/period/burst", e.g. "60/1m" or "10/1m/20".
+// The burst defaults to the number of requests.
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	burst := requests
+	if len(parts) == 3 {
+		if burst, err = strconv.Atoi(parts[2]); err != nil || burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+		}
+	}
+	return Limit{Requests: requests, Period: period, Burst: burst}, nil
+}
+
+// rate returns the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// fill returns how long an empty bucket takes to be full again.
+func (l Limit) fill() time.Duration {
+	return seconds(float64(l.Burst) / l.rate())
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // until a token is available, when not allowed
+	Reset      time.Duration // until the bucket is full again
+}
+
+// Backend stores the buckets.
+type Backend interface {
+	// Take removes a token from the bucket of the key if one is available.
+	Take(key string, limit Limit, now time.Time) (Result, error)
+	// DeleteIdle removes the buckets last taken from before the given time.
+	DeleteIdle(before time.Time) error
+}
+
+// bucket is the state of one token bucket.
+type bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// take refills the bucket for the time elapsed and takes a token if possible.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	burst := float64(limit.Burst)
+	if b.Updated.IsZero() {
+		b.Tokens = burst
+	} else if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+elapsed*limit.rate())
+	}
+	b.Updated = now
+
+	result := Result{}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.Tokens) / limit.rate())
+	}
+	result.Remaining = int(b.Tokens)
+	result.Reset = seconds((burst - b.Tokens) / limit.rate())
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// KeyFunc returns the key requests are counted under.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client IP.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests per user ID set by jwt.AuthMiddleware, falling back
+// to the client IP for anonymous requests.
+func ByUser(c *gin.Context) string {
+	if id := c.GetUint("userid"); id != 0 {
+		return "user:" + strconv.FormatUint(uint64(id), 10)
+	}
+	return ByIP(c)
+}
+
+// ByAPIKey counts requests per X-API-Key header, falling back to ByUser.
+// The key is hashed so it never ends up in the backend in plain text.
+func ByAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "apikey:" + hex.EncodeToString(sum[:8])
+	}
+	return ByUser(c)
+}
+
+// Policy is a named limit applied per key.
+type Policy struct {
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+// Limiter applies policies using a backend.
+type Limiter struct {
+	Backend Backend
+}
+
+// Default is the limiter used by the middleware functions.
+var Default = &Limiter{Backend: NewMemoryBackend()}
+
+// Middleware limits the requests of the policy with the default limiter.
+func Middleware(policy Policy) gin.HandlerFunc {
+	return Default.Middleware(policy)
+}
+
+// PerMethod limits safe methods (GET, HEAD, OPTIONS) with read and every
+// other method with write.
+func PerMethod(read, write Policy) gin.HandlerFunc {
+	readLimit, writeLimit := Middleware(read), Middleware(write)
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			readLimit(c)
+		default:
+			writeLimit(c)
+		}
+	}
+}
+
+// Middleware limits the requests of the policy. It sets the X-RateLimit-*
+// headers and answers 429 with Retry-After when the limit is exceeded.
+func (l *Limiter) Middleware(policy Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := policy.Name + ":" + policy.Key(c)
+		result, err := l.Backend.Take(key, policy.Limit, time.Now())
+		if err != nil {
+			// Fail open: an unavailable backend must not take the API down
+			logging.For(c).Error("rate limit backend error", "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(policy.Limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+		if !result.Allowed {
+			apperr.Abort(c, apperr.TooManyRequests("rate_limited", "rate limit exceeded, please retry later", result.RetryAfter))
+			return
+		}
+		c.Next()
+	}
+}
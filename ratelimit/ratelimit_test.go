@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	database "gaming/database"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/logger"
+)
+
+// backends returns a fresh backend of every kind.
+func backends(t *testing.T) map[string]Backend {
+	t.Helper()
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Logger = logger.Discard
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Backend{"memory": NewMemoryBackend(), "database": DBBackend{DB: db}}
+}
+
+func TestRefill(t *testing.T) {
+	limit := Limit{Requests: 2, Period: time.Second, Burst: 3}
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			for i := 2; i >= 0; i-- {
+				r, err := backend.Take("k", limit, now)
+				if err != nil || !r.Allowed || r.Remaining != i {
+					t.Fatalf("take %d = %+v, %v, want allowed with %d remaining", 3-i, r, err, i)
+				}
+			}
+			r, err := backend.Take("k", limit, now)
+			if err != nil || r.Allowed || r.RetryAfter != 500*time.Millisecond || r.Reset != 1500*time.Millisecond {
+				t.Fatalf("take of an empty bucket = %+v, %v, want denied with retry after 500ms", r, err)
+			}
+
+			// Half a second brings one token back, and never more than the burst
+			if r, err := backend.Take("k", limit, now.Add(500*time.Millisecond)); err != nil || !r.Allowed || r.Remaining != 0 {
+				t.Errorf("take after 500ms = %+v, %v, want allowed", r, err)
+			}
+			if r, err := backend.Take("k", limit, now.Add(time.Hour)); err != nil || !r.Allowed || r.Remaining != 2 {
+				t.Errorf("take after an hour = %+v, %v, want a full bucket", r, err)
+			}
+			if r, err := backend.Take("other", limit, now); err != nil || !r.Allowed || r.Remaining != 2 {
+				t.Errorf("take of another key = %+v, %v, want a full bucket", r, err)
+			}
+		})
+	}
+}
+
+func TestConcurrentTakes(t *testing.T) {
+	limit := Limit{Requests: 1, Period: time.Hour, Burst: 5}
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			// Every take of a new key counts, even when none finds its bucket
+			const n = 8
+			now := time.Now()
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			allowed := 0
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r, err := backend.Take("k", limit, now)
+					if err != nil {
+						t.Error(err)
+					}
+					if r.Allowed {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if allowed != limit.Burst {
+				t.Errorf("%d of %d concurrent takes allowed, want %d", allowed, n, limit.Burst)
+			}
+		})
+	}
+}
+
+func TestDeleteIdle(t *testing.T) {
+	limit := Limit{Requests: 1, Period: time.Hour, Burst: 1}
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			for _, key := range []string{"old", "new"} {
+				if _, err := backend.Take(key, limit, now.Add(-time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := backend.Take("new", limit, now); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := backend.DeleteIdle(now.Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			if r, err := backend.Take("old", limit, now); err != nil || !r.Allowed {
+				t.Errorf("take of a deleted bucket = %+v, %v, want a full bucket", r, err)
+			}
+			if r, err := backend.Take("new", limit, now); err != nil || r.Allowed {
+				t.Errorf("take of a kept bucket = %+v, %v, want it still empty", r, err)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := Policy{Name: "test", Limit: Limit{Requests: 1, Period: time.Minute, Burst: 2}, Key: ByIP}
+	limiter := &Limiter{Backend: NewMemoryBackend()}
+	r := gin.New()
+	r.GET("/", limiter.Middleware(policy), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec
+	}
+
+	for _, remaining := range []string{"1", "0"} {
+		rec := get()
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("request within the limit: got %d, want 204", rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != remaining {
+			t.Errorf("X-RateLimit-Remaining = %q, want %s", got, remaining)
+		}
+	}
+
+	rec := get()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: got %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Reset"); got != "120" {
+		t.Errorf("X-RateLimit-Reset = %q, want 120", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+}
@@ -0,0 +1,1070 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gaming/model"
+	"maps"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm returns repositories backed by the database.
+func NewGorm(db *gorm.DB) Repositories {
+	return Repositories{
+		Users:       gormUsers{db},
+		Leagues:     gormLeagues{db},
+		Tournaments: gormTournaments{db},
+		Teams:       gormTeams{db},
+		OTPs:        gormOTPs{db},
+		Sessions:    gormSessions{db},
+		APIKeys:     gormAPIKeys{db},
+		Identities:  gormIdentities{db},
+		Accounts:    gormAccounts{db},
+	}
+}
+
+// exists reports whether a row of the model matches the condition.
+func exists(db *gorm.DB, m any, query string, args ...any) (bool, error) {
+	var count int64
+	err := db.Model(m).Where(query, args...).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// deletionTime is the time to mark soft deleted rows with. Rows deleted
+// together get the same time, which Restore relies on.
+func deletionTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// deletedAt returns the DeletedAt field of a soft deleted model.
+func deletedAt(row any) gorm.DeletedAt {
+	return reflect.ValueOf(row).FieldByName("DeletedAt").Interface().(gorm.DeletedAt)
+}
+
+// updateVersioned sets columns of the live row of T with the id and
+// increments its version, or returns ErrVersionConflict when the row is no
+// longer at version.
+func updateVersioned[T any](db *gorm.DB, id, version uint, fields map[string]any) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// The row stays locked until the update, like OTP updates
+		var row T
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, id).Error; err != nil {
+			return notFound(err)
+		}
+		if uint(reflect.ValueOf(row).FieldByName("Version").Uint()) != version {
+			return ErrVersionConflict
+		}
+		updates := maps.Clone(fields)
+		updates["version"] = version + 1
+		// Databases without row locks, such as SQLite, still refuse a second update of the version
+		result := tx.Model(new(T)).Where("id = ? AND version = ?", id, version).Updates(updates)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		return result.Error
+	})
+}
+
+// softDelete marks the rows of T matching the condition as deleted at and
+// returns how many it marked. Rows deleted earlier keep their time.
+func softDelete[T any](db *gorm.DB, at time.Time, query string, args ...any) (int64, error) {
+	result := db.Model(new(T)).Where(query, args...).Update("deleted_at", at)
+	return result.RowsAffected, result.Error
+}
+
+// deleteVersioned soft deletes the live row of T with the id as at, or
+// returns ErrVersionConflict when the row is no longer at version.
+func deleteVersioned[T any](db *gorm.DB, at time.Time, id, version uint) error {
+	n, err := softDelete[T](db, at, "id = ? AND version = ?", id, version)
+	if err != nil || n > 0 {
+		return err
+	}
+	if _, err := findLive[T](db, id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// restore clears the deletion of the row of T with the id and returns when it
+// had been deleted, or ErrNotFound when no deleted row has the id. check can
+// refuse to restore the row by returning an error.
+func restore[T any](db *gorm.DB, id uint, check func(row T) error) (time.Time, error) {
+	var row T
+	if err := db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&row).Error; err != nil {
+		return time.Time{}, notFound(err)
+	}
+	if err := check(row); err != nil {
+		return time.Time{}, err
+	}
+	at := deletedAt(row).Time
+	return at, db.Unscoped().Model(new(T)).Where("id = ?", id).Update("deleted_at", nil).Error
+}
+
+// restoreWith clears the deletion of the rows of T matching the condition
+// that were deleted at or after at, i.e. together with their parent.
+func restoreWith[T any](db *gorm.DB, at time.Time, query string, args ...any) error {
+	return db.Unscoped().Model(new(T)).Where(query, args...).
+		Where("deleted_at >= ?", at).Update("deleted_at", nil).Error
+}
+
+// nameFree returns ErrDuplicate when a live row of T other than row has the
+// name of row.
+func nameFree[T any](db *gorm.DB, row T) error {
+	v := reflect.ValueOf(row)
+	taken, err := exists(db, new(T), "name = ? AND id <> ?", v.FieldByName("Name").String(), v.FieldByName("ID").Uint())
+	if err == nil && taken {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// parentLive returns ErrParentDeleted unless a live row of P has the id.
+func parentLive[P any](db *gorm.DB, id uint) error {
+	live, err := exists(db, new(P), "id = ?", id)
+	if err == nil && !live {
+		return ErrParentDeleted
+	}
+	return err
+}
+
+// listCompetitions returns a page of the competitions of T that match opts.
+// joined is the condition that a player, the @player argument, has a team in
+// the competition, and teams the associations that hold its teams.
+func listCompetitions[T any](db *gorm.DB, opts ListOptions, joined string, teams ...string) (Page[T], error) {
+	query := db.Model(new(T))
+	switch opts.Status {
+	case StatusUpcoming:
+		query = query.Where("start_time > ?", opts.Now)
+	case StatusStarted:
+		query = query.Where("start_time <= ?", opts.Now)
+	}
+	if !opts.StartsAfter.IsZero() {
+		query = query.Where("start_time > ?", opts.StartsAfter)
+	}
+	if !opts.StartsBefore.IsZero() {
+		query = query.Where("start_time < ?", opts.StartsBefore)
+	}
+	if opts.MinPrizePool != nil {
+		query = query.Where("prize_pool >= ?", *opts.MinPrizePool)
+	}
+	if opts.MaxPrizePool != nil {
+		query = query.Where("prize_pool <= ?", *opts.MaxPrizePool)
+	}
+	if opts.Game != "" {
+		query = query.Where("game = ?", opts.Game)
+	}
+	if opts.PlayerID != 0 {
+		query = query.Where(joined, sql.Named("player", opts.PlayerID))
+	}
+
+	// The id breaks ties so that the order, and so the cursor, is total
+	column, op, dir := opts.sortColumn(), ">", "ASC"
+	if _, ok := sortFields[column]; !ok {
+		return Page[T]{}, fmt.Errorf("cannot sort competitions by %q", column)
+	}
+	if opts.Desc {
+		op, dir = "<", "DESC"
+	}
+	switch {
+	case opts.After != nil && column == SortID:
+		query = query.Where("id "+op+" ?", opts.After.ID)
+	case opts.After != nil:
+		query = query.Where("("+column+" "+op+" ? OR ("+column+" = ? AND id "+op+" ?))", opts.After.Value, opts.After.Value, opts.After.ID)
+	default:
+		query = query.Offset(opts.Offset)
+	}
+	if column != SortID {
+		query = query.Order(column + " " + dir)
+	}
+	query = query.Order("id " + dir)
+
+	// One more row than the page tells whether there is a next page
+	limit := opts.limit()
+	query = query.Limit(limit + 1)
+	if opts.WithTeams {
+		for _, association := range teams {
+			query = query.Preload(association)
+		}
+	}
+	var rows []T
+	if err := query.Find(&rows).Error; err != nil {
+		return Page[T]{}, err
+	}
+	return pageOf(rows, limit, column), nil
+}
+
+// pageOf returns the first limit rows of a list sorted by column, with the
+// cursor of the last one when there are more.
+func pageOf[T any](rows []T, limit int, column string) Page[T] {
+	if len(rows) <= limit {
+		return Page[T]{Items: rows}
+	}
+	rows = rows[:limit]
+	last := reflect.ValueOf(rows[limit-1])
+	next := &Cursor{ID: uint(last.FieldByName("ID").Uint())}
+	if column != SortID {
+		next.Value = last.FieldByName(sortFields[column]).Interface()
+	}
+	return Page[T]{Items: rows, Next: next}
+}
+
+// sortFields maps the sort columns to the fields of the competition models.
+var sortFields = map[string]string{
+	SortID:        "ID",
+	SortName:      "Name",
+	SortPrizePool: "PrizePool",
+	SortStartTime: "StartTime",
+}
+
+// findLive returns the row of T with the id unless it is deleted.
+func findLive[T any](db *gorm.DB, id uint) (T, error) {
+	var row T
+	err := db.First(&row, id).Error
+	return row, notFound(err)
+}
+
+type gormUsers struct{ db *gorm.DB }
+
+func (r gormUsers) ByID(id uint) (model.User, error) {
+	var user model.User
+	err := r.db.First(&user, id).Error
+	return user, notFound(err)
+}
+
+func (r gormUsers) ByEmail(email string) (model.User, error) {
+	var user model.User
+	err := r.db.Where("LOWER(email) = LOWER(?)", email).Order("user_id").First(&user).Error
+	return user, notFound(err)
+}
+
+func (r gormUsers) EmailTaken(email string) (bool, error) {
+	return exists(r.db, &model.User{}, "LOWER(email) = LOWER(?)", email)
+}
+
+func (r gormUsers) Create(user *model.User) error {
+	return r.db.Create(user).Error
+}
+
+func (r gormUsers) Update(id uint, fields map[string]any) error {
+	return r.db.Model(&model.User{}).Where("user_id = ?", id).Updates(fields).Error
+}
+
+func (r gormUsers) ChangeEmail(id uint, email string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		taken, err := exists(tx, &model.User{}, "email = ? AND user_id <> ?", email, id)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicate
+		}
+		return tx.Model(&model.User{}).Where("user_id = ?", id).Updates(map[string]any{
+			"email":         email,
+			"pending_email": "",
+		}).Error
+	})
+}
+
+func (r gormUsers) SavePendingSignup(pending model.PendingSignup) error {
+	return r.db.Save(&pending).Error
+}
+
+func (r gormUsers) CompleteSignup(email string) (model.User, error) {
+	var user model.User
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var pending model.PendingSignup
+		if err := tx.Where("email = ?", email).First(&pending).Error; err != nil {
+			return err
+		}
+		user = model.User{
+			Name:     pending.Name,
+			Email:    pending.Email,
+			Phone:    pending.Phone,
+			Password: pending.Password,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&pending).Error
+	})
+	return user, notFound(err)
+}
+
+func (r gormUsers) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	result := r.db.Model(&model.User{}).
+		Where("user_id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r gormUsers) EnableTOTP(id uint, step int64, recoveryCodeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("user_id = ?", id).Updates(map[string]any{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, hash := range recoveryCodeHashes {
+			if err := tx.Create(&model.RecoveryCode{UserID: id, CodeHash: hash}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r gormUsers) DisableTOTP(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("user_id = ?", id).Updates(map[string]any{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&model.RecoveryCode{}).Error
+	})
+}
+
+func (r gormUsers) UseRecoveryCode(id uint, codeHash string) (bool, error) {
+	result := r.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", id, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+type gormLeagues struct{ db *gorm.DB }
+
+func (r gormLeagues) Create(league *model.League) error {
+	return r.db.Create(league).Error
+}
+
+func (r gormLeagues) ByID(id uint) (model.League, error) {
+	var league model.League
+	err := r.db.Preload("Teams").First(&league, id).Error
+	return league, notFound(err)
+}
+
+func (r gormLeagues) NameTaken(name string) (bool, error) {
+	return exists(r.db, &model.League{}, "name = ?", name)
+}
+
+func (r gormLeagues) List(opts ListOptions) (Page[model.League], error) {
+	joined := "EXISTS (SELECT 1 FROM teams WHERE teams.league_id = leagues.id AND teams.player_id = @player AND teams.deleted_at IS NULL)"
+	return listCompetitions[model.League](r.db, opts, joined, "Teams")
+}
+
+func (r gormLeagues) Update(id, version uint, fields map[string]any) error {
+	return updateVersioned[model.League](r.db, id, version, fields)
+}
+
+func (r gormLeagues) Delete(id, version uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		at := deletionTime()
+		if err := deleteVersioned[model.League](tx, at, id, version); err != nil {
+			return err
+		}
+		_, err := softDelete[model.Team](tx, at, "league_id = ?", id)
+		return err
+	})
+}
+
+func (r gormLeagues) Restore(id uint) (model.League, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		at, err := restore(tx, id, func(league model.League) error { return nameFree(tx, league) })
+		if err != nil {
+			return err
+		}
+		return restoreWith[model.Team](tx, at, "league_id = ?", id)
+	})
+	if err != nil {
+		return model.League{}, err
+	}
+	return r.ByID(id)
+}
+
+type gormTournaments struct{ db *gorm.DB }
+
+func (r gormTournaments) Create(tournament *model.Tournament) error {
+	return r.db.Create(tournament).Error
+}
+
+func (r gormTournaments) ByID(id uint) (model.Tournament, error) {
+	var tournament model.Tournament
+	err := r.db.Preload("TeamA").Preload("TeamB").First(&tournament, id).Error
+	return tournament, notFound(err)
+}
+
+func (r gormTournaments) NameTaken(name string) (bool, error) {
+	return exists(r.db, &model.Tournament{}, "name = ?", name)
+}
+
+func (r gormTournaments) List(opts ListOptions) (Page[model.Tournament], error) {
+	joined := "(EXISTS (SELECT 1 FROM team_as WHERE team_as.tournament_id = tournaments.id AND team_as.player_id = @player AND team_as.deleted_at IS NULL)" +
+		" OR EXISTS (SELECT 1 FROM team_bs WHERE team_bs.tournament_id = tournaments.id AND team_bs.player_id = @player AND team_bs.deleted_at IS NULL))"
+	return listCompetitions[model.Tournament](r.db, opts, joined, "TeamA", "TeamB")
+}
+
+func (r gormTournaments) Update(id, version uint, fields map[string]any) error {
+	return updateVersioned[model.Tournament](r.db, id, version, fields)
+}
+
+func (r gormTournaments) Delete(id, version uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		at := deletionTime()
+		if err := deleteVersioned[model.Tournament](tx, at, id, version); err != nil {
+			return err
+		}
+		if _, err := softDelete[model.TeamA](tx, at, "tournament_id = ?", id); err != nil {
+			return err
+		}
+		_, err := softDelete[model.TeamB](tx, at, "tournament_id = ?", id)
+		return err
+	})
+}
+
+func (r gormTournaments) Restore(id uint) (model.Tournament, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		at, err := restore(tx, id, func(tournament model.Tournament) error { return nameFree(tx, tournament) })
+		if err != nil {
+			return err
+		}
+		if err := restoreWith[model.TeamA](tx, at, "tournament_id = ?", id); err != nil {
+			return err
+		}
+		return restoreWith[model.TeamB](tx, at, "tournament_id = ?", id)
+	})
+	if err != nil {
+		return model.Tournament{}, err
+	}
+	return r.ByID(id)
+}
+
+type gormTeams struct{ db *gorm.DB }
+
+func (r gormTeams) CreateLeagueTeam(team *model.Team) error {
+	return r.db.Create(team).Error
+}
+
+func (r gormTeams) CreateTeamA(team *model.TeamA) error {
+	return r.db.Create(team).Error
+}
+
+func (r gormTeams) CreateTeamB(team *model.TeamB) error {
+	return r.db.Create(team).Error
+}
+
+func (r gormTeams) LeagueTeamNameTaken(name string) (bool, error) {
+	return exists(r.db, &model.Team{}, "name = ?", name)
+}
+
+func (r gormTeams) TeamANameTaken(name string) (bool, error) {
+	return exists(r.db, &model.TeamA{}, "name = ?", name)
+}
+
+func (r gormTeams) TeamBNameTaken(name string) (bool, error) {
+	return exists(r.db, &model.TeamB{}, "name = ?", name)
+}
+
+func (r gormTeams) LeagueTeam(id uint) (model.Team, error) {
+	return findLive[model.Team](r.db, id)
+}
+
+func (r gormTeams) TeamA(id uint) (model.TeamA, error) {
+	return findLive[model.TeamA](r.db, id)
+}
+
+func (r gormTeams) TeamB(id uint) (model.TeamB, error) {
+	return findLive[model.TeamB](r.db, id)
+}
+
+func (r gormTeams) UpdateLeagueTeam(id, version uint, fields map[string]any) error {
+	return updateVersioned[model.Team](r.db, id, version, fields)
+}
+
+func (r gormTeams) UpdateTeamA(id, version uint, fields map[string]any) error {
+	return updateVersioned[model.TeamA](r.db, id, version, fields)
+}
+
+func (r gormTeams) UpdateTeamB(id, version uint, fields map[string]any) error {
+	return updateVersioned[model.TeamB](r.db, id, version, fields)
+}
+
+func (r gormTeams) DeleteLeagueTeam(id, version uint) error {
+	return deleteVersioned[model.Team](r.db, deletionTime(), id, version)
+}
+
+func (r gormTeams) DeleteTeamA(id, version uint) error {
+	return deleteVersioned[model.TeamA](r.db, deletionTime(), id, version)
+}
+
+func (r gormTeams) DeleteTeamB(id, version uint) error {
+	return deleteVersioned[model.TeamB](r.db, deletionTime(), id, version)
+}
+
+func (r gormTeams) RestoreLeagueTeam(id uint) (model.Team, error) {
+	return restoreTeam(r.db, id, func(team model.Team) error {
+		return parentLive[model.League](r.db, team.LeagueID)
+	})
+}
+
+func (r gormTeams) RestoreTeamA(id uint) (model.TeamA, error) {
+	return restoreTeam(r.db, id, func(team model.TeamA) error {
+		return parentLive[model.Tournament](r.db, team.TournamentID)
+	})
+}
+
+func (r gormTeams) RestoreTeamB(id uint) (model.TeamB, error) {
+	return restoreTeam(r.db, id, func(team model.TeamB) error {
+		return parentLive[model.Tournament](r.db, team.TournamentID)
+	})
+}
+
+// restoreTeam restores a team if its name is free and parent, which checks
+// the competition, allows it.
+func restoreTeam[T any](db *gorm.DB, id uint, parent func(team T) error) (T, error) {
+	check := func(team T) error {
+		if err := parent(team); err != nil {
+			return err
+		}
+		return nameFree(db, team)
+	}
+	if _, err := restore(db, id, check); err != nil {
+		var zero T
+		return zero, err
+	}
+	return findLive[T](db, id)
+}
+
+type gormOTPs struct{ db *gorm.DB }
+
+// errOTPInserted is returned by update when another Update inserted the OTP
+// after it found none.
+var errOTPInserted = errors.New("otp inserted concurrently")
+
+// Update locks the row for the duration of a transaction. While there is no
+// row two Updates can both find none; the one whose insert conflicts then runs
+// again on the row of the other.
+func (r gormOTPs) Update(email, purpose string, fn func(current *model.OTP) (*model.OTP, error)) error {
+	err := r.update(email, purpose, fn)
+	if errors.Is(err, errOTPInserted) {
+		return r.update(email, purpose, fn)
+	}
+	return err
+}
+
+func (r gormOTPs) update(email, purpose string, fn func(current *model.OTP) (*model.OTP, error)) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var row model.OTP
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("email = ? AND purpose = ?", email, purpose).First(&row).Error
+		var current *model.OTP
+		if err == nil {
+			current = &row
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			if current == nil {
+				return nil
+			}
+			return tx.Unscoped().Delete(current).Error
+		}
+		next.Email, next.Purpose = email, purpose
+		if current == nil {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(next)
+			if result.Error == nil && result.RowsAffected == 0 {
+				return errOTPInserted
+			}
+			return result.Error
+		}
+		next.Model = current.Model
+		return tx.Save(next).Error
+	})
+}
+
+func (r gormOTPs) Delete(email, purpose string) error {
+	return r.db.Unscoped().Where("email = ? AND purpose = ?", email, purpose).Delete(&model.OTP{}).Error
+}
+
+type gormSessions struct{ db *gorm.DB }
+
+func (r gormSessions) Create(session *model.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r gormSessions) ByJTI(userID uint, jti string) (model.Session, error) {
+	var session model.Session
+	err := r.db.Where("jti = ? AND user_id = ?", jti, userID).First(&session).Error
+	return session, notFound(err)
+}
+
+func (r gormSessions) ByID(userID, id uint) (model.Session, error) {
+	var session model.Session
+	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&session).Error
+	return session, notFound(err)
+}
+
+func (r gormSessions) List(userID uint) ([]model.Session, error) {
+	var sessions []model.Session
+	err := r.db.Where("user_id = ?", userID).Order("created_at desc, id desc").Find(&sessions).Error
+	return sessions, err
+}
+
+func (r gormSessions) Active(userID uint, now time.Time) ([]model.Session, error) {
+	var sessions []model.Session
+	err := r.db.Where("user_id = ? AND expires_at > ?", userID, now).
+		Order("created_at desc, id desc").Find(&sessions).Error
+	return sessions, err
+}
+
+func (r gormSessions) Revoke(session model.Session) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return revokeSessions(tx, []model.Session{session})
+	})
+}
+
+func (r gormSessions) RevokeAll(userID uint, keepJTI string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var sessions []model.Session
+		if err := tx.Where("user_id = ? AND jti <> ?", userID, keepJTI).Find(&sessions).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, sessions)
+	})
+}
+
+func (r gormSessions) IsRevoked(jti string) (bool, error) {
+	return exists(r.db, &model.RevokedToken{}, "jti = ?", jti)
+}
+
+func (r gormSessions) DeleteExpired(now time.Time) error {
+	if err := r.db.Where("expires_at <= ?", now).Delete(&model.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("expires_at <= ?", now).Delete(&model.Session{}).Error
+}
+
+// revokeSessions stores a revocation for each session and deletes the session rows.
+func revokeSessions(tx *gorm.DB, sessions []model.Session) error {
+	for _, session := range sessions {
+		revoked := model.RevokedToken{
+			JTI:       session.JTI,
+			UserID:    session.UserID,
+			ExpiresAt: session.ExpiresAt,
+		}
+		if err := tx.Save(&revoked).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&model.Session{}, session.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type gormAPIKeys struct{ db *gorm.DB }
+
+func (r gormAPIKeys) Create(key *model.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r gormAPIKeys) ByPrefix(prefix string) (model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.Where("prefix = ?", prefix).First(&key).Error
+	return key, notFound(err)
+}
+
+func (r gormAPIKeys) List(userID uint) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	err := r.db.Where("user_id = ?", userID).Order("created_at desc, id desc").Find(&keys).Error
+	return keys, err
+}
+
+func (r gormAPIKeys) CountActive(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r gormAPIKeys) Revoke(userID, id uint, at time.Time) error {
+	result := r.db.Model(&model.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", at)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func (r gormAPIKeys) RevokeAll(userID uint, at time.Time) error {
+	return r.db.Model(&model.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+func (r gormAPIKeys) MarkUsed(id uint, at time.Time) error {
+	return r.db.Model(&model.APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
+
+type gormIdentities struct{ db *gorm.DB }
+
+func (r gormIdentities) SaveLoginState(state model.OIDCLoginState) error {
+	return r.db.Create(&state).Error
+}
+
+func (r gormIdentities) TakeLoginState(provider, state string) (model.OIDCLoginState, error) {
+	var row model.OIDCLoginState
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state = ? AND provider = ?", state, provider).First(&row).Error; err != nil {
+			return err
+		}
+		// Of two callbacks with the same state, only the one that deletes it wins
+		result := tx.Delete(&row)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+	return row, notFound(err)
+}
+
+func (r gormIdentities) ByProviderSubject(provider, subject string) (model.UserIdentity, error) {
+	var identity model.UserIdentity
+	err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	return identity, notFound(err)
+}
+
+func (r gormIdentities) List(userID uint) ([]model.UserIdentity, error) {
+	var identities []model.UserIdentity
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+func (r gormIdentities) Link(identity *model.UserIdentity, user *model.User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if user != nil {
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+			identity.UserID = user.UserID
+		}
+		taken, err := exists(tx, &model.UserIdentity{}, "provider = ? AND subject = ?", identity.Provider, identity.Subject)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicate
+		}
+		return tx.Create(identity).Error
+	})
+}
+
+func (r gormIdentities) Unlink(userID uint, provider string, keepLast bool) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var identity model.UserIdentity
+		if err := tx.Where("user_id = ? AND provider = ?", userID, provider).First(&identity).Error; err != nil {
+			return notFound(err)
+		}
+		if keepLast {
+			others, err := exists(tx, &model.UserIdentity{}, "user_id = ? AND id <> ?", userID, identity.ID)
+			if err != nil {
+				return err
+			}
+			if !others {
+				return ErrLastIdentity
+			}
+		}
+		return tx.Delete(&identity).Error
+	})
+}
+
+type gormAccounts struct{ db *gorm.DB }
+
+func (r gormAccounts) ScheduleDeletion(userID uint, at time.Time) error {
+	result := r.db.Model(&model.User{}).
+		Where("user_id = ? AND deletion_scheduled_at IS NULL AND anonymized_at IS NULL", userID).
+		Update("deletion_scheduled_at", at)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func (r gormAccounts) CancelDeletion(userID uint) error {
+	result := r.db.Model(&model.User{}).
+		Where("user_id = ? AND deletion_scheduled_at IS NOT NULL AND anonymized_at IS NULL", userID).
+		Update("deletion_scheduled_at", nil)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func (r gormAccounts) DueForDeletion(now time.Time) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&model.User{}).
+		Where("deletion_scheduled_at <= ? AND anonymized_at IS NULL", now).
+		Order("user_id").Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+func (r gormAccounts) Anonymize(userID uint, now time.Time) (model.User, error) {
+	var user model.User
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.AnonymizedAt != nil {
+			return nil
+		}
+		// The password stays empty, so no login can match it
+		if err := tx.Model(&model.User{}).Where("user_id = ?", userID).Updates(map[string]any{
+			"name":           "Deleted user",
+			"email":          anonymousEmail(userID),
+			"phone":          "",
+			"phone_verified": false,
+			"pending_email":  "",
+			"pending_phone":  "",
+			"password":       "",
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+			"anonymized_at":  now,
+		}).Error; err != nil {
+			return err
+		}
+		for _, m := range []any{&model.UserIdentity{}, &model.RecoveryCode{}, &model.APIKey{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		var sessions []model.Session
+		if err := tx.Where("user_id = ?", userID).Find(&sessions).Error; err != nil {
+			return err
+		}
+		if err := revokeSessions(tx, sessions); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("email IN ?", recipients(user)).Delete(&model.OTP{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where(map[string]any{"to": recipients(user)}).Delete(&model.OutboxEmail{}).Error; err != nil {
+			return err
+		}
+		if err := scrubAuditEvents(tx, user); err != nil {
+			return err
+		}
+		return tx.Where("email = ?", user.Email).Delete(&model.PendingSignup{}).Error
+	})
+	return user, notFound(err)
+}
+
+// scrubAuditEvents removes the emails and phone numbers of the user from the
+// audit events that mention the user. The events themselves are kept.
+func scrubAuditEvents(tx *gorm.DB, user model.User) error {
+	scrubber := newAuditScrubber(user)
+	var events []model.AuditEvent
+	err := tx.Where("actor_id = ? OR subject_id = ? OR LOWER(target_id) IN ?", user.UserID, user.UserID, scrubber.contacts).
+		Find(&events).Error
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		fields := scrubber.scrub(event)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := tx.Model(&model.AuditEvent{}).Where("id = ?", event.ID).Updates(fields).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contactFields are the snapshot fields that hold an email or phone number,
+// and whether they hold an email.
+var contactFields = map[string]bool{"email": true, "pending_email": true, "phone": false, "pending_phone": false}
+
+// auditScrubber replaces the emails and phone numbers of an anonymized user
+// with the placeholders the user row got.
+type auditScrubber struct {
+	user         model.User
+	contacts     []string          // the current emails and numbers, in lower case
+	placeholders map[string]string // by contact
+	pattern      *regexp.Regexp    // matches the contacts in any case
+}
+
+func newAuditScrubber(user model.User) auditScrubber {
+	s := auditScrubber{user: user, placeholders: map[string]string{}}
+	for _, contact := range recipients(user) {
+		contact = strings.ToLower(contact)
+		s.placeholders[contact] = ""
+		if strings.Contains(contact, "@") {
+			s.placeholders[contact] = anonymousEmail(user.UserID)
+		}
+	}
+	for contact := range s.placeholders {
+		s.contacts = append(s.contacts, contact)
+	}
+	// Longest first, so that a number is not replaced by a prefix of it
+	sort.Slice(s.contacts, func(i, j int) bool { return len(s.contacts[i]) > len(s.contacts[j]) })
+	quoted := make([]string, len(s.contacts))
+	for i, contact := range s.contacts {
+		quoted[i] = regexp.QuoteMeta(contact)
+	}
+	s.pattern = regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	return s
+}
+
+// scrub returns the columns of the event to update. Events about the user
+// also lose earlier emails and numbers: every contact field in the snapshots
+// is replaced, and so is an email as the target. Events where the user only
+// acted on something else keep the data of that, minus the user's contacts.
+func (s auditScrubber) scrub(event model.AuditEvent) map[string]any {
+	_, byContact := s.placeholders[strings.ToLower(event.TargetID)]
+	about := event.SubjectID == s.user.UserID || byContact ||
+		(event.ActorID == s.user.UserID && event.SubjectID == 0 && (event.TargetType == "" || event.TargetType == "user"))
+
+	fields := map[string]any{}
+	targetID := s.text(event.TargetID)
+	if about && event.TargetType == "user" && strings.Contains(targetID, "@") {
+		targetID = anonymousEmail(s.user.UserID)
+	}
+	if targetID != event.TargetID {
+		fields["target_id"] = targetID
+	}
+	for column, value := range map[string]string{"before": event.Before, "after": event.After, "details": event.Details} {
+		if scrubbed := s.json(value, about); scrubbed != value {
+			fields[column] = scrubbed
+		}
+	}
+	return fields
+}
+
+// text replaces the user's contacts in s.
+func (s auditScrubber) text(text string) string {
+	if len(s.contacts) == 0 {
+		return text
+	}
+	return s.pattern.ReplaceAllStringFunc(text, func(match string) string { return s.placeholders[strings.ToLower(match)] })
+}
+
+// json scrubs a JSON snapshot, or the text of anything else.
+func (s auditScrubber) json(data string, about bool) string {
+	var value any
+	if data == "" || json.Unmarshal([]byte(data), &value) != nil {
+		return s.text(data)
+	}
+	// Compared re-encoded, so that an event without personal data is left as is
+	original, err := json.Marshal(value)
+	if err != nil {
+		return s.text(data)
+	}
+	scrubbed, err := json.Marshal(s.value(value, about))
+	if err != nil || bytes.Equal(scrubbed, original) {
+		return data
+	}
+	return string(scrubbed)
+}
+
+// value scrubs a decoded JSON value in place and returns it.
+func (s auditScrubber) value(value any, about bool) any {
+	switch v := value.(type) {
+	case string:
+		return s.text(v)
+	case []any:
+		for i := range v {
+			v[i] = s.value(v[i], about)
+		}
+	case map[string]any:
+		for key, field := range v {
+			isEmail, contact := contactFields[strings.ToLower(key)]
+			if str, ok := field.(string); ok && about && contact && str != "" {
+				v[key] = ""
+				if isEmail {
+					v[key] = anonymousEmail(s.user.UserID)
+				}
+				continue
+			}
+			v[key] = s.value(field, about)
+		}
+	}
+	return value
+}
+
+// anonymousEmail is the placeholder email of an anonymized user. It is
+// unique, as emails have to be, and cannot receive mail.
+func anonymousEmail(userID uint) string {
+	return fmt.Sprintf("deleted-%d@deleted.invalid", userID)
+}
+
+// recipients returns the addresses and numbers of the user that codes may
+// have been sent to. OTPs are keyed by them.
+func recipients(user model.User) []string {
+	var r []string
+	for _, recipient := range []string{user.Email, user.PendingEmail, user.Phone, user.PendingPhone} {
+		if recipient != "" {
+			r = append(r, recipient)
+		}
+	}
+	return r
+}
+
+func (r gormAccounts) PersonalData(userID uint) (PersonalData, error) {
+	var data PersonalData
+	if err := r.db.First(&data.User, userID).Error; err != nil {
+		return data, notFound(err)
+	}
+	for _, dest := range []any{&data.Identities, &data.Sessions, &data.APIKeys} {
+		if err := r.db.Where("user_id = ?", userID).Order("id").Find(dest).Error; err != nil {
+			return data, err
+		}
+	}
+	for _, dest := range []any{&data.LeagueTeams, &data.TeamsA, &data.TeamsB} {
+		if err := r.db.Where("player_id = ?", userID).Order("id").Find(dest).Error; err != nil {
+			return data, err
+		}
+	}
+
+	var leagueIDs, tournamentIDs []uint
+	for _, team := range data.LeagueTeams {
+		leagueIDs = append(leagueIDs, team.LeagueID)
+	}
+	for _, team := range data.TeamsA {
+		tournamentIDs = append(tournamentIDs, team.TournamentID)
+	}
+	for _, team := range data.TeamsB {
+		tournamentIDs = append(tournamentIDs, team.TournamentID)
+	}
+	if len(leagueIDs) > 0 {
+		if err := r.db.Where("id IN ?", leagueIDs).Order("id").Find(&data.Leagues).Error; err != nil {
+			return data, err
+		}
+	}
+	if len(tournamentIDs) > 0 {
+		if err := r.db.Where("id IN ?", tournamentIDs).Order("id").Find(&data.Tournaments).Error; err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
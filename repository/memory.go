@@ -0,0 +1,1270 @@
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"gaming/model"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Memory holds the data of the in-memory repositories. All repositories
+// returned by NewMemory share one Memory, so that e.g. a league lists the
+// teams created through the team repository.
+type Memory struct {
+	mu            sync.Mutex
+	nextID        uint
+	users         map[uint]model.User
+	pending       map[string]model.PendingSignup
+	recoveryCodes map[uint][]model.RecoveryCode
+	leagues       map[uint]model.League
+	tournaments   map[uint]model.Tournament
+	teams         map[uint]model.Team
+	teamsA        map[uint]model.TeamA
+	teamsB        map[uint]model.TeamB
+	otps          map[string]model.OTP
+	sessions      map[uint]model.Session
+	revoked       map[string]model.RevokedToken
+	apiKeys       map[uint]model.APIKey
+	identities    map[uint]model.UserIdentity
+	loginStates   map[string]model.OIDCLoginState
+}
+
+// NewMemory returns empty in-memory repositories.
+func NewMemory() Repositories {
+	m := &Memory{
+		users:         make(map[uint]model.User),
+		pending:       make(map[string]model.PendingSignup),
+		recoveryCodes: make(map[uint][]model.RecoveryCode),
+		leagues:       make(map[uint]model.League),
+		tournaments:   make(map[uint]model.Tournament),
+		teams:         make(map[uint]model.Team),
+		teamsA:        make(map[uint]model.TeamA),
+		teamsB:        make(map[uint]model.TeamB),
+		otps:          make(map[string]model.OTP),
+		sessions:      make(map[uint]model.Session),
+		revoked:       make(map[string]model.RevokedToken),
+		apiKeys:       make(map[uint]model.APIKey),
+		identities:    make(map[uint]model.UserIdentity),
+		loginStates:   make(map[string]model.OIDCLoginState),
+	}
+	return Repositories{
+		Users:       memoryUsers{m},
+		Leagues:     memoryLeagues{m},
+		Tournaments: memoryTournaments{m},
+		Teams:       memoryTeams{m},
+		OTPs:        memoryOTPs{m},
+		Sessions:    memorySessions{m},
+		APIKeys:     memoryAPIKeys{m},
+		Identities:  memoryIdentities{m},
+		Accounts:    memoryAccounts{m},
+	}
+}
+
+// id returns the next ID. IDs are unique across all tables, which is enough for a fake.
+func (m *Memory) id() uint {
+	m.nextID++
+	return m.nextID
+}
+
+// sortedKeys returns the keys of a map in ascending order, so listings are stable.
+func sortedKeys[V any](items map[uint]V) []uint {
+	keys := make([]uint, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+type memoryUsers struct{ *Memory }
+
+func (r memoryUsers) ByID(id uint) (model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return model.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) ByEmail(email string) (model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byEmail(email)
+}
+
+func (r memoryUsers) byEmail(email string) (model.User, error) {
+	found := model.User{}
+	for _, user := range r.users {
+		if strings.EqualFold(user.Email, email) && (found.UserID == 0 || user.UserID < found.UserID) {
+			found = user
+		}
+	}
+	if found.UserID == 0 {
+		return model.User{}, ErrNotFound
+	}
+	return found, nil
+}
+
+func (r memoryUsers) EmailTaken(email string) (bool, error) {
+	_, err := r.ByEmail(email)
+	return err == nil, nil
+}
+
+func (r memoryUsers) Create(user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.create(user)
+}
+
+func (r memoryUsers) create(user *model.User) error {
+	if _, err := r.byEmail(user.Email); err == nil {
+		return ErrDuplicate
+	}
+	if user.UserID == 0 {
+		user.UserID = r.id()
+	}
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	r.users[user.UserID] = *user
+	return nil
+}
+
+func (r memoryUsers) Update(id uint, fields map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil // like an UPDATE matching no rows
+	}
+	if err := setColumns(&user, fields); err != nil {
+		return err
+	}
+	r.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) ChangeEmail(id uint, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if other, err := r.byEmail(email); err == nil && other.UserID != id {
+		return ErrDuplicate
+	}
+	if user, ok := r.users[id]; ok {
+		user.Email, user.PendingEmail = email, ""
+		r.users[id] = user
+	}
+	return nil
+}
+
+func (r memoryUsers) SavePendingSignup(pending model.PendingSignup) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if existing, ok := r.pending[pending.Email]; ok {
+		pending.CreatedAt = existing.CreatedAt
+	} else {
+		pending.CreatedAt = now
+	}
+	pending.UpdatedAt = now
+	r.pending[pending.Email] = pending
+	return nil
+}
+
+func (r memoryUsers) CompleteSignup(email string) (model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending, ok := r.pending[email]
+	if !ok {
+		return model.User{}, ErrNotFound
+	}
+	user := model.User{
+		Name:     pending.Name,
+		Email:    pending.Email,
+		Phone:    pending.Phone,
+		Password: pending.Password,
+	}
+	if err := r.create(&user); err != nil {
+		return model.User{}, err
+	}
+	delete(r.pending, email)
+	return user, nil
+}
+
+func (r memoryUsers) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	r.users[id] = user
+	return true, nil
+}
+
+func (r memoryUsers) EnableTOTP(id uint, step int64, recoveryCodeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+	user.TOTPEnabled, user.TOTPLastStep = true, step
+	r.users[id] = user
+	codes := make([]model.RecoveryCode, len(recoveryCodeHashes))
+	for i, hash := range recoveryCodeHashes {
+		codes[i] = model.RecoveryCode{ID: r.id(), UserID: id, CodeHash: hash}
+	}
+	r.recoveryCodes[id] = codes
+	return nil
+}
+
+func (r memoryUsers) DisableTOTP(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if user, ok := r.users[id]; ok {
+		user.TOTPEnabled, user.TOTPSecret, user.TOTPLastStep = false, "", 0
+		r.users[id] = user
+	}
+	delete(r.recoveryCodes, id)
+	return nil
+}
+
+func (r memoryUsers) UseRecoveryCode(id uint, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, code := range r.recoveryCodes[id] {
+		if code.CodeHash == codeHash && code.UsedAt == nil {
+			now := time.Now()
+			r.recoveryCodes[id][i].UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setColumns sets the struct fields named by the column names, using the same
+// naming as GORM.
+func setColumns(dest any, fields map[string]any) error {
+	v := reflect.ValueOf(dest).Elem()
+	naming := schema.NamingStrategy{}
+	columns := make(map[string]reflect.Value, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		columns[naming.ColumnName("", v.Type().Field(i).Name)] = v.Field(i)
+	}
+	for column, value := range fields {
+		field, ok := columns[column]
+		if !ok {
+			return fmt.Errorf("unknown column %q", column)
+		}
+		if value == nil {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		rv := reflect.ValueOf(value)
+		switch {
+		case rv.Type().AssignableTo(field.Type()):
+			field.Set(rv)
+		case field.Kind() == reflect.Pointer && rv.Type().AssignableTo(field.Type().Elem()):
+			ptr := reflect.New(field.Type().Elem())
+			ptr.Elem().Set(rv)
+			field.Set(ptr)
+		case rv.Type().ConvertibleTo(field.Type()):
+			field.Set(rv.Convert(field.Type()))
+		default:
+			return fmt.Errorf("cannot set column %q to %T", column, value)
+		}
+	}
+	return nil
+}
+
+// findLiveIn returns the row with the id unless it is missing or deleted.
+func findLiveIn[V any](rows map[uint]V, id uint) (V, error) {
+	row, ok := rows[id]
+	if !ok || deletedAt(row).Valid {
+		var zero V
+		return zero, ErrNotFound
+	}
+	return row, nil
+}
+
+// updateVersionedIn sets columns of the live row with the id and increments
+// its version, or returns ErrVersionConflict when the row is no longer at
+// version.
+func updateVersionedIn[V any](rows map[uint]V, id, version uint, fields map[string]any) error {
+	row, err := findLiveIn(rows, id)
+	if err != nil {
+		return err
+	}
+	if uint(reflect.ValueOf(row).FieldByName("Version").Uint()) != version {
+		return ErrVersionConflict
+	}
+	updates := maps.Clone(fields)
+	updates["version"] = version + 1
+	return updateLiveIn(rows, id, updates)
+}
+
+// deleteVersionedIn soft deletes the live row with the id, or returns
+// ErrVersionConflict when the row is no longer at version.
+func deleteVersionedIn[V any](rows map[uint]V, id, version uint) error {
+	row, err := findLiveIn(rows, id)
+	if err != nil {
+		return err
+	}
+	if uint(reflect.ValueOf(row).FieldByName("Version").Uint()) != version {
+		return ErrVersionConflict
+	}
+	return updateLiveIn(rows, id, deletedNow())
+}
+
+// updateLiveIn sets columns of the row with the id unless it is deleted.
+func updateLiveIn[V any](rows map[uint]V, id uint, fields map[string]any) error {
+	row, err := findLiveIn(rows, id)
+	if err != nil {
+		return err
+	}
+	if err := setColumns(&row, fields); err != nil {
+		return err
+	}
+	rows[id] = row
+	return nil
+}
+
+// softDeleteIn marks the live rows for which match returns true as deleted at.
+func softDeleteIn[V any](rows map[uint]V, at time.Time, match func(V) bool) {
+	for id, row := range rows {
+		if match(row) && !deletedAt(row).Valid {
+			setColumns(&row, map[string]any{"deleted_at": gorm.DeletedAt{Time: at, Valid: true}})
+			rows[id] = row
+		}
+	}
+}
+
+// restoreIn clears the deletion of the rows for which match returns true
+// that were deleted at or after at.
+func restoreIn[V any](rows map[uint]V, at time.Time, match func(V) bool) {
+	for id, row := range rows {
+		if deleted := deletedAt(row); match(row) && deleted.Valid && !deleted.Time.Before(at) {
+			setColumns(&row, map[string]any{"deleted_at": nil})
+			rows[id] = row
+		}
+	}
+}
+
+// restoreRowIn clears the deletion of the row with the id and returns it with
+// the time it had been deleted, or ErrNotFound when no deleted row has the
+// id. It returns ErrDuplicate when a live row has the same name, and the
+// error of check when that refuses to restore the row.
+func restoreRowIn[V any](rows map[uint]V, id uint, check func(row V) error) (V, time.Time, error) {
+	var zero V
+	row, ok := rows[id]
+	if !ok || !deletedAt(row).Valid {
+		return zero, time.Time{}, ErrNotFound
+	}
+	if err := check(row); err != nil {
+		return zero, time.Time{}, err
+	}
+	name := reflect.ValueOf(row).FieldByName("Name").String()
+	for otherID, other := range rows {
+		if otherID != id && !deletedAt(other).Valid && reflect.ValueOf(other).FieldByName("Name").String() == name {
+			return zero, time.Time{}, ErrDuplicate
+		}
+	}
+	at := deletedAt(row).Time
+	setColumns(&row, map[string]any{"deleted_at": nil})
+	rows[id] = row
+	return row, at, nil
+}
+
+// pageIn returns a page of the live competitions in rows that match opts, like
+// listCompetitions does in SQL. joined reports whether opts.PlayerID has a
+// team in a competition.
+func pageIn[V any](rows map[uint]V, opts ListOptions, joined func(row V) bool) (Page[V], error) {
+	column := opts.sortColumn()
+	field, ok := sortFields[column]
+	if !ok {
+		return Page[V]{}, fmt.Errorf("cannot sort competitions by %q", column)
+	}
+
+	var matches []V
+	for _, id := range sortedKeys(rows) {
+		row := rows[id]
+		v := reflect.ValueOf(row)
+		start := v.FieldByName("StartTime").Interface().(time.Time)
+		prizePool := v.FieldByName("PrizePool").Float()
+		switch {
+		case deletedAt(row).Valid,
+			opts.Status == StatusUpcoming && !start.After(opts.Now),
+			opts.Status == StatusStarted && start.After(opts.Now),
+			!opts.StartsAfter.IsZero() && !start.After(opts.StartsAfter),
+			!opts.StartsBefore.IsZero() && !start.Before(opts.StartsBefore),
+			opts.MinPrizePool != nil && prizePool < *opts.MinPrizePool,
+			opts.MaxPrizePool != nil && prizePool > *opts.MaxPrizePool,
+			opts.Game != "" && v.FieldByName("Game").String() != opts.Game,
+			opts.PlayerID != 0 && !joined(row):
+			continue
+		}
+		matches = append(matches, row)
+	}
+
+	// position orders a row against a cursor, negated when descending
+	position := func(row V, at Cursor) int {
+		v := reflect.ValueOf(row)
+		order := 0
+		if column != SortID {
+			order = compareSortValues(v.FieldByName(field).Interface(), at.Value)
+		}
+		if order == 0 {
+			order = cmp.Compare(uint(v.FieldByName("ID").Uint()), at.ID)
+		}
+		if opts.Desc {
+			return -order
+		}
+		return order
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		v := reflect.ValueOf(matches[j])
+		return position(matches[i], Cursor{Value: v.FieldByName(field).Interface(), ID: uint(v.FieldByName("ID").Uint())}) < 0
+	})
+
+	switch {
+	case opts.After != nil:
+		after := sort.Search(len(matches), func(i int) bool { return position(matches[i], *opts.After) > 0 })
+		matches = matches[after:]
+	case opts.Offset >= len(matches):
+		matches = nil
+	default:
+		matches = matches[max(opts.Offset, 0):]
+	}
+	limit := opts.limit()
+	return pageOf(matches[:min(len(matches), limit+1)], limit, column), nil
+}
+
+// compareSortValues orders two values of a sort column.
+func compareSortValues(a, b any) int {
+	switch a := a.(type) {
+	case time.Time:
+		b, _ := b.(time.Time)
+		return a.Compare(b)
+	case float64:
+		b, _ := b.(float64)
+		return cmp.Compare(a, b)
+	case string:
+		b, _ := b.(string)
+		return strings.Compare(a, b)
+	}
+	return 0
+}
+
+// deletedNow is the columns that soft delete a row.
+func deletedNow() map[string]any {
+	return map[string]any{"deleted_at": gorm.DeletedAt{Time: time.Now(), Valid: true}}
+}
+
+type memoryLeagues struct{ *Memory }
+
+func (r memoryLeagues) Create(league *model.League) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	league.ID, league.Version = r.id(), 1
+	stored := *league
+	stored.Teams = nil // teams are stored by the team repository
+	r.leagues[league.ID] = stored
+	return nil
+}
+
+func (r memoryLeagues) ByID(id uint) (model.League, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	league, err := findLiveIn(r.leagues, id)
+	if err != nil {
+		return model.League{}, err
+	}
+	return r.withTeams(league), nil
+}
+
+func (r memoryLeagues) NameTaken(name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, league := range r.leagues {
+		if league.Name == name && !league.DeletedAt.Valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryLeagues) List(opts ListOptions) (Page[model.League], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	page, err := pageIn(r.leagues, opts, func(league model.League) bool {
+		for _, team := range r.teams {
+			if team.LeagueID == league.ID && team.PlayerID == opts.PlayerID && !team.DeletedAt.Valid {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil || !opts.WithTeams {
+		return page, err
+	}
+	for i, league := range page.Items {
+		page.Items[i] = r.withTeams(league)
+	}
+	return page, nil
+}
+
+func (r memoryLeagues) withTeams(league model.League) model.League {
+	for _, id := range sortedKeys(r.teams) {
+		if team := r.teams[id]; team.LeagueID == league.ID && !team.DeletedAt.Valid {
+			league.Teams = append(league.Teams, team)
+		}
+	}
+	return league
+}
+
+func (r memoryLeagues) Update(id, version uint, fields map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return updateVersionedIn(r.leagues, id, version, fields)
+}
+
+func (r memoryLeagues) Delete(id, version uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := deleteVersionedIn(r.leagues, id, version); err != nil {
+		return err
+	}
+	softDeleteIn(r.teams, r.leagues[id].DeletedAt.Time, func(t model.Team) bool { return t.LeagueID == id })
+	return nil
+}
+
+func (r memoryLeagues) Restore(id uint) (model.League, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	league, at, err := restoreRowIn(r.leagues, id, noCheck[model.League])
+	if err != nil {
+		return model.League{}, err
+	}
+	restoreIn(r.teams, at, func(t model.Team) bool { return t.LeagueID == id })
+	return r.withTeams(league), nil
+}
+
+type memoryTournaments struct{ *Memory }
+
+func (r memoryTournaments) Create(tournament *model.Tournament) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tournament.ID, tournament.Version = r.id(), 1
+	stored := *tournament
+	stored.TeamA, stored.TeamB = nil, nil
+	r.tournaments[tournament.ID] = stored
+	return nil
+}
+
+func (r memoryTournaments) ByID(id uint) (model.Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tournament, err := findLiveIn(r.tournaments, id)
+	if err != nil {
+		return model.Tournament{}, err
+	}
+	return r.withTeams(tournament), nil
+}
+
+func (r memoryTournaments) NameTaken(name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tournament := range r.tournaments {
+		if tournament.Name == name && !tournament.DeletedAt.Valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryTournaments) List(opts ListOptions) (Page[model.Tournament], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	page, err := pageIn(r.tournaments, opts, func(tournament model.Tournament) bool {
+		for _, team := range r.teamsA {
+			if team.TournamentID == tournament.ID && team.PlayerID == opts.PlayerID && !team.DeletedAt.Valid {
+				return true
+			}
+		}
+		for _, team := range r.teamsB {
+			if team.TournamentID == tournament.ID && team.PlayerID == opts.PlayerID && !team.DeletedAt.Valid {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil || !opts.WithTeams {
+		return page, err
+	}
+	for i, tournament := range page.Items {
+		page.Items[i] = r.withTeams(tournament)
+	}
+	return page, nil
+}
+
+func (r memoryTournaments) withTeams(tournament model.Tournament) model.Tournament {
+	for _, id := range sortedKeys(r.teamsA) {
+		if team := r.teamsA[id]; team.TournamentID == tournament.ID && !team.DeletedAt.Valid {
+			tournament.TeamA = append(tournament.TeamA, team)
+		}
+	}
+	for _, id := range sortedKeys(r.teamsB) {
+		if team := r.teamsB[id]; team.TournamentID == tournament.ID && !team.DeletedAt.Valid {
+			tournament.TeamB = append(tournament.TeamB, team)
+		}
+	}
+	return tournament
+}
+
+func (r memoryTournaments) Update(id, version uint, fields map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return updateVersionedIn(r.tournaments, id, version, fields)
+}
+
+func (r memoryTournaments) Delete(id, version uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := deleteVersionedIn(r.tournaments, id, version); err != nil {
+		return err
+	}
+	at := r.tournaments[id].DeletedAt.Time
+	softDeleteIn(r.teamsA, at, func(t model.TeamA) bool { return t.TournamentID == id })
+	softDeleteIn(r.teamsB, at, func(t model.TeamB) bool { return t.TournamentID == id })
+	return nil
+}
+
+func (r memoryTournaments) Restore(id uint) (model.Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tournament, at, err := restoreRowIn(r.tournaments, id, noCheck[model.Tournament])
+	if err != nil {
+		return model.Tournament{}, err
+	}
+	restoreIn(r.teamsA, at, func(t model.TeamA) bool { return t.TournamentID == id })
+	restoreIn(r.teamsB, at, func(t model.TeamB) bool { return t.TournamentID == id })
+	return r.withTeams(tournament), nil
+}
+
+type memoryTeams struct{ *Memory }
+
+func (r memoryTeams) CreateLeagueTeam(team *model.Team) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	team.ID, team.Version = r.id(), 1
+	r.teams[team.ID] = *team
+	return nil
+}
+
+func (r memoryTeams) CreateTeamA(team *model.TeamA) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	team.ID, team.Version = r.id(), 1
+	r.teamsA[team.ID] = *team
+	return nil
+}
+
+func (r memoryTeams) CreateTeamB(team *model.TeamB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	team.ID, team.Version = r.id(), 1
+	r.teamsB[team.ID] = *team
+	return nil
+}
+
+func (r memoryTeams) LeagueTeamNameTaken(name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, team := range r.teams {
+		if team.Name == name && !team.DeletedAt.Valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryTeams) TeamANameTaken(name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, team := range r.teamsA {
+		if team.Name == name && !team.DeletedAt.Valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryTeams) TeamBNameTaken(name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, team := range r.teamsB {
+		if team.Name == name && !team.DeletedAt.Valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryTeams) LeagueTeam(id uint) (model.Team, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return findLiveIn(r.teams, id)
+}
+
+func (r memoryTeams) TeamA(id uint) (model.TeamA, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return findLiveIn(r.teamsA, id)
+}
+
+func (r memoryTeams) TeamB(id uint) (model.TeamB, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return findLiveIn(r.teamsB, id)
+}
+
+func (r memoryTeams) UpdateLeagueTeam(id, version uint, fields map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return updateVersionedIn(r.teams, id, version, fields)
+}
+
+func (r memoryTeams) UpdateTeamA(id, version uint, fields map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return updateVersionedIn(r.teamsA, id, version, fields)
+}
+
+func (r memoryTeams) UpdateTeamB(id, version uint, fields map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return updateVersionedIn(r.teamsB, id, version, fields)
+}
+
+func (r memoryTeams) DeleteLeagueTeam(id, version uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return deleteVersionedIn(r.teams, id, version)
+}
+
+func (r memoryTeams) DeleteTeamA(id, version uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return deleteVersionedIn(r.teamsA, id, version)
+}
+
+func (r memoryTeams) DeleteTeamB(id, version uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return deleteVersionedIn(r.teamsB, id, version)
+}
+
+func (r memoryTeams) RestoreLeagueTeam(id uint) (model.Team, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	team, _, err := restoreRowIn(r.teams, id, func(team model.Team) error {
+		return parentLiveIn(r.leagues, team.LeagueID)
+	})
+	return team, err
+}
+
+func (r memoryTeams) RestoreTeamA(id uint) (model.TeamA, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	team, _, err := restoreRowIn(r.teamsA, id, func(team model.TeamA) error {
+		return parentLiveIn(r.tournaments, team.TournamentID)
+	})
+	return team, err
+}
+
+func (r memoryTeams) RestoreTeamB(id uint) (model.TeamB, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	team, _, err := restoreRowIn(r.teamsB, id, func(team model.TeamB) error {
+		return parentLiveIn(r.tournaments, team.TournamentID)
+	})
+	return team, err
+}
+
+// noCheck lets restoreRowIn restore any row.
+func noCheck[V any](V) error { return nil }
+
+// parentLiveIn returns ErrParentDeleted unless a live row has the id.
+func parentLiveIn[V any](rows map[uint]V, id uint) error {
+	if _, err := findLiveIn(rows, id); err != nil {
+		return ErrParentDeleted
+	}
+	return nil
+}
+
+type memoryOTPs struct{ *Memory }
+
+// Update holds the lock while fn runs, so concurrent updates are serialized.
+func (r memoryOTPs) Update(email, purpose string, fn func(current *model.OTP) (*model.OTP, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := purpose + "|" + email
+	var current *model.OTP
+	if row, ok := r.otps[key]; ok {
+		current = &row
+	}
+	next, err := fn(current)
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		delete(r.otps, key)
+		return nil
+	}
+	next.Email, next.Purpose = email, purpose
+	r.otps[key] = *next
+	return nil
+}
+
+func (r memoryOTPs) Delete(email, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.otps, purpose+"|"+email)
+	return nil
+}
+
+// newestFirst sorts rows by creation, newest first, like the GORM listings.
+// created returns the creation time and ID of a row.
+func newestFirst[V any](rows []V, created func(V) (time.Time, uint)) {
+	sort.Slice(rows, func(i, j int) bool {
+		ti, idi := created(rows[i])
+		tj, idj := created(rows[j])
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return idi > idj
+	})
+}
+
+func sessionCreated(s model.Session) (time.Time, uint) { return s.CreatedAt, s.ID }
+
+type memorySessions struct{ *Memory }
+
+func (r memorySessions) Create(session *model.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session.ID = r.id()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	r.sessions[session.ID] = *session
+	return nil
+}
+
+func (r memorySessions) ByJTI(userID uint, jti string) (model.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, session := range r.sessions {
+		if session.JTI == jti && session.UserID == userID {
+			return session, nil
+		}
+	}
+	return model.Session{}, ErrNotFound
+}
+
+func (r memorySessions) ByID(userID, id uint) (model.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok || session.UserID != userID {
+		return model.Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r memorySessions) List(userID uint) ([]model.Session, error) {
+	return r.Active(userID, time.Time{})
+}
+
+func (r memorySessions) Active(userID uint, now time.Time) ([]model.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sessions []model.Session
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	newestFirst(sessions, sessionCreated)
+	return sessions, nil
+}
+
+func (r memorySessions) Revoke(session model.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoke(session)
+	return nil
+}
+
+func (r memorySessions) RevokeAll(userID uint, keepJTI string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.JTI != keepJTI {
+			r.revoke(session)
+		}
+	}
+	return nil
+}
+
+func (r memorySessions) revoke(session model.Session) {
+	r.revoked[session.JTI] = model.RevokedToken{JTI: session.JTI, UserID: session.UserID, ExpiresAt: session.ExpiresAt}
+	delete(r.sessions, session.ID)
+}
+
+func (r memorySessions) IsRevoked(jti string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.revoked[jti]
+	return ok, nil
+}
+
+func (r memorySessions) DeleteExpired(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for jti, revoked := range r.revoked {
+		if !revoked.ExpiresAt.After(now) {
+			delete(r.revoked, jti)
+		}
+	}
+	for id, session := range r.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+type memoryAPIKeys struct{ *Memory }
+
+func (r memoryAPIKeys) Create(key *model.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.apiKeys {
+		if other.Prefix == key.Prefix {
+			return ErrDuplicate
+		}
+	}
+	key.ID = r.id()
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	r.apiKeys[key.ID] = *key
+	return nil
+}
+
+func (r memoryAPIKeys) ByPrefix(prefix string) (model.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.apiKeys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+	return model.APIKey{}, ErrNotFound
+}
+
+func (r memoryAPIKeys) List(userID uint) ([]model.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var keys []model.APIKey
+	for _, key := range r.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	newestFirst(keys, func(k model.APIKey) (time.Time, uint) { return k.CreatedAt, k.ID })
+	return keys, nil
+}
+
+func (r memoryAPIKeys) CountActive(userID uint) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, key := range r.apiKeys {
+		if key.UserID == userID && key.RevokedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryAPIKeys) Revoke(userID, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.apiKeys[id]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return ErrNotFound
+	}
+	key.RevokedAt = &at
+	r.apiKeys[id] = key
+	return nil
+}
+
+func (r memoryAPIKeys) RevokeAll(userID uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, key := range r.apiKeys {
+		if key.UserID == userID && key.RevokedAt == nil {
+			key.RevokedAt = &at
+			r.apiKeys[id] = key
+		}
+	}
+	return nil
+}
+
+func (r memoryAPIKeys) MarkUsed(id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, ok := r.apiKeys[id]; ok {
+		key.LastUsedAt = &at
+		r.apiKeys[id] = key
+	}
+	return nil
+}
+
+type memoryIdentities struct{ *Memory }
+
+func (r memoryIdentities) SaveLoginState(state model.OIDCLoginState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.loginStates[state.State]; ok {
+		return ErrDuplicate
+	}
+	r.loginStates[state.State] = state
+	return nil
+}
+
+func (r memoryIdentities) TakeLoginState(provider, state string) (model.OIDCLoginState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.loginStates[state]
+	if !ok || row.Provider != provider {
+		return model.OIDCLoginState{}, ErrNotFound
+	}
+	delete(r.loginStates, state)
+	return row, nil
+}
+
+func (r memoryIdentities) ByProviderSubject(provider, subject string) (model.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return model.UserIdentity{}, ErrNotFound
+}
+
+func (r memoryIdentities) List(userID uint) ([]model.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.list(userID), nil
+}
+
+func (r memoryIdentities) list(userID uint) []model.UserIdentity {
+	var identities []model.UserIdentity
+	for _, id := range sortedKeys(r.identities) {
+		if identity := r.identities[id]; identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities
+}
+
+func (r memoryIdentities) Link(identity *model.UserIdentity, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.identities {
+		if other.Provider == identity.Provider && other.Subject == identity.Subject {
+			return ErrDuplicate
+		}
+	}
+	if user != nil {
+		if err := (memoryUsers{r.Memory}).create(user); err != nil {
+			return err
+		}
+		identity.UserID = user.UserID
+	}
+	identity.ID = r.id()
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+	r.identities[identity.ID] = *identity
+	return nil
+}
+
+func (r memoryIdentities) Unlink(userID uint, provider string, keepLast bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	identities := r.list(userID)
+	for _, identity := range identities {
+		if identity.Provider != provider {
+			continue
+		}
+		if keepLast && len(identities) == 1 {
+			return ErrLastIdentity
+		}
+		delete(r.identities, identity.ID)
+		return nil
+	}
+	return ErrNotFound
+}
+
+type memoryAccounts struct{ *Memory }
+
+func (r memoryAccounts) ScheduleDeletion(userID uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userID]
+	if !ok || user.DeletionScheduledAt != nil || user.AnonymizedAt != nil {
+		return ErrNotFound
+	}
+	user.DeletionScheduledAt = &at
+	r.users[userID] = user
+	return nil
+}
+
+func (r memoryAccounts) CancelDeletion(userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userID]
+	if !ok || user.DeletionScheduledAt == nil || user.AnonymizedAt != nil {
+		return ErrNotFound
+	}
+	user.DeletionScheduledAt = nil
+	r.users[userID] = user
+	return nil
+}
+
+func (r memoryAccounts) DueForDeletion(now time.Time) ([]uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []uint
+	for _, id := range sortedKeys(r.users) {
+		user := r.users[id]
+		if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(now) && user.AnonymizedAt == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r memoryAccounts) Anonymize(userID uint, now time.Time) (model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userID]
+	if !ok {
+		return model.User{}, ErrNotFound
+	}
+	if user.AnonymizedAt != nil {
+		return user, nil
+	}
+
+	anonymized := user
+	anonymized.Name, anonymized.Email, anonymized.Password = "Deleted user", anonymousEmail(userID), ""
+	anonymized.Phone, anonymized.PhoneVerified = "", false
+	anonymized.PendingEmail, anonymized.PendingPhone = "", ""
+	anonymized.TOTPSecret, anonymized.TOTPEnabled, anonymized.TOTPLastStep = "", false, 0
+	anonymized.AnonymizedAt = &now
+	r.users[userID] = anonymized
+
+	for id, identity := range r.identities {
+		if identity.UserID == userID {
+			delete(r.identities, id)
+		}
+	}
+	for id, key := range r.apiKeys {
+		if key.UserID == userID {
+			delete(r.apiKeys, id)
+		}
+	}
+	delete(r.recoveryCodes, userID)
+	for _, session := range r.sessions {
+		if session.UserID == userID {
+			(memorySessions{r.Memory}).revoke(session)
+		}
+	}
+	for key, otp := range r.otps {
+		if slices.Contains(recipients(user), otp.Email) {
+			delete(r.otps, key)
+		}
+	}
+	delete(r.pending, user.Email)
+	return user, nil
+}
+
+func (r memoryAccounts) PersonalData(userID uint) (PersonalData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userID]
+	if !ok {
+		return PersonalData{}, ErrNotFound
+	}
+	data := PersonalData{User: user, Identities: (memoryIdentities{r.Memory}).list(userID)}
+	for _, id := range sortedKeys(r.sessions) {
+		if session := r.sessions[id]; session.UserID == userID {
+			data.Sessions = append(data.Sessions, session)
+		}
+	}
+	for _, id := range sortedKeys(r.apiKeys) {
+		if key := r.apiKeys[id]; key.UserID == userID {
+			data.APIKeys = append(data.APIKeys, key)
+		}
+	}
+
+	leagues, tournaments := map[uint]bool{}, map[uint]bool{}
+	for _, id := range sortedKeys(r.teams) {
+		if team := r.teams[id]; team.PlayerID == userID && !team.DeletedAt.Valid {
+			data.LeagueTeams = append(data.LeagueTeams, team)
+			leagues[team.LeagueID] = true
+		}
+	}
+	for _, id := range sortedKeys(r.teamsA) {
+		if team := r.teamsA[id]; team.PlayerID == userID && !team.DeletedAt.Valid {
+			data.TeamsA = append(data.TeamsA, team)
+			tournaments[team.TournamentID] = true
+		}
+	}
+	for _, id := range sortedKeys(r.teamsB) {
+		if team := r.teamsB[id]; team.PlayerID == userID && !team.DeletedAt.Valid {
+			data.TeamsB = append(data.TeamsB, team)
+			tournaments[team.TournamentID] = true
+		}
+	}
+	for _, id := range sortedKeys(r.leagues) {
+		if league := r.leagues[id]; leagues[id] && !league.DeletedAt.Valid {
+			data.Leagues = append(data.Leagues, league)
+		}
+	}
+	for _, id := range sortedKeys(r.tournaments) {
+		if tournament := r.tournaments[id]; tournaments[id] && !tournament.DeletedAt.Valid {
+			data.Tournaments = append(data.Tournaments, tournament)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,345 @@
+// Package repository defines the data access interfaces used by the
+// handlers, with GORM implementations for production and in-memory fakes
+// for tests and local runs.
+//
+// Lookups return ErrNotFound when nothing matches, whatever the backend, so
+// callers never depend on GORM errors.
+package repository
+
+import (
+	"errors"
+	"gaming/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNotFound is returned when no record matches.
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when a unique value, such as an email, is already taken.
+	ErrDuplicate = errors.New("record already exists")
+	// ErrParentDeleted is returned when restoring a record whose parent,
+	// such as the league of a team, is deleted.
+	ErrParentDeleted = errors.New("parent record is deleted")
+	// ErrVersionConflict is returned when updating a record that was changed
+	// since the version the update was based on.
+	ErrVersionConflict = errors.New("record was changed by another request")
+	// ErrLastIdentity is returned when unlinking the only identity of a user
+	// who has to keep one.
+	ErrLastIdentity = errors.New("last identity of the user")
+)
+
+// UserRepo stores users, their pending signups and their 2FA recovery codes.
+type UserRepo interface {
+	ByID(id uint) (model.User, error)
+	// ByEmail and EmailTaken ignore case, so that one address cannot end up
+	// on two accounts. When several users that signed up before emails were
+	// lowercased match, ByEmail returns the first one created.
+	ByEmail(email string) (model.User, error)
+	EmailTaken(email string) (bool, error)
+	Create(user *model.User) error
+	// Update sets the given columns of the user.
+	Update(id uint, fields map[string]any) error
+	// ChangeEmail replaces the email and clears the pending email. It returns
+	// ErrDuplicate when another user has the email.
+	ChangeEmail(id uint, email string) error
+
+	// SavePendingSignup stores a signup awaiting OTP verification, replacing
+	// an earlier one for the same email.
+	SavePendingSignup(pending model.PendingSignup) error
+	// CompleteSignup creates the user from the pending signup of the email
+	// and removes the pending signup.
+	CompleteSignup(email string) (model.User, error)
+
+	// AdvanceTOTPStep records step as the last accepted TOTP step unless the
+	// same or a later step was already accepted, and reports whether it did.
+	AdvanceTOTPStep(id uint, step int64) (bool, error)
+	// EnableTOTP turns 2FA on and replaces the recovery codes with the hashes.
+	EnableTOTP(id uint, step int64, recoveryCodeHashes []string) error
+	// DisableTOTP turns 2FA off and deletes the secret and recovery codes.
+	DisableTOTP(id uint) error
+	// UseRecoveryCode marks the unused recovery code with the hash as used
+	// and reports whether there was one.
+	UseRecoveryCode(id uint, codeHash string) (bool, error)
+}
+
+// LeagueRepo stores leagues. Leagues are returned with their teams.
+// Deleted leagues and teams are kept, but only Restore can see them.
+//
+// Updates of leagues, tournaments and teams are optimistic: they name the
+// version of the record they were based on and fail with ErrVersionConflict
+// when another update came first.
+type LeagueRepo interface {
+	Create(league *model.League) error
+	ByID(id uint) (model.League, error)
+	NameTaken(name string) (bool, error)
+	// List returns a page of the leagues that match the options.
+	List(opts ListOptions) (Page[model.League], error)
+	// Update sets the given columns of the league at version and increments
+	// its version.
+	Update(id, version uint, fields map[string]any) error
+	// Delete soft deletes the league at version and its teams. Like Update,
+	// it returns ErrVersionConflict when the league has changed since.
+	Delete(id, version uint) error
+	// Restore undoes Delete, with the teams that were deleted along with the
+	// league. It returns ErrNotFound when no deleted league has the id, and
+	// ErrDuplicate when another league has taken its name since.
+	Restore(id uint) (model.League, error)
+}
+
+// TournamentRepo stores tournaments. Tournaments are returned with both
+// sides' teams. Deletes are soft, as for leagues.
+type TournamentRepo interface {
+	Create(tournament *model.Tournament) error
+	ByID(id uint) (model.Tournament, error)
+	NameTaken(name string) (bool, error)
+	// List returns a page of the tournaments that match the options.
+	List(opts ListOptions) (Page[model.Tournament], error)
+	// Update sets the given columns of the tournament at version and
+	// increments its version.
+	Update(id, version uint, fields map[string]any) error
+	// Delete soft deletes the tournament at version and the teams of both
+	// sides. Like Update, it returns ErrVersionConflict when the tournament
+	// has changed since.
+	Delete(id, version uint) error
+	// Restore undoes Delete, with the teams that were deleted along with the
+	// tournament. It returns ErrNotFound when no deleted tournament has the
+	// id, and ErrDuplicate when another tournament has taken its name since.
+	Restore(id uint) (model.Tournament, error)
+}
+
+// Columns that competitions can be sorted by.
+const (
+	SortID        = "id"
+	SortName      = "name"
+	SortPrizePool = "prize_pool"
+	SortStartTime = "start_time"
+)
+
+// Statuses that competitions can be filtered by.
+const (
+	StatusUpcoming = "upcoming"
+	StatusStarted  = "started"
+)
+
+const (
+	// DefaultLimit is the page size when ListOptions.Limit is 0.
+	DefaultLimit = 20
+	// MaxLimit is the largest page size, whatever ListOptions.Limit asks.
+	MaxLimit = 100
+)
+
+// ListOptions filters, sorts and pages the competitions returned by List.
+// The zero value returns the first DefaultLimit competitions by id, without
+// their teams.
+type ListOptions struct {
+	Status       string    // StatusUpcoming or StatusStarted at Now, empty for both
+	Now          time.Time // when Status is evaluated
+	StartsAfter  time.Time // only competitions starting after it, unless zero
+	StartsBefore time.Time // only competitions starting before it, unless zero
+	MinPrizePool *float64
+	MaxPrizePool *float64
+	Game         string
+	PlayerID     uint // only competitions where this player has a team, unless 0
+
+	Sort   string // one of the Sort columns, SortID when empty
+	Desc   bool
+	Limit  int
+	Offset int     // rows to skip, ignored when After is set
+	After  *Cursor // return the rows after this one in the sort order
+
+	WithTeams bool // load the teams of the competitions
+}
+
+// limit returns the page size, bounded by MaxLimit.
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultLimit
+	}
+	return min(o.Limit, MaxLimit)
+}
+
+// sortColumn returns the column to sort by.
+func (o ListOptions) sortColumn() string {
+	if o.Sort == "" {
+		return SortID
+	}
+	return o.Sort
+}
+
+// Cursor is the position of a row in a sort order: the value of the sort
+// column, a time.Time, float64 or string, and the id that breaks ties. Value
+// is nil when sorting by id.
+type Cursor struct {
+	Value any
+	ID    uint
+}
+
+// Page is a page of a list. Next is the position of the last item, to ask
+// for the page after it, and nil on the last page.
+type Page[T any] struct {
+	Items []T
+	Next  *Cursor
+}
+
+// TeamRepo stores league teams and the A and B side teams of tournaments.
+type TeamRepo interface {
+	CreateLeagueTeam(team *model.Team) error
+	CreateTeamA(team *model.TeamA) error
+	CreateTeamB(team *model.TeamB) error
+	LeagueTeamNameTaken(name string) (bool, error)
+	TeamANameTaken(name string) (bool, error)
+	TeamBNameTaken(name string) (bool, error)
+
+	LeagueTeam(id uint) (model.Team, error)
+	TeamA(id uint) (model.TeamA, error)
+	TeamB(id uint) (model.TeamB, error)
+	// UpdateLeagueTeam, UpdateTeamA and UpdateTeamB set the given columns of
+	// the team at version and increment its version.
+	UpdateLeagueTeam(id, version uint, fields map[string]any) error
+	UpdateTeamA(id, version uint, fields map[string]any) error
+	UpdateTeamB(id, version uint, fields map[string]any) error
+	// DeleteLeagueTeam, DeleteTeamA and DeleteTeamB soft delete the team at
+	// version, or return ErrVersionConflict when it has changed since.
+	DeleteLeagueTeam(id, version uint) error
+	DeleteTeamA(id, version uint) error
+	DeleteTeamB(id, version uint) error
+	// RestoreLeagueTeam, RestoreTeamA and RestoreTeamB undo a delete. They
+	// return ErrNotFound when no deleted team has the id, ErrDuplicate when
+	// another team has taken its name since and ErrParentDeleted when its
+	// competition is deleted.
+	RestoreLeagueTeam(id uint) (model.Team, error)
+	RestoreTeamA(id uint) (model.TeamA, error)
+	RestoreTeamB(id uint) (model.TeamB, error)
+}
+
+// OTPRepo stores one-time passwords, one per email and purpose.
+type OTPRepo interface {
+	// Update runs fn on the OTP of the email and purpose while no other
+	// Update can touch it. fn receives nil when there is none, and returns
+	// the OTP to store, or nil to delete it. An error from fn is returned
+	// and nothing is changed.
+	Update(email, purpose string, fn func(current *model.OTP) (*model.OTP, error)) error
+	Delete(email, purpose string) error
+}
+
+// SessionRepo stores the sessions behind issued tokens and the token IDs
+// (jti) of revoked sessions, which stay revoked until the token expires.
+type SessionRepo interface {
+	Create(session *model.Session) error
+	// ByJTI and ByID return a session of the user.
+	ByJTI(userID uint, jti string) (model.Session, error)
+	ByID(userID, id uint) (model.Session, error)
+	// List returns every session of the user, newest first.
+	List(userID uint) ([]model.Session, error)
+	// Active returns the sessions of the user that have not expired at now,
+	// newest first.
+	Active(userID uint, now time.Time) ([]model.Session, error)
+	// Revoke revokes the token of the session and removes the session.
+	Revoke(session model.Session) error
+	// RevokeAll revokes every session of the user except the one with
+	// keepJTI, or all of them when keepJTI is empty.
+	RevokeAll(userID uint, keepJTI string) error
+	IsRevoked(jti string) (bool, error)
+	// DeleteExpired removes the sessions and revocations of tokens that
+	// expired at now, since an expired token is rejected anyway.
+	DeleteExpired(now time.Time) error
+}
+
+// APIKeyRepo stores API keys. Revoked keys are kept so they can be listed.
+type APIKeyRepo interface {
+	Create(key *model.APIKey) error
+	ByPrefix(prefix string) (model.APIKey, error)
+	// List returns the keys of the user, newest first.
+	List(userID uint) ([]model.APIKey, error)
+	// CountActive returns the number of keys of the user that are not revoked.
+	CountActive(userID uint) (int64, error)
+	// Revoke marks the key of the user as revoked at. It returns ErrNotFound
+	// when the user has no such key that is not revoked yet.
+	Revoke(userID, id uint, at time.Time) error
+	// RevokeAll marks every key of the user that is not revoked yet as
+	// revoked at.
+	RevokeAll(userID uint, at time.Time) error
+	// MarkUsed sets when the key was last used.
+	MarkUsed(id uint, at time.Time) error
+}
+
+// IdentityRepo stores the accounts at external identity providers linked to
+// users, and the pending logins at those providers.
+type IdentityRepo interface {
+	SaveLoginState(state model.OIDCLoginState) error
+	// TakeLoginState returns the login state of the provider and removes it,
+	// so that each state is used once.
+	TakeLoginState(provider, state string) (model.OIDCLoginState, error)
+
+	ByProviderSubject(provider, subject string) (model.UserIdentity, error)
+	// List returns the identities linked to the user.
+	List(userID uint) ([]model.UserIdentity, error)
+	// Link stores the identity. With a user, the user is created first and
+	// the identity linked to it, both or neither. It returns ErrDuplicate
+	// when the identity is already linked.
+	Link(identity *model.UserIdentity, user *model.User) error
+	// Unlink removes the identity of the provider from the user. With
+	// keepLast, it returns ErrLastIdentity instead of removing the only
+	// identity of the user.
+	Unlink(userID uint, provider string, keepLast bool) error
+}
+
+// AccountRepo schedules the deletion of accounts and carries it out.
+type AccountRepo interface {
+	// ScheduleDeletion sets when the account is deleted. It returns
+	// ErrNotFound unless the user exists without a deletion scheduled.
+	ScheduleDeletion(userID uint, at time.Time) error
+	// CancelDeletion clears the scheduled deletion. It returns ErrNotFound
+	// unless a deletion is scheduled and has not been carried out.
+	CancelDeletion(userID uint) error
+	// DueForDeletion returns the users whose deletion was scheduled at or
+	// before now.
+	DueForDeletion(now time.Time) ([]uint, error)
+	// Anonymize replaces the personal data in the user row with placeholders,
+	// revokes the user's sessions and deletes the identities, recovery codes,
+	// API keys, pending codes, pending signup and queued or sent emails of the
+	// user. The emails and phone numbers of the user are replaced in the audit
+	// events too. All of this happens at once, or not at all. It returns the
+	// user as it was; a user anonymized before is left as is. The memory fake
+	// has no emails or audit events to scrub.
+	Anonymize(userID uint, now time.Time) (model.User, error)
+	// PersonalData collects everything stored about the user.
+	PersonalData(userID uint) (PersonalData, error)
+}
+
+// PersonalData is everything stored about a user: the user row, what is
+// linked to it and the competitions the user has a team in.
+type PersonalData struct {
+	User        model.User
+	Identities  []model.UserIdentity
+	Sessions    []model.Session
+	APIKeys     []model.APIKey
+	LeagueTeams []model.Team
+	TeamsA      []model.TeamA
+	TeamsB      []model.TeamB
+	Leagues     []model.League
+	Tournaments []model.Tournament
+}
+
+// Repositories groups the repositories of one backend.
+type Repositories struct {
+	Users       UserRepo
+	Leagues     LeagueRepo
+	Tournaments TournamentRepo
+	Teams       TeamRepo
+	OTPs        OTPRepo
+	Sessions    SessionRepo
+	APIKeys     APIKeyRepo
+	Identities  IdentityRepo
+	Accounts    AccountRepo
+}
+
+// notFound translates the GORM not found error to ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
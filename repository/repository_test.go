@@ -0,0 +1,400 @@
+package repository_test
+
+import (
+	"errors"
+	"fmt"
+	database "gaming/database"
+	"gaming/model"
+	"gaming/repository"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// backends returns the repositories of every backend, each on fresh data, so
+// that a test checks that the in-memory fakes behave like the database.
+func backends(t *testing.T) map[string]repository.Repositories {
+	t.Helper()
+	return map[string]repository.Repositories{
+		"memory": repository.NewMemory(),
+		"gorm":   repository.NewGorm(newTestDB(t)),
+	}
+}
+
+// newTestDB returns a migrated in-memory database.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Logger = logger.Discard
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// forEach runs the test on every backend.
+func forEach(t *testing.T, test func(t *testing.T, repos repository.Repositories)) {
+	for name, repos := range backends(t) {
+		t.Run(name, func(t *testing.T) { test(t, repos) })
+	}
+}
+
+func createUser(t *testing.T, repos repository.Repositories, email string) model.User {
+	t.Helper()
+	user := model.User{Name: "Player", Email: email, Password: "hash"}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestUsers(t *testing.T) {
+	forEach(t, func(t *testing.T, repos repository.Repositories) {
+		alice := createUser(t, repos, "alice@example.com")
+		bob := createUser(t, repos, "bob@example.com")
+
+		if err := repos.Users.Create(&model.User{Name: "Other", Email: "alice@example.com"}); err == nil {
+			t.Error("Create with a taken email succeeded")
+		}
+		if got, err := repos.Users.ByEmail("alice@example.com"); err != nil || got.UserID != alice.UserID {
+			t.Errorf("ByEmail = %d, %v, want %d", got.UserID, err, alice.UserID)
+		}
+		carol := createUser(t, repos, "Carol@Example.com")
+		if got, err := repos.Users.ByEmail("carol@EXAMPLE.com"); err != nil || got.UserID != carol.UserID {
+			t.Errorf("ByEmail in another case = %d, %v, want %d", got.UserID, err, carol.UserID)
+		}
+		if taken, err := repos.Users.EmailTaken("CAROL@example.com"); err != nil || !taken {
+			t.Errorf("EmailTaken in another case = %v, %v, want true", taken, err)
+		}
+		if _, err := repos.Users.ByID(9999); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ByID of a missing user = %v, want ErrNotFound", err)
+		}
+		if err := repos.Users.ChangeEmail(bob.UserID, "alice@example.com"); !errors.Is(err, repository.ErrDuplicate) {
+			t.Errorf("ChangeEmail to a taken email = %v, want ErrDuplicate", err)
+		}
+		if err := repos.Users.ChangeEmail(bob.UserID, "robert@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if taken, err := repos.Users.EmailTaken("bob@example.com"); err != nil || taken {
+			t.Errorf("EmailTaken of the old email = %v, %v, want false", taken, err)
+		}
+	})
+}
+
+func TestLeagueVersions(t *testing.T) {
+	forEach(t, func(t *testing.T, repos repository.Repositories) {
+		league := model.League{Name: "Spring", StartTime: time.Now().Add(time.Hour)}
+		if err := repos.Leagues.Create(&league); err != nil {
+			t.Fatal(err)
+		}
+		team := model.Team{Name: "Reds", LeagueID: league.ID, PlayerID: 1}
+		if err := repos.Teams.CreateLeagueTeam(&team); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repos.Leagues.Update(league.ID, 1, map[string]any{"name": "Summer"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Leagues.Update(league.ID, 1, map[string]any{"name": "Autumn"}); !errors.Is(err, repository.ErrVersionConflict) {
+			t.Errorf("Update at a stale version = %v, want ErrVersionConflict", err)
+		}
+		got, err := repos.Leagues.ByID(league.ID)
+		if err != nil || got.Name != "Summer" || got.Version != 2 || len(got.Teams) != 1 {
+			t.Errorf("ByID = %+v, %v, want Summer at version 2 with one team", got, err)
+		}
+
+		if err := repos.Leagues.Delete(league.ID, 1); !errors.Is(err, repository.ErrVersionConflict) {
+			t.Errorf("Delete at a stale version = %v, want ErrVersionConflict", err)
+		}
+		if err := repos.Leagues.Delete(league.ID, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Teams.LeagueTeam(team.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("LeagueTeam of a deleted league = %v, want ErrNotFound", err)
+		}
+		restored, err := repos.Leagues.Restore(league.ID)
+		if err != nil || len(restored.Teams) != 1 {
+			t.Errorf("Restore = %+v, %v, want the league with its team", restored, err)
+		}
+	})
+}
+
+func TestOTPConcurrentUpdates(t *testing.T) {
+	forEach(t, func(t *testing.T, repos repository.Repositories) {
+		// Each Update stores a code unless one exists, so only one succeeds
+		// even when none existed as they all started
+		errExists := errors.New("exists")
+		const n = 5
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				errs <- repos.OTPs.Update("ada@example.com", "signup", func(current *model.OTP) (*model.OTP, error) {
+					if current != nil {
+						return nil, errExists
+					}
+					return &model.OTP{CodeHash: "hash", Exp: time.Now().Add(time.Minute)}, nil
+				})
+			}()
+		}
+		stored := 0
+		for i := 0; i < n; i++ {
+			switch err := <-errs; {
+			case err == nil:
+				stored++
+			case !errors.Is(err, errExists):
+				t.Errorf("Update = %v, want nil or the error of fn", err)
+			}
+		}
+		if stored != 1 {
+			t.Errorf("%d concurrent Updates stored a code, want 1", stored)
+		}
+	})
+}
+
+func TestSessions(t *testing.T) {
+	forEach(t, func(t *testing.T, repos repository.Repositories) {
+		now := time.Now()
+		var sessions []model.Session
+		for _, jti := range []string{"a", "b", "c"} {
+			session := model.Session{JTI: jti, UserID: 1, ExpiresAt: now.Add(time.Hour)}
+			if err := repos.Sessions.Create(&session); err != nil {
+				t.Fatal(err)
+			}
+			sessions = append(sessions, session)
+		}
+		expired := model.Session{JTI: "old", UserID: 1, ExpiresAt: now.Add(-time.Hour)}
+		if err := repos.Sessions.Create(&expired); err != nil {
+			t.Fatal(err)
+		}
+
+		if active, err := repos.Sessions.Active(1, now); err != nil || len(active) != 3 {
+			t.Errorf("Active = %d sessions, %v, want 3", len(active), err)
+		}
+		if _, err := repos.Sessions.ByID(2, sessions[0].ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ByID of another user's session = %v, want ErrNotFound", err)
+		}
+
+		if err := repos.Sessions.Revoke(sessions[0]); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, err := repos.Sessions.IsRevoked("a"); err != nil || !revoked {
+			t.Errorf("IsRevoked of a revoked session = %v, %v, want true", revoked, err)
+		}
+		if err := repos.Sessions.RevokeAll(1, "b"); err != nil {
+			t.Fatal(err)
+		}
+		for jti, want := range map[string]bool{"b": false, "c": true} {
+			if revoked, _ := repos.Sessions.IsRevoked(jti); revoked != want {
+				t.Errorf("IsRevoked(%q) = %v, want %v", jti, revoked, want)
+			}
+		}
+
+		if err := repos.Sessions.DeleteExpired(now.Add(2 * time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, _ := repos.Sessions.IsRevoked("a"); revoked {
+			t.Error("revocation of an expired token was kept")
+		}
+		if all, err := repos.Sessions.List(1); err != nil || len(all) != 0 {
+			t.Errorf("List after DeleteExpired = %d sessions, %v, want none", len(all), err)
+		}
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	forEach(t, func(t *testing.T, repos repository.Repositories) {
+		key := model.APIKey{UserID: 1, Name: "ci", Prefix: "abcd", KeyHash: "hash", Scopes: "read"}
+		if err := repos.APIKeys.Create(&key); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := repos.APIKeys.ByPrefix("abcd"); err != nil || got.ID != key.ID {
+			t.Errorf("ByPrefix = %d, %v, want %d", got.ID, err, key.ID)
+		}
+		if err := repos.APIKeys.Revoke(2, key.ID, time.Now()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Revoke of another user's key = %v, want ErrNotFound", err)
+		}
+		if err := repos.APIKeys.Revoke(1, key.ID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.APIKeys.Revoke(1, key.ID, time.Now()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("second Revoke = %v, want ErrNotFound", err)
+		}
+		if count, err := repos.APIKeys.CountActive(1); err != nil || count != 0 {
+			t.Errorf("CountActive = %d, %v, want 0", count, err)
+		}
+		if keys, err := repos.APIKeys.List(1); err != nil || len(keys) != 1 || keys[0].RevokedAt == nil {
+			t.Errorf("List = %+v, %v, want the revoked key", keys, err)
+		}
+
+		for _, k := range []model.APIKey{{UserID: 1, Prefix: "efgh"}, {UserID: 1, Prefix: "ijkl"}, {UserID: 2, Prefix: "mnop"}} {
+			k.Name, k.KeyHash, k.Scopes = "ci", "hash", "read"
+			if err := repos.APIKeys.Create(&k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repos.APIKeys.RevokeAll(1, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := repos.APIKeys.CountActive(1); err != nil || count != 0 {
+			t.Errorf("CountActive after RevokeAll = %d, %v, want 0", count, err)
+		}
+		if count, err := repos.APIKeys.CountActive(2); err != nil || count != 1 {
+			t.Errorf("CountActive of another user after RevokeAll = %d, %v, want 1", count, err)
+		}
+	})
+}
+
+func TestIdentities(t *testing.T) {
+	forEach(t, func(t *testing.T, repos repository.Repositories) {
+		state := model.OIDCLoginState{State: "s1", Provider: "google", CodeVerifier: "v", Nonce: "n", ExpiresAt: time.Now().Add(time.Minute)}
+		if err := repos.Identities.SaveLoginState(state); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Identities.TakeLoginState("github", "s1"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("TakeLoginState of another provider = %v, want ErrNotFound", err)
+		}
+		if got, err := repos.Identities.TakeLoginState("google", "s1"); err != nil || got.Nonce != "n" {
+			t.Errorf("TakeLoginState = %+v, %v", got, err)
+		}
+		if _, err := repos.Identities.TakeLoginState("google", "s1"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("second TakeLoginState = %v, want ErrNotFound", err)
+		}
+
+		user := model.User{Name: "Social", Email: "social@example.com"}
+		identity := model.UserIdentity{Provider: "google", Subject: "123"}
+		if err := repos.Identities.Link(&identity, &user); err != nil {
+			t.Fatal(err)
+		}
+		if user.UserID == 0 || identity.UserID != user.UserID {
+			t.Fatalf("Link with a new user linked user %d to %d", identity.UserID, user.UserID)
+		}
+		again := model.UserIdentity{UserID: user.UserID, Provider: "google", Subject: "123"}
+		if err := repos.Identities.Link(&again, nil); !errors.Is(err, repository.ErrDuplicate) {
+			t.Errorf("Link of a linked identity = %v, want ErrDuplicate", err)
+		}
+
+		if err := repos.Identities.Unlink(user.UserID, "google", true); !errors.Is(err, repository.ErrLastIdentity) {
+			t.Errorf("Unlink of the last identity = %v, want ErrLastIdentity", err)
+		}
+		if err := repos.Identities.Unlink(user.UserID, "github", false); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Unlink of an unlinked provider = %v, want ErrNotFound", err)
+		}
+		if err := repos.Identities.Unlink(user.UserID, "google", false); err != nil {
+			t.Fatal(err)
+		}
+		if identities, err := repos.Identities.List(user.UserID); err != nil || len(identities) != 0 {
+			t.Errorf("List after Unlink = %+v, %v", identities, err)
+		}
+	})
+}
+
+func TestAccounts(t *testing.T) {
+	forEach(t, func(t *testing.T, repos repository.Repositories) {
+		user := createUser(t, repos, "gone@example.com")
+		now := time.Now()
+
+		if err := repos.Accounts.CancelDeletion(user.UserID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("CancelDeletion without a deletion = %v, want ErrNotFound", err)
+		}
+		if err := repos.Accounts.ScheduleDeletion(user.UserID, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Accounts.ScheduleDeletion(user.UserID, now); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("second ScheduleDeletion = %v, want ErrNotFound", err)
+		}
+		if due, err := repos.Accounts.DueForDeletion(now.Add(time.Second)); err != nil || len(due) != 1 || due[0] != user.UserID {
+			t.Errorf("DueForDeletion = %v, %v, want [%d]", due, err, user.UserID)
+		}
+
+		key := model.APIKey{UserID: user.UserID, Name: "ci", Prefix: "gone", KeyHash: "hash", Scopes: "read"}
+		if err := repos.APIKeys.Create(&key); err != nil {
+			t.Fatal(err)
+		}
+		session := model.Session{JTI: "gone-jti", UserID: user.UserID, ExpiresAt: now.Add(time.Hour)}
+		if err := repos.Sessions.Create(&session); err != nil {
+			t.Fatal(err)
+		}
+		before, err := repos.Accounts.Anonymize(user.UserID, now)
+		if err != nil || before.Email != "gone@example.com" {
+			t.Fatalf("Anonymize = %+v, %v, want the user as it was", before, err)
+		}
+
+		data, err := repos.Accounts.PersonalData(user.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.User.Email == "gone@example.com" || data.User.Name != "Deleted user" || data.User.AnonymizedAt == nil {
+			t.Errorf("anonymized user = %+v", data.User)
+		}
+		if len(data.APIKeys) != 0 || len(data.Sessions) != 0 {
+			t.Errorf("API keys and sessions of an anonymized user = %+v, %+v", data.APIKeys, data.Sessions)
+		}
+		if revoked, _ := repos.Sessions.IsRevoked("gone-jti"); !revoked {
+			t.Error("the session of an anonymized user is not revoked")
+		}
+		if due, _ := repos.Accounts.DueForDeletion(now.Add(time.Second)); len(due) != 0 {
+			t.Errorf("DueForDeletion after Anonymize = %v", due)
+		}
+		if taken, _ := repos.Users.EmailTaken("gone@example.com"); taken {
+			t.Error("the email of an anonymized user is still taken")
+		}
+	})
+}
+
+func TestAnonymizeScrubsEmailsAndAudit(t *testing.T) {
+	db := newTestDB(t)
+	repos := repository.NewGorm(db)
+	user := model.User{Name: "Player", Email: "gone@example.com", Phone: "+15550100", Password: "hash"}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	id := user.UserID
+	for _, email := range []model.OutboxEmail{{To: "gone@example.com", Text: "code 123456"}, {To: "other@example.com", Text: "hello"}} {
+		if err := db.Create(&email).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := []model.AuditEvent{
+		{Action: "signup", TargetType: "user", TargetID: "Gone@Example.com"},
+		{Action: "email_changed", ActorID: id, Before: `{"email":"old@example.com"}`, After: `{"email":"gone@example.com"}`},
+		{Action: "phone_changed", ActorID: id, After: `{"phone":"+15550100","phone_verified":true}`},
+		{Action: "account_unlocked", ActorID: 99, SubjectID: id, TargetType: "user", TargetID: "old@example.com"},
+		{Action: "account_unlocked", ActorID: id, SubjectID: 42, TargetType: "user", TargetID: "someone@example.com", Details: `{"note":"asked by gone@example.com"}`},
+	}
+	for i := range events {
+		if err := db.Create(&events[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repos.Accounts.Anonymize(id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var outbox []model.OutboxEmail
+	db.Find(&outbox)
+	if len(outbox) != 1 || outbox[0].To != "other@example.com" {
+		t.Errorf("outbox after Anonymize = %+v, want only the email to someone else", outbox)
+	}
+	anonymous := fmt.Sprintf("deleted-%d@deleted.invalid", id)
+	want := []model.AuditEvent{
+		{TargetID: anonymous},
+		{Before: `{"email":"` + anonymous + `"}`, After: `{"email":"` + anonymous + `"}`},
+		{After: `{"phone":"","phone_verified":true}`},
+		{TargetID: anonymous},
+		{TargetID: "someone@example.com", Details: `{"note":"asked by ` + anonymous + `"}`},
+	}
+	var got []model.AuditEvent
+	db.Order("id").Find(&got)
+	for i, event := range got {
+		if event.TargetID != want[i].TargetID || event.Before != want[i].Before || event.After != want[i].After || event.Details != want[i].Details {
+			t.Errorf("%s event after Anonymize = target %q, before %s, after %s, details %s; want %+v",
+				event.Action, event.TargetID, event.Before, event.After, event.Details, want[i])
+		}
+	}
+}
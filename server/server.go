@@ -0,0 +1,126 @@
+// Package server wires the handlers into the routes of the API.
+package server
+
+import (
+	"gaming/account"
+	"gaming/apperr"
+	"gaming/handlers/admin"
+	"gaming/handlers/leagues"
+	"gaming/handlers/result"
+	team "gaming/handlers/team"
+	"gaming/handlers/tournament"
+	"gaming/handlers/user"
+	"gaming/idempotency"
+	"gaming/jwt"
+	"gaming/logging"
+	"gaming/loginguard"
+	"gaming/otp"
+	"gaming/ratelimit"
+	"gaming/repository"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+// New returns the router of the API, with the handlers using the given
+// repositories.
+func New(repos repository.Repositories, otps *otp.Service, guard *loginguard.Guard) *gin.Engine {
+	//build the route handlers
+	auth := jwt.NewAuth(repos.Sessions, repos.APIKeys)
+	accounts := account.NewService(repos.Accounts, guard)
+	userSvc := user.NewService(repos, otps, guard, auth, accounts)
+	leagueSvc := leagues.NewService(repos.Leagues, repos.Users)
+	tournamentSvc := tournament.NewService(repos.Tournaments, repos.Users)
+	teamSvc := team.NewService(repos.Teams, repos.Leagues, repos.Tournaments, repos.Users)
+	resultSvc := result.NewService(repos.Leagues, repos.Tournaments)
+	adminSvc := admin.NewService(repos.Users, repos.Leagues, repos.Tournaments, repos.Teams, guard)
+
+	//initialize gin
+	r := gin.New()
+	// Requests are logged with an ID, and panics are logged by apperr.Recovery
+	// and answered with problem details like the handlers, as unknown routes
+	r.Use(logging.Middleware(), gin.CustomRecoveryWithWriter(io.Discard, apperr.Recovery))
+	r.NoRoute(apperr.NoRoute)
+	//auth routes are rate limited per IP, the others per user with separate read and write quotas
+	authLimit := ratelimit.Middleware(ratelimit.Auth)
+	limit := ratelimit.PerMethod(ratelimit.Read, ratelimit.Write)
+	//creating and joining replay the response to a retry with the same Idempotency-Key
+	idempotent := idempotency.Middleware()
+	//API keys can report scores with the results:submit scope
+	submitResults := jwt.AllowScopes(jwt.ScopeResultsSubmit)
+	//public keys for verifying issued tokens
+	r.GET("/.well-known/jwks.json", jwt.JWKSHandler)
+	//user authentication
+	r.POST("/user/signup", authLimit, userSvc.Signup)
+	r.POST("/user/verification", authLimit, userSvc.VerifyOTP)
+	r.POST("/user/login", authLimit, userSvc.Login)
+	r.GET("/auth/:provider/login", authLimit, userSvc.OIDCLogin)
+	r.GET("/auth/:provider/callback", authLimit, userSvc.OIDCCallback)
+	r.POST("/user/login/unlock/request", authLimit, userSvc.RequestUnlock)
+	r.POST("/user/login/unlock", authLimit, userSvc.UnlockAccount)
+	r.POST("/user/password/forgot", authLimit, userSvc.ForgotPassword)
+	r.POST("/user/password/reset", authLimit, userSvc.ResetPassword)
+	//user middleware
+	r.GET("/user/profile", auth.AuthMiddleware("user"), limit, userSvc.UserProfile)
+	r.PATCH("/user/profile", auth.AuthMiddleware("user"), limit, userSvc.EditUser)
+	r.POST("/user/email", auth.AuthMiddleware("user"), limit, userSvc.RequestEmailChange)
+	r.POST("/user/email/confirm", auth.AuthMiddleware("user"), limit, userSvc.ConfirmEmailChange)
+	r.POST("/user/phone", auth.AuthMiddleware("user"), limit, userSvc.RequestPhoneVerification)
+	r.POST("/user/phone/confirm", auth.AuthMiddleware("user"), limit, userSvc.ConfirmPhoneVerification)
+	r.GET("/user/audit", auth.AuthMiddleware("user"), limit, userSvc.AuditEvents)
+	r.GET("/user/export", auth.AuthMiddleware("user"), limit, userSvc.ExportData)
+	r.POST("/user/deletion", auth.AuthMiddleware("user"), limit, userSvc.RequestDeletion)
+	r.DELETE("/user/deletion", auth.AuthMiddleware("user"), limit, userSvc.CancelDeletion)
+	r.POST("/user/logout", auth.AuthMiddleware("user"), limit, userSvc.Logout)
+	r.POST("/user/logout/all", auth.AuthMiddleware("user"), limit, userSvc.LogoutAll)
+	r.POST("/user/password/change", auth.AuthMiddleware("user"), limit, userSvc.ChangePassword)
+	r.POST("/user/2fa/enroll", auth.AuthMiddleware("user"), limit, userSvc.Enroll2FA)
+	r.POST("/user/2fa/confirm", auth.AuthMiddleware("user"), limit, userSvc.Confirm2FA)
+	r.POST("/user/2fa/disable", auth.AuthMiddleware("user"), limit, userSvc.Disable2FA)
+	r.GET("/user/identities", auth.AuthMiddleware("user"), limit, userSvc.ListIdentities)
+	r.POST("/user/identities/:provider", auth.AuthMiddleware("user"), limit, userSvc.LinkIdentity)
+	r.DELETE("/user/identities/:provider", auth.AuthMiddleware("user"), limit, userSvc.UnlinkIdentity)
+	r.POST("/user/api-keys", auth.AuthMiddleware("user"), limit, userSvc.CreateAPIKey)
+	r.GET("/user/api-keys", auth.AuthMiddleware("user"), limit, userSvc.ListAPIKeys)
+	r.DELETE("/user/api-keys/:id", auth.AuthMiddleware("user"), limit, userSvc.RevokeAPIKey)
+	r.GET("/user/sessions", auth.AuthMiddleware("user"), limit, userSvc.ListSessions)
+	r.DELETE("/user/sessions/:id", auth.AuthMiddleware("user"), limit, userSvc.RevokeSession)
+	r.POST("/user/leagues", auth.AuthMiddleware("user"), limit, idempotent, leagueSvc.CreateLeagues)
+	r.GET("/user/leagues", auth.AuthMiddleware("user"), limit, leagueSvc.ViewLeagues)
+	r.GET("/user/leagues/:id", auth.AuthMiddleware("user"), limit, leagueSvc.GetLeague)
+	r.PATCH("/user/leagues/:id", auth.AuthMiddleware("user"), limit, leagueSvc.UpdateLeague)
+	r.DELETE("/user/leagues/:id", auth.AuthMiddleware("user"), limit, leagueSvc.DeleteLeague)
+	r.POST("/user/league/team", auth.AuthMiddleware("user"), limit, idempotent, teamSvc.CreateTeam)
+	r.GET("/user/league/team/:id", auth.AuthMiddleware("user"), limit, teamSvc.GetTeam)
+	r.PATCH("/user/league/team/:id", auth.AuthMiddleware("user"), limit, teamSvc.UpdateTeam)
+	r.DELETE("/user/league/team/:id", auth.AuthMiddleware("user"), limit, teamSvc.DeleteTeam)
+	r.PUT("/user/league/team/:id/score", submitResults, auth.AuthMiddleware("user"), limit, teamSvc.ReportScore)
+	r.POST("/user/leagues/join", auth.AuthMiddleware("user"), limit, idempotent, leagueSvc.JoinLeague)
+	r.POST("/user/tournament", auth.AuthMiddleware("user"), limit, idempotent, tournamentSvc.CreateTournament)
+	r.GET("/user/tournament", auth.AuthMiddleware("user"), limit, tournamentSvc.ViewTournaments)
+	r.GET("/user/tournament/:id", auth.AuthMiddleware("user"), limit, tournamentSvc.GetTournament)
+	r.PATCH("/user/tournament/:id", auth.AuthMiddleware("user"), limit, tournamentSvc.UpdateTournament)
+	r.DELETE("/user/tournament/:id", auth.AuthMiddleware("user"), limit, tournamentSvc.DeleteTournament)
+	r.POST("/user/tournament/teamA", auth.AuthMiddleware("user"), limit, idempotent, teamSvc.CreateTeamA)
+	r.GET("/user/tournament/teamA/:id", auth.AuthMiddleware("user"), limit, teamSvc.GetTeamA)
+	r.PATCH("/user/tournament/teamA/:id", auth.AuthMiddleware("user"), limit, teamSvc.UpdateTeamA)
+	r.DELETE("/user/tournament/teamA/:id", auth.AuthMiddleware("user"), limit, teamSvc.DeleteTeamA)
+	r.PUT("/user/tournament/teamA/:id/score", submitResults, auth.AuthMiddleware("user"), limit, teamSvc.ReportScoreA)
+	r.POST("/user/tournament/teamB", auth.AuthMiddleware("user"), limit, idempotent, teamSvc.CreateTeamB)
+	r.GET("/user/tournament/teamB/:id", auth.AuthMiddleware("user"), limit, teamSvc.GetTeamB)
+	r.PATCH("/user/tournament/teamB/:id", auth.AuthMiddleware("user"), limit, teamSvc.UpdateTeamB)
+	r.DELETE("/user/tournament/teamB/:id", auth.AuthMiddleware("user"), limit, teamSvc.DeleteTeamB)
+	r.PUT("/user/tournament/teamB/:id/score", submitResults, auth.AuthMiddleware("user"), limit, teamSvc.ReportScoreB)
+	r.POST("user/tournament/join", auth.AuthMiddleware("user"), limit, idempotent, tournamentSvc.JoinTournament)
+	r.GET("/user/leagues/result", auth.AuthMiddleware("user"), limit, resultSvc.LeagueResult)
+	r.GET("/user/leagues/price", auth.AuthMiddleware("user"), limit, resultSvc.PriceDistribution)
+	r.GET("/user/tournament/result", auth.AuthMiddleware("user"), limit, resultSvc.TournamentResult)
+	r.GET("/user/tournament/price", auth.AuthMiddleware("user"), limit, resultSvc.TournamentPriceDistribution)
+	//admin routes
+	r.GET("/admin/audit", auth.AuthMiddleware("admin"), limit, adminSvc.AuditEvents)
+	r.GET("/admin/locked-accounts", auth.AuthMiddleware("admin"), limit, adminSvc.LockedAccounts)
+	r.POST("/admin/locked-accounts/unlock", auth.AuthMiddleware("admin"), limit, adminSvc.UnlockAccount)
+	r.POST("/admin/restore/:kind/:id", auth.AuthMiddleware("admin"), limit, adminSvc.RestoreRecord)
+
+	return r
+}
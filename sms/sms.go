@@ -0,0 +1,96 @@
+// Package sms sends text messages such as phone verification codes.
+//
+// Delivery goes through a Sender so a real provider can be plugged in; the
+// FakeSender keeps messages in memory for tests and local runs.
+package sms
+
+import (
+	"context"
+	"fmt"
+	"gaming/config"
+	"gaming/logging"
+	"sync"
+)
+
+// Message is a single text message.
+type Message struct {
+	To   string // phone number in E.164 format
+	Body string
+}
+
+// Sender delivers a text message.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default is the sender used by the handlers.
+var Default Sender = NewFakeSender()
+
+// FromConfig builds a sender from the config.
+//
+// The log driver writes every message to the application log, which is
+// handy in development but leaks codes, so never use it in production.
+func FromConfig(cfg config.SMS) (Sender, error) {
+	switch cfg.Driver {
+	case "", "fake":
+		return NewFakeSender(), nil
+	case "log":
+		return LogSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown sms driver %q", cfg.Driver)
+	}
+}
+
+// FakeSender keeps sent messages in memory instead of delivering them.
+type FakeSender struct {
+	mu       sync.Mutex
+	messages []Message
+
+	// Err, when set, is returned by Send to simulate a failing provider.
+	Err error
+}
+
+// NewFakeSender returns an empty FakeSender.
+func NewFakeSender() *FakeSender {
+	return &FakeSender{}
+}
+
+// Send records the message.
+func (f *FakeSender) Send(ctx context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far.
+func (f *FakeSender) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.messages...)
+}
+
+// Last returns the most recent message sent to the number.
+func (f *FakeSender) Last(to string) (Message, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		if f.messages[i].To == to {
+			return f.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// LogSender writes messages to the log instead of delivering them.
+type LogSender struct{}
+
+// Send logs the message at debug level, with the number masked. The body is
+// logged as is, so the driver is for development only.
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	logging.FromContext(ctx).Debug("sms", "to", msg.To, "body", msg.Body)
+	return nil
+}
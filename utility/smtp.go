@@ -1,28 +1,18 @@
 package utility
 
 import (
-	"fmt"
-	"net/smtp"
+	"gaming/mailer"
+	"log/slog"
+	"time"
 )
 
-func SendOTPByEmail(Email, Otp string) {
-	auth := smtp.PlainAuth(
-		"rcdyr",
-		"sonusuni2255@gmail.com",
-		"ggnhnsxxsnvvonmm",
-		"smtp.gmail.com",
-	)
-
-	msg := []byte(Otp)
-
-	err := smtp.SendMail(
-		"smtp.gmail.com:587",
-		auth,
-		"sonusuni2255@gmail.com",
-		[]string{Email},
-		msg,
-	)
-	if err != nil {
-		fmt.Println(err)
+// SendOTPByEmail queues an email with the OTP, valid for ttl, in the locale for delivery by the mail outbox
+func SendOTPByEmail(Email, Otp, locale string, ttl time.Duration) {
+	data := struct {
+		Code      string
+		ExpiresIn time.Duration
+	}{Otp, ttl}
+	if err := mailer.SendTemplate(Email, locale, mailer.TemplateOTP, data); err != nil {
+		slog.Error("failed to queue otp email", "error", err)
 	}
 }
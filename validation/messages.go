@@ -0,0 +1,149 @@
+package validation
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is used when the client accepts none of the Languages.
+const DefaultLanguage = "en"
+
+// Languages are the languages that messages are translated to.
+var Languages = []string{"en", "es", "fr"}
+
+// messages holds, per language, the message of each rule. {param} is replaced
+// by the parameter of the rule, e.g. the 8 of min=8. Rules whose message
+// differs for text, such as min and max, have a ".text" variant.
+var messages = map[string]map[string]string{
+	"en": {
+		"required":  "is required",
+		"email":     "must be a valid email",
+		"e164":      "must be a phone number in international format, e.g. +14155552671",
+		"min":       "must be at least {param}",
+		"min.text":  "must be at least {param} characters",
+		"max":       "must be at most {param}",
+		"max.text":  "must be at most {param} characters",
+		"gt":        "must be greater than {param}",
+		"lt":        "must be less than {param}",
+		"oneof":     "must be one of {param}",
+		"excluded":  "cannot be used with {param}",
+		"future":    "must be in the future",
+		"money":     "must be an amount between 0 and 1000000000 with at most two decimals",
+		"name":      "may only contain letters, digits, spaces and . ' & _ -, and must start with a letter or digit",
+		"type":      "must be a {param}",
+		"unknown":   "is not a field that can be set",
+		"invalid":   "is invalid",
+		"t.string":  "string",
+		"t.number":  "number",
+		"t.boolean": "boolean",
+		"t.list":    "list",
+		"t.object":  "object",
+	},
+	"es": {
+		"required":  "es obligatorio",
+		"email":     "debe ser un correo electrónico válido",
+		"e164":      "debe ser un teléfono en formato internacional, p. ej. +14155552671",
+		"min":       "debe ser al menos {param}",
+		"min.text":  "debe tener al menos {param} caracteres",
+		"max":       "debe ser como máximo {param}",
+		"max.text":  "debe tener como máximo {param} caracteres",
+		"gt":        "debe ser mayor que {param}",
+		"lt":        "debe ser menor que {param}",
+		"oneof":     "debe ser uno de {param}",
+		"excluded":  "no se puede usar con {param}",
+		"future":    "debe estar en el futuro",
+		"money":     "debe ser un importe entre 0 y 1000000000 con dos decimales como máximo",
+		"name":      "solo puede contener letras, dígitos, espacios y . ' & _ -, y debe empezar por una letra o un dígito",
+		"type":      "debe ser de tipo {param}",
+		"unknown":   "no es un campo que se pueda establecer",
+		"invalid":   "no es válido",
+		"t.string":  "texto",
+		"t.number":  "número",
+		"t.boolean": "booleano",
+		"t.list":    "lista",
+		"t.object":  "objeto",
+	},
+	"fr": {
+		"required":  "est obligatoire",
+		"email":     "doit être une adresse e-mail valide",
+		"e164":      "doit être un numéro au format international, par ex. +14155552671",
+		"min":       "doit être au moins {param}",
+		"min.text":  "doit contenir au moins {param} caractères",
+		"max":       "doit être au plus {param}",
+		"max.text":  "doit contenir au plus {param} caractères",
+		"gt":        "doit être supérieur à {param}",
+		"lt":        "doit être inférieur à {param}",
+		"oneof":     "doit être l'une des valeurs {param}",
+		"excluded":  "ne peut pas être utilisé avec {param}",
+		"future":    "doit être dans le futur",
+		"money":     "doit être un montant entre 0 et 1000000000 avec au plus deux décimales",
+		"name":      "ne peut contenir que des lettres, des chiffres, des espaces et . ' & _ -, et doit commencer par une lettre ou un chiffre",
+		"type":      "doit être de type {param}",
+		"unknown":   "n'est pas un champ modifiable",
+		"invalid":   "n'est pas valide",
+		"t.string":  "texte",
+		"t.number":  "nombre",
+		"t.boolean": "booléen",
+		"t.list":    "liste",
+		"t.object":  "objet",
+	},
+}
+
+// aliases are rules described by the message of another rule.
+var aliases = map[string]string{"gte": "min", "lte": "max"}
+
+// Message describes a failed rule in lang. text tells whether the field is a
+// string, for rules such as min that count characters in strings.
+func Message(lang, rule, param string, text bool) string {
+	catalog, ok := messages[lang]
+	if !ok {
+		catalog = messages[DefaultLanguage]
+	}
+	if alias, ok := aliases[rule]; ok {
+		rule = alias
+	}
+	message, ok := catalog[rule+".text"]
+	if !ok || !text {
+		message, ok = catalog[rule]
+	}
+	if !ok {
+		return catalog["invalid"]
+	}
+	switch rule {
+	case "oneof":
+		param = strings.ReplaceAll(param, " ", ", ")
+	case "type":
+		param = catalog["t."+param]
+	}
+	return strings.ReplaceAll(message, "{param}", param)
+}
+
+// Language picks the best of the Languages for an Accept-Language header,
+// e.g. "fr-CH, fr;q=0.9, en;q=0.8", or DefaultLanguage.
+func Language(acceptLanguage string) string {
+	best, bestQ := DefaultLanguage, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q = parseQuality(value)
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		for _, lang := range Languages {
+			if base == lang && q > bestQ {
+				best, bestQ = lang, q
+			}
+		}
+	}
+	return best
+}
+
+// parseQuality parses the q value of a language range, e.g. "0.8". An invalid
+// value counts as 0 so that the range is ignored.
+func parseQuality(value string) float64 {
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0
+	}
+	return q
+}
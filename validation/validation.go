@@ -0,0 +1,76 @@
+// Package validation registers the rules used in the binding tags of request
+// DTOs and describes failed rules in the language of the client.
+//
+// Besides the built-in rules of go-playground/validator it adds:
+//
+//	future  a time after now
+//	money   a non-negative amount with at most two decimals, up to MaxMoney
+//	name    a display name: letters, digits, spaces and . ' & _ -, starting
+//	        with a letter or digit and not ending with a space
+package validation
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// MaxMoney is the largest amount accepted by the money rule.
+const MaxMoney = 1_000_000_000
+
+var namePattern = regexp.MustCompile(`^[\p{L}\p{N}](?:[\p{L}\p{N} .'&_-]*[\p{L}\p{N}.'&_-])?$`)
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	// Name fields in validation errors as the client sent them
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			name = field.Tag.Get("form")
+		}
+		return name
+	})
+	v.RegisterValidation("future", isFuture)
+	v.RegisterValidation("money", isMoney)
+	v.RegisterValidation("name", isName)
+}
+
+// isFuture accepts a time.Time that is after now.
+func isFuture(fl validator.FieldLevel) bool {
+	t, ok := fl.Field().Interface().(time.Time)
+	return ok && t.After(time.Now())
+}
+
+// isMoney accepts a non-negative amount with at most two decimals.
+func isMoney(fl validator.FieldLevel) bool {
+	var amount float64
+	switch fl.Field().Kind() {
+	case reflect.Float32, reflect.Float64:
+		amount = fl.Field().Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		amount = float64(fl.Field().Int())
+	default:
+		return false
+	}
+	if amount < 0 || amount > MaxMoney || math.IsNaN(amount) {
+		return false
+	}
+	cents := amount * 100
+	return math.Abs(cents-math.Round(cents)) < 1e-6
+}
+
+// isName accepts a display name, see the package comment.
+func isName(fl validator.FieldLevel) bool {
+	return namePattern.MatchString(fl.Field().String())
+}
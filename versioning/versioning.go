@@ -0,0 +1,63 @@
+// Package versioning exposes the version of competitions and teams over HTTP
+// for optimistic concurrency control.
+//
+// Responses carry the version of the record as a strong ETag. A client that
+// sends it back in If-Match with an edit gets 412 Precondition Failed when
+// the record has changed in the meantime, instead of overwriting the other
+// change. Edits without If-Match are still refused when another edit lands
+// between their read and their write.
+package versioning
+
+import (
+	"gaming/apperr"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrMismatch is the problem of an edit based on an outdated version.
+var ErrMismatch = apperr.PreconditionFailed("version_mismatch", "the record has changed since it was read, fetch it again and retry")
+
+// ErrRequired is the problem of an edit that must send If-Match and did not.
+var ErrRequired = apperr.PreconditionRequired("version_required", "send the ETag of the record you read in If-Match")
+
+// ETag returns the entity tag of a version of a record, e.g. "3".
+func ETag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// SetETag sends the version of the record in the ETag header.
+func SetETag(c *gin.Context, version uint) {
+	c.Header("ETag", ETag(version))
+}
+
+// CheckIfMatch aborts with ErrMismatch and returns false unless the request
+// has no If-Match header, or one that is "*" or lists the tag of version.
+// Weak tags never match, as If-Match uses the strong comparison.
+func CheckIfMatch(c *gin.Context, version uint) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return true
+	}
+	want := ETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == want {
+			return true
+		}
+	}
+	apperr.Abort(c, ErrMismatch)
+	return false
+}
+
+// RequireIfMatch is CheckIfMatch for edits that must not be made blindly,
+// such as reported results: it also aborts with ErrRequired when the request
+// has no If-Match header or "*".
+func RequireIfMatch(c *gin.Context, version uint) bool {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		apperr.Abort(c, ErrRequired)
+		return false
+	}
+	return CheckIfMatch(c, version)
+}